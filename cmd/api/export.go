@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/export"
+)
+
+// exportData writes a dataset to a file or stdout. Filters follow the flags
+// as key=value pairs using the GET /items parameter names, for example:
+//
+//	api export -dataset items -format csv -o items.csv brand=radley min_price=10
+func exportData(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	datasetName := flags.String("dataset", "items", "items, photos, price_history or runs")
+	formatName := flags.String("format", "ndjson", "csv, ndjson or parquet")
+	output := flags.String("o", "", "output file (default stdout)")
+	flags.Parse(args)
+
+	dataset, err := export.ParseDataset(*datasetName)
+	if err != nil {
+		exitUsage(err)
+	}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		exitUsage(err)
+	}
+	filters := url.Values{}
+	for _, arg := range flags.Args() {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			exitUsage(fmt.Errorf("filter %q is not key=value", arg))
+		}
+		filters.Add(key, value)
+	}
+	q, err := database.ParseItemQuery(filters)
+	if err != nil {
+		exitUsage(err)
+	}
+	q.WorkspaceID = database.AllWorkspaces
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	err = export.Write(context.Background(), db, out, dataset, format, q)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
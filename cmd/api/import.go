@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/importer"
+)
+
+// importFiles loads saved catalog responses (JSON or NDJSON files, "-" for
+// stdin) into the database under a topic a workspace sees, for example:
+//
+//	api import -topic "new look" -domain co.uk -workspace sales responses/*.json
+func importFiles(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	topic := flags.String("topic", "", "topic the items are stored under (required)")
+	domain := flags.String("domain", database.DefaultDomain, "Vinted domain the responses came from")
+	workspace := flags.String("workspace", "default", "workspace that sees the topic")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	if *topic == "" {
+		exitUsage(fmt.Errorf("import needs -topic"))
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		exitUsage(fmt.Errorf("import needs at least one file, or - for stdin"))
+	}
+
+	db := database.New()
+	defer db.Close()
+	ctx := context.Background()
+	ws := findWorkspace(ctx, db, *workspace)
+
+	imp := importer.New(db, *topic, *domain)
+	for _, path := range paths {
+		imp.ImportFile(path)
+	}
+	report := imp.Report()
+	if report.TopicID != 0 {
+		if err := db.AddWorkspaceTopic(ctx, ws.ID, report.TopicID); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		fmt.Printf("Imported %d files (%d responses) into %q:\n", report.Files, report.Responses, *topic)
+		fmt.Printf("  inserted  %d\n", report.Inserted)
+		fmt.Printf("  updated   %d\n", report.Updated)
+		fmt.Printf("  rejected  %d\n", report.Rejected)
+		for _, err := range report.Errors {
+			fmt.Printf("  error     %s\n", err)
+		}
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
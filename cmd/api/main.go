@@ -2,10 +2,49 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"vinted-scraper/internal/server"
 )
 
+const usage = `usage: api [command] [flags]
+
+commands:
+  serve       run the HTTP server (default)
+  prune       remove data outside the retention policy
+  export      write items, photos, price history or runs as csv, ndjson or parquet
+  import      load saved catalog responses into the database
+  keys        create, list or revoke API keys
+  workspaces  create or list workspaces
+`
+
 func main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve()
+	case "prune":
+		prune(args)
+	case "export":
+		exportData(args)
+	case "import":
+		importFiles(args)
+	case "keys":
+		keys(args)
+	case "workspaces":
+		workspaces(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+func serve() {
 
 	server := server.NewServer()
 
@@ -14,3 +53,9 @@ func main() {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
 }
+
+// exitUsage reports a bad invocation and exits with status 2.
+func exitUsage(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
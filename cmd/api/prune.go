@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"vinted-scraper/internal/database"
+)
+
+// prune runs the retention policy once. Flags default to the RETENTION_*
+// environment used by the server's background job.
+func prune(args []string) {
+	policy, err := database.RetentionPolicyFromEnv()
+	if err != nil {
+		exitUsage(err)
+	}
+
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be removed without removing it")
+	itemDays := flags.Int("item-days", int(policy.ItemMaxAge/(24*time.Hour)), "remove items unseen for this many days (0 keeps them)")
+	priceDays := flags.Int("price-history-days", int(policy.PriceHistoryMaxAge/(24*time.Hour)), "remove price history older than this many days (0 keeps it)")
+	orphans := flags.Bool("orphans", policy.Orphans, "remove orphaned photos and thumbnails")
+	flags.Parse(args)
+
+	policy = database.RetentionPolicy{
+		ItemMaxAge:         time.Duration(*itemDays) * 24 * time.Hour,
+		PriceHistoryMaxAge: time.Duration(*priceDays) * 24 * time.Hour,
+		Orphans:            *orphans,
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	report, err := db.Prune(context.Background(), policy, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	verb := "Removed"
+	if report.DryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s:\n", verb)
+	fmt.Printf("  items          %d\n", report.Items)
+	fmt.Printf("  price history  %d\n", report.PriceHistory)
+	fmt.Printf("  thumbnails     %d\n", report.Thumbnails)
+	fmt.Printf("  photos         %d\n", report.Photos)
+}
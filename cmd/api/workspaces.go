@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"vinted-scraper/internal/database"
+)
+
+const workspacesUsage = `usage: api workspaces <create|list> [flags]
+
+  create NAME
+  list
+`
+
+// workspaces manages the workspaces, for example to give a team its own
+// topics, saved searches, webhooks and keys:
+//
+//	api workspaces create sales
+//	api keys create -workspace sales -name sales-admin -role admin
+func workspaces(args []string) {
+	if len(args) == 0 {
+		exitUsage(errors.New(workspacesUsage))
+	}
+	command, args := args[0], args[1:]
+
+	db := database.New()
+	defer db.Close()
+	ctx := context.Background()
+
+	switch command {
+	case "create":
+		if len(args) != 1 {
+			exitUsage(errors.New(workspacesUsage))
+		}
+		ws, err := db.CreateWorkspace(ctx, args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created workspace %d (%s)\n", ws.ID, ws.Name)
+	case "list":
+		list, err := db.ListWorkspaces(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED")
+		for _, ws := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", ws.ID, ws.Name, ws.CreatedAt.Format(time.DateTime))
+		}
+		w.Flush()
+	default:
+		exitUsage(fmt.Errorf("unknown workspaces command %q\n\n%s", command, workspacesUsage))
+	}
+}
+
+// findWorkspace returns the workspace called name, exiting when there is
+// none.
+func findWorkspace(ctx context.Context, db database.Service, name string) database.Workspace {
+	list, err := db.ListWorkspaces(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, ws := range list {
+		if ws.Name == name {
+			return ws
+		}
+	}
+	exitUsage(fmt.Errorf("unknown workspace %q, see api workspaces list", name))
+	return database.Workspace{}
+}
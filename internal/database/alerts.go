@@ -0,0 +1,481 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	vinted_scraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// EventAlert is the kind webhooks subscribe to alerts by. Alerts are not
+// item events: they are listed with ListAlerts.
+const EventAlert EventKind = "alert"
+
+// SavedSearch is a search kept under a name, with the alert rules checked
+// against the items ingestion stores for it.
+type SavedSearch struct {
+	ID          int64  `json:"id"`
+	WorkspaceID int64  `json:"workspace_id"`
+	Name        string `json:"name"`
+	Text        string `json:"q"`
+	Order       string `json:"order"`
+	Domain      string `json:"domain"`
+	Currency    string `json:"currency"`
+	// Filters are item filters named as in ParseItemQuery, see ParseFilters.
+	Filters map[string]string `json:"filters"`
+	// Signature is the key of the search, as used by GET /v1/search.
+	Signature string      `json:"signature"`
+	Rules     []AlertRule `json:"rules"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// AlertRule raises an alert for each item of its saved search that meets
+// all of its conditions, item filters named as in ParseItemQuery: a rule
+// {"max_price": "20", "business": "false"} matches items up to 20 sold by
+// private sellers. A rule without conditions matches every item.
+type AlertRule struct {
+	ID            int64             `json:"id"`
+	SavedSearchID int64             `json:"saved_search_id"`
+	Name          string            `json:"name"`
+	Conditions    map[string]string `json:"conditions"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// Alert is an item found matching an alert rule when it was stored or its
+// price changed. An item alerts once per rule and price.
+type Alert struct {
+	ID            int64               `json:"id"`
+	RuleID        int64               `json:"rule_id"`
+	SavedSearchID int64               `json:"saved_search_id"`
+	TopicID       int64               `json:"topic_id"`
+	Price         string              `json:"price"`
+	Currency      string              `json:"currency"`
+	CreatedAt     time.Time           `json:"created_at"`
+	Item          vinted_scraper.Item `json:"item"`
+}
+
+// AlertQuery selects alerts, newest first and paged with Before, or oldest
+// first when AfterID is set, so a reader can resume from the last alert it
+// saw. Zero values mean "no filter".
+type AlertQuery struct {
+	// WorkspaceID selects the alerts of the saved searches of a workspace.
+	WorkspaceID   int64
+	SavedSearchID int64
+	TopicID       int64
+	AfterID       int64
+	Before        int64
+	Limit         int
+}
+
+// filterKeys are the ParseItemQuery parameters saved searches and alert
+// rules may filter on.
+var filterKeys = map[string]bool{
+	"brand": true, "size": true, "status": true, "seller": true,
+	"business": true, "min_price": true, "max_price": true,
+}
+
+// ParseFilters validates item filters for a saved search or alert rule and
+// returns them in canonical form.
+func ParseFilters(filters map[string]string) (url.Values, error) {
+	values := url.Values{}
+	for key, value := range filters {
+		if !filterKeys[key] {
+			return nil, fmt.Errorf("unknown filter %q", key)
+		}
+		values.Set(key, value)
+	}
+	q, err := ParseItemQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	return q.FilterValues(), nil
+}
+
+// parseFilters reads stored filters.
+func parseFilters(encoded string) (ItemQuery, error) {
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return ItemQuery{}, err
+	}
+	return ParseItemQuery(values)
+}
+
+// filterMap returns canonical filters as the map the API shows.
+func filterMap(encoded string) map[string]string {
+	filters := map[string]string{}
+	values, _ := url.ParseQuery(encoded)
+	for key := range values {
+		filters[key] = values.Get(key)
+	}
+	return filters
+}
+
+// encodeFilters stores filters in canonical form.
+func encodeFilters(filters map[string]string) (string, error) {
+	values, err := ParseFilters(filters)
+	if err != nil {
+		return "", err
+	}
+	return values.Encode(), nil
+}
+
+// matches reports whether item passes the filters of q that ParseFilters allows.
+func (q ItemQuery) matches(item vinted_scraper.Item) bool {
+	if q.Brand != "" && !strings.EqualFold(item.BrandTitle, q.Brand) {
+		return false
+	}
+	if q.Size != "" && !strings.EqualFold(item.SizeTitle, q.Size) {
+		return false
+	}
+	if q.Status != "" && !strings.EqualFold(item.Status, q.Status) {
+		return false
+	}
+	if q.SellerID != 0 && item.User.ID != q.SellerID {
+		return false
+	}
+	if q.Business != nil && item.User.Business != *q.Business {
+		return false
+	}
+	if q.MinPrice != nil || q.MaxPrice != nil {
+		price, err := strconv.ParseFloat(item.Price, 64)
+		if err != nil {
+			return false
+		}
+		if q.MinPrice != nil && price < *q.MinPrice {
+			return false
+		}
+		if q.MaxPrice != nil && price > *q.MaxPrice {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateSavedSearch stores search and its rules and returns them with
+// their ids, normalized like a SearchSignature.
+func (s *service) CreateSavedSearch(ctx context.Context, search SavedSearch) (SavedSearch, error) {
+	filters, err := encodeFilters(search.Filters)
+	if err != nil {
+		return search, err
+	}
+	sig := NewSearchSignature(search.Text, search.Order, search.Domain, search.Currency, nil)
+	search.Text, search.Domain, search.Currency = sig.Text, sig.Domain, sig.Currency
+	search.CreatedAt = time.Now().UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return search, err
+	}
+	defer tx.Rollback()
+	if search.WorkspaceID == 0 {
+		search.WorkspaceID = DefaultWorkspace
+	}
+	err = tx.QueryRowContext(ctx, `INSERT INTO saved_searches (workspace_id, name, text, search_order, domain, currency, filters, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		search.WorkspaceID, search.Name, search.Text, search.Order, search.Domain, search.Currency, filters, search.CreatedAt).Scan(&search.ID)
+	if err != nil {
+		return search, fmt.Errorf("error creating saved search: %v", err)
+	}
+	for i := range search.Rules {
+		search.Rules[i].SavedSearchID = search.ID
+		if search.Rules[i], err = insertAlertRule(ctx, tx, search.Rules[i]); err != nil {
+			return search, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return search, err
+	}
+	search.Filters = filterMap(filters)
+	search.Signature = savedSignature(search, filters)
+	if search.Rules == nil {
+		search.Rules = []AlertRule{}
+	}
+	return search, nil
+}
+
+// savedSignature returns the signature of a saved search with stored filters.
+func savedSignature(search SavedSearch, filters string) string {
+	values, _ := url.ParseQuery(filters)
+	return NewSearchSignature(search.Text, search.Order, search.Domain, search.Currency, values).String()
+}
+
+func insertAlertRule(ctx context.Context, tx *sql.Tx, rule AlertRule) (AlertRule, error) {
+	conditions, err := encodeFilters(rule.Conditions)
+	if err != nil {
+		return rule, fmt.Errorf("invalid rule %q: %v", rule.Name, err)
+	}
+	rule.CreatedAt = time.Now().UTC()
+	err = tx.QueryRowContext(ctx, `INSERT INTO alert_rules (saved_search_id, name, conditions, created_at)
+        VALUES ($1, $2, $3, $4) RETURNING id`,
+		rule.SavedSearchID, rule.Name, conditions, rule.CreatedAt).Scan(&rule.ID)
+	if err != nil {
+		return rule, fmt.Errorf("error creating alert rule: %v", err)
+	}
+	rule.Conditions = filterMap(conditions)
+	return rule, nil
+}
+
+// ListSavedSearches returns the saved searches of a workspace, or of all of
+// them for 0, with their rules, oldest first.
+func (s *service) ListSavedSearches(ctx context.Context, workspaceID int64) ([]SavedSearch, error) {
+	return s.querySavedSearches(ctx, workspaceID, 0)
+}
+
+// GetSavedSearch returns one saved search of a workspace, or of any for 0,
+// with its rules, or ErrNotFound.
+func (s *service) GetSavedSearch(ctx context.Context, workspaceID, id int64) (SavedSearch, error) {
+	searches, err := s.querySavedSearches(ctx, workspaceID, id)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	if len(searches) == 0 {
+		return SavedSearch{}, ErrNotFound
+	}
+	return searches[0], nil
+}
+
+// querySavedSearches reads the saved search id, or all of them for 0, in
+// the workspace workspaceID, or in any for 0.
+func (s *service) querySavedSearches(ctx context.Context, workspaceID, id int64) ([]SavedSearch, error) {
+	w := &whereBuilder{}
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	if id != 0 {
+		w.add("id = ?", id)
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT id, workspace_id, name, text, search_order, domain, currency, filters, created_at
+        FROM saved_searches %s ORDER BY id`, w), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	searches := []SavedSearch{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var search SavedSearch
+		var filters string
+		var createdAt nullTime
+		err := rows.Scan(&search.ID, &search.WorkspaceID, &search.Name, &search.Text, &search.Order, &search.Domain, &search.Currency, &filters, &createdAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		search.Filters = filterMap(filters)
+		search.Signature = savedSignature(search, filters)
+		search.Rules = []AlertRule{}
+		search.CreatedAt = createdAt.Time
+		index[search.ID] = len(searches)
+		searches = append(searches, search)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	w = &whereBuilder{}
+	w.addWorkspace("saved_search_id IN (SELECT id FROM saved_searches WHERE workspace_id = ?)", workspaceID)
+	if id != 0 {
+		w.add("saved_search_id = ?", id)
+	}
+	rows, err = s.db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT id, saved_search_id, name, conditions, created_at
+        FROM alert_rules %s ORDER BY id`, w), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule AlertRule
+		var conditions string
+		var createdAt nullTime
+		if err := rows.Scan(&rule.ID, &rule.SavedSearchID, &rule.Name, &conditions, &createdAt); err != nil {
+			return nil, err
+		}
+		rule.Conditions = filterMap(conditions)
+		rule.CreatedAt = createdAt.Time
+		if i, ok := index[rule.SavedSearchID]; ok {
+			searches[i].Rules = append(searches[i].Rules, rule)
+		}
+	}
+	return searches, rows.Err()
+}
+
+// DeleteSavedSearch removes a saved search of a workspace, or of any for 0,
+// with its rules and alerts, or returns ErrNotFound.
+func (s *service) DeleteSavedSearch(ctx context.Context, workspaceID, id int64) error {
+	w := &whereBuilder{}
+	w.add("id = ?", id)
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	result, err := s.db.ExecContext(ctx, "DELETE FROM saved_searches "+w.String(), w.args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddAlertRule adds rule to its saved search and returns it with its id.
+func (s *service) AddAlertRule(ctx context.Context, rule AlertRule) (AlertRule, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return rule, err
+	}
+	defer tx.Rollback()
+	if rule, err = insertAlertRule(ctx, tx, rule); err != nil {
+		return rule, err
+	}
+	return rule, tx.Commit()
+}
+
+// DeleteAlertRule removes a rule of a saved search with its alerts, or
+// returns ErrNotFound.
+func (s *service) DeleteAlertRule(ctx context.Context, savedSearchID, ruleID int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM alert_rules WHERE id = $1 AND saved_search_id = $2", ruleID, savedSearchID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LatestAlertID returns the id of the newest alert, or 0 when there is none.
+func (s *service) LatestAlertID(ctx context.Context) (int64, error) {
+	var id sql.NullInt64
+	err := s.db.QueryRowContext(ctx, "SELECT MAX(id) FROM alerts").Scan(&id)
+	return id.Int64, err
+}
+
+// ListAlerts returns the alerts selected by q with their items.
+func (s *service) ListAlerts(ctx context.Context, q AlertQuery) ([]Alert, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+	w := &whereBuilder{}
+	order := "DESC"
+	if q.AfterID != 0 {
+		w.add("alerts.id > ?", q.AfterID)
+		order = "ASC"
+	}
+	if q.Before != 0 {
+		w.add("alerts.id < ?", q.Before)
+	}
+	w.addWorkspace("alerts.saved_search_id IN (SELECT id FROM saved_searches WHERE workspace_id = ?)", q.WorkspaceID)
+	if q.SavedSearchID != 0 {
+		w.add("alerts.saved_search_id = ?", q.SavedSearchID)
+	}
+	if q.TopicID != 0 {
+		w.add("alerts.topic_id = ?", q.TopicID)
+	}
+	query := fmt.Sprintf(`
+        SELECT %s, alerts.id, alerts.rule_id, alerts.saved_search_id, alerts.topic_id,
+            alerts.price, alerts.currency, alerts.created_at
+        FROM alerts
+        JOIN Item ON Item.id = alerts.item_id
+        JOIN photos ON Item.photo_id = photos.id
+        %s
+        ORDER BY alerts.id %s
+        LIMIT %d`, itemColumns, w, order, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var alert Alert
+		var createdAt nullTime
+		alert.Item, err = scanItem(rows, &alert.ID, &alert.RuleID, &alert.SavedSearchID, &alert.TopicID,
+			&alert.Price, &alert.Currency, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		alert.CreatedAt = createdAt.Time
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// compiledRule is an alert rule with the filters of its saved search, as
+// evaluateAlerts checks them.
+type compiledRule struct {
+	id, savedSearchID int64
+	search, rule      ItemQuery
+}
+
+// evaluateAlerts records an alert for each of items, stored under topic
+// from domain as part of the ingestion transaction tx, that matches a rule
+// of a saved search for that topic, and returns how many it recorded.
+func evaluateAlerts(tx *sql.Tx, topicID int64, topic, domain string, items []vinted_scraper.Item, at time.Time) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	// Read every rule first: SQLite cannot write while rows are open.
+	rows, err := tx.Query(`
+        SELECT alert_rules.id, saved_searches.id, saved_searches.text, saved_searches.filters, alert_rules.conditions
+        FROM alert_rules
+        JOIN saved_searches ON saved_searches.id = alert_rules.saved_search_id
+        WHERE saved_searches.domain = $1
+        ORDER BY alert_rules.id`, strings.ToLower(domain))
+	if err != nil {
+		return 0, err
+	}
+	text := NewSearchSignature(topic, "", domain, "", nil).Text
+	var rules []compiledRule
+	for rows.Next() {
+		var rule compiledRule
+		var searchText, filters, conditions string
+		if err := rows.Scan(&rule.id, &rule.savedSearchID, &searchText, &filters, &conditions); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if searchText != text {
+			continue
+		}
+		if rule.search, err = parseFilters(filters); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("invalid filters of saved search %d: %v", rule.savedSearchID, err)
+		}
+		if rule.rule, err = parseFilters(conditions); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("invalid conditions of alert rule %d: %v", rule.id, err)
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	alerts := 0
+	for _, item := range items {
+		for _, rule := range rules {
+			if !rule.search.matches(item) || !rule.rule.matches(item) {
+				continue
+			}
+			result, err := tx.Exec(`INSERT INTO alerts (rule_id, saved_search_id, topic_id, item_id, price, currency, created_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7)
+                ON CONFLICT (rule_id, item_id, price) DO NOTHING`,
+				rule.id, rule.savedSearchID, topicID, item.ID, item.Price, item.Currency, at)
+			if err != nil {
+				return 0, fmt.Errorf("error recording alert of rule %d: %v", rule.id, err)
+			}
+			if n, err := result.RowsAffected(); err == nil {
+				alerts += int(n)
+			}
+		}
+	}
+	return alerts, nil
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so a leaked one is easy to recognise.
+const apiKeyPrefix = "vsk_"
+
+// Role is what an API key may do. Each role may do everything the roles
+// before it may.
+type Role string
+
+const (
+	// RoleReader may read.
+	RoleReader Role = "reader"
+	// RoleOperator may also change webhooks, saved searches and jobs.
+	RoleOperator Role = "operator"
+	// RoleAdmin may also manage API keys and read the audit log.
+	RoleAdmin Role = "admin"
+)
+
+var roles = []Role{RoleReader, RoleOperator, RoleAdmin}
+
+// ParseRole parses a role name; "" is a reader.
+func ParseRole(v string) (Role, error) {
+	if v == "" {
+		return RoleReader, nil
+	}
+	for _, role := range roles {
+		if string(role) == v {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("invalid role %q, want reader, operator or admin", v)
+}
+
+func (r Role) rank() int {
+	for i, role := range roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Allows reports whether r may do what role other may.
+func (r Role) Allows(other Role) bool {
+	return r.rank() >= other.rank() && r.rank() >= 0
+}
+
+// APIKey authenticates an API client. Only a hash of the key is stored.
+type APIKey struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// WorkspaceID is the workspace the key acts in.
+	WorkspaceID int64 `json:"workspace_id"`
+	// Key is only known, and shown, when the key is created.
+	Key string `json:"key,omitempty"`
+	// Prefix is the start of the key, to tell keys apart.
+	Prefix string `json:"prefix"`
+	// Role is what the key may do.
+	Role Role `json:"role"`
+	// RateLimit (requests per minute) and ScrapeLimit (live scrapes per
+	// hour) override the server's defaults when positive.
+	RateLimit   int        `json:"rate_limit,omitempty"`
+	ScrapeLimit int        `json:"scrape_limit,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// hashAPIKey returns the hash an API key is stored and looked up by. Keys
+// are random, so a fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey stores key with a newly generated secret and returns it with
+// its id and, this once, the secret in Key.
+func (s *service) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return key, fmt.Errorf("error generating API key: %v", err)
+	}
+	key.Key = apiKeyPrefix + hex.EncodeToString(secret)
+	key.Prefix = key.Key[:len(apiKeyPrefix)+8]
+	if key.Role == "" {
+		key.Role = RoleReader
+	}
+	if key.WorkspaceID == 0 {
+		key.WorkspaceID = DefaultWorkspace
+	}
+	key.CreatedAt = time.Now().UTC()
+	key.LastUsedAt, key.RevokedAt = nil, nil
+	err := s.db.QueryRowContext(ctx, `INSERT INTO api_keys (workspace_id, name, prefix, key_hash, role, rate_limit, scrape_limit, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		key.WorkspaceID, key.Name, key.Prefix, hashAPIKey(key.Key), key.Role, key.RateLimit, key.ScrapeLimit, key.CreatedAt).Scan(&key.ID)
+	if err != nil {
+		return key, fmt.Errorf("error creating API key: %v", err)
+	}
+	return key, nil
+}
+
+const apiKeyColumns = "id, workspace_id, name, prefix, role, rate_limit, scrape_limit, created_at, last_used_at, revoked_at"
+
+// ListAPIKeys returns the API keys of a workspace, or of all of them for 0,
+// revoked ones included, oldest first.
+func (s *service) ListAPIKeys(ctx context.Context, workspaceID int64) ([]APIKey, error) {
+	w := &whereBuilder{}
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM api_keys %s ORDER BY id", apiKeyColumns, w), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// AuthenticateAPIKey returns the unrevoked API key whose secret is key, or
+// ErrNotFound. It records when the key was used, to the minute.
+func (s *service) AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		hashAPIKey(key))
+	if err != nil {
+		return APIKey{}, err
+	}
+	found, err := scanOneAPIKey(rows)
+	if err != nil {
+		return found, err
+	}
+	now := time.Now().UTC()
+	if found.LastUsedAt == nil || now.Sub(*found.LastUsedAt) >= time.Minute {
+		if _, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = $1 WHERE id = $2", now, found.ID); err != nil {
+			return found, fmt.Errorf("error recording API key use: %v", err)
+		}
+		found.LastUsedAt = &now
+	}
+	return found, nil
+}
+
+// RevokeAPIKey stops an API key of a workspace, or of any for 0, from
+// authenticating and returns it, or returns ErrNotFound. Revoking a key twice
+// keeps the first revocation.
+func (s *service) RevokeAPIKey(ctx context.Context, workspaceID, id int64) (APIKey, error) {
+	w := &whereBuilder{}
+	w.add("id = ?", id)
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM api_keys %s", apiKeyColumns, w), w.args...)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key, err := scanOneAPIKey(rows)
+	if err != nil {
+		return key, err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("error revoking API key: %v", err)
+	}
+	rows, err = s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1", id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return scanOneAPIKey(rows)
+}
+
+// scanOneAPIKey reads the single key of rows and closes them, returning
+// ErrNotFound when there is none.
+func scanOneAPIKey(rows *sql.Rows) (APIKey, error) {
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return APIKey{}, err
+		}
+		return APIKey{}, ErrNotFound
+	}
+	return scanAPIKey(rows)
+}
+
+func scanAPIKey(rows *sql.Rows) (APIKey, error) {
+	var key APIKey
+	var createdAt, lastUsedAt, revokedAt nullTime
+	err := rows.Scan(&key.ID, &key.WorkspaceID, &key.Name, &key.Prefix, &key.Role, &key.RateLimit, &key.ScrapeLimit,
+		&createdAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return key, err
+	}
+	key.CreatedAt = createdAt.Time
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AuditEntry records a privileged action, allowed or not.
+type AuditEntry struct {
+	ID          int64 `json:"id"`
+	WorkspaceID int64 `json:"workspace_id"`
+	// APIKeyID is the key the action was taken with, 0 without one.
+	APIKeyID int64 `json:"api_key_id,omitempty"`
+	// Actor is the prefix of the API key, or who acted without one.
+	Actor string `json:"actor"`
+	// Action is the method and route, such as "DELETE /v1/keys/{id}".
+	Action    string    `json:"action"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditQuery pages the audit log newest first, optionally for one workspace
+// or API key.
+type AuditQuery struct {
+	WorkspaceID int64
+	APIKeyID    int64
+	Before      int64
+	Limit       int
+}
+
+// RecordAudit adds an entry to the audit log and returns its id.
+func (s *service) RecordAudit(ctx context.Context, entry AuditEntry) (int64, error) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if entry.WorkspaceID == 0 {
+		entry.WorkspaceID = DefaultWorkspace
+	}
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO audit_log (workspace_id, api_key_id, actor, action, path, status, request_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		entry.WorkspaceID, sql.NullInt64{Int64: entry.APIKeyID, Valid: entry.APIKeyID != 0}, entry.Actor, entry.Action, entry.Path,
+		entry.Status, nullString(entry.RequestID), entry.CreatedAt.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error recording audit entry: %v", err)
+	}
+	return id, nil
+}
+
+// ListAuditLog returns audit entries, newest first.
+func (s *service) ListAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+	w := &whereBuilder{}
+	w.addWorkspace("workspace_id = ?", q.WorkspaceID)
+	if q.APIKeyID != 0 {
+		w.add("api_key_id = ?", q.APIKeyID)
+	}
+	if q.Before != 0 {
+		w.add("id < ?", q.Before)
+	}
+	query := fmt.Sprintf(`SELECT id, workspace_id, api_key_id, actor, action, path, status, request_id, created_at
+        FROM audit_log %s ORDER BY id DESC LIMIT %d`, w, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var apiKeyID sql.NullInt64
+		var requestID sql.NullString
+		var createdAt nullTime
+		err := rows.Scan(&entry.ID, &entry.WorkspaceID, &apiKeyID, &entry.Actor, &entry.Action, &entry.Path, &entry.Status, &requestID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.APIKeyID = apiKeyID.Int64
+		entry.RequestID = requestID.String
+		entry.CreatedAt = createdAt.Time
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
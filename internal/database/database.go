@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	vinted_scraper "vinted-scraper/internal/vinted-scraper"
 
@@ -44,41 +45,221 @@ type Service interface {
 	// A prepared statement takes parameters and is safe against SQL injection.
 	Prepare(ctx context.Context, query string) (*sql.Stmt, error)
 
-	AddItems(items []vinted_scraper.Item, topic string) error
-	ExistsTopic(topic string) (int8, error)
-	GetItems(topicId int8) (items []vinted_scraper.Item, err error)
+	// AddItems upserts the items scraped from domain (DefaultDomain if empty)
+	// under topic, creating the topic if needed. Items failing ValidateItem are
+	// skipped; the result counts new, updated and rejected items, and the
+	// alerts the rules of saved searches for the topic raised.
+	AddItems(items []vinted_scraper.Item, topic string, domain string) (IngestResult, error)
+	ExistsTopic(topic string) (int64, error)
+	GetItems(topicId int64) (items []vinted_scraper.Item, err error)
+
+	// ListTopics returns the topics a workspace sees, with their item count
+	// and last successful scrape. Here and below, a workspaceID of 0 means
+	// DefaultWorkspace and AllWorkspaces means every workspace.
+	ListTopics(ctx context.Context, workspaceID int64) ([]Topic, error)
+
+	// GetTopic returns one topic a workspace sees, or ErrNotFound.
+	GetTopic(ctx context.Context, workspaceID, id int64) (Topic, error)
+
+	// CreateWorkspace, ListWorkspaces and GetWorkspace manage the
+	// workspaces; CreateWorkspace returns ErrWorkspaceExists and GetWorkspace
+	// ErrNotFound. AddWorkspaceTopic lets a workspace see a topic.
+	CreateWorkspace(ctx context.Context, name string) (Workspace, error)
+	ListWorkspaces(ctx context.Context) ([]Workspace, error)
+	GetWorkspace(ctx context.Context, id int64) (Workspace, error)
+	AddWorkspaceTopic(ctx context.Context, workspaceID, topicID int64) error
+
+	// MarkTopicRead records when the API last served a topic.
+	MarkTopicRead(ctx context.Context, topicID int64, at time.Time) error
+
+	// SaveTopicSchedule and GetTopicSchedule store and read the scheduler's
+	// state for a topic; GetTopicSchedule returns ErrNotFound.
+	SaveTopicSchedule(ctx context.Context, st TopicScheduleState) error
+	GetTopicSchedule(ctx context.Context, topicID int64) (TopicScheduleState, error)
+
+	// ListItemEvents returns the events of a topic after an event id, with
+	// their items, oldest first.
+	ListItemEvents(ctx context.Context, q EventQuery) ([]ItemEvent, error)
+
+	// LatestEventID returns the id of the newest event of a topic, or 0.
+	LatestEventID(ctx context.Context, topicID int64) (int64, error)
+
+	// GetSearch returns the last live search with a signature, or ErrNotFound.
+	GetSearch(ctx context.Context, signature string) (Search, error)
+
+	// SaveSearch stores a live search and the IDs of its items in the order
+	// Vinted returned them, for QueryItems to sort by SortRelevance.
+	SaveSearch(ctx context.Context, search Search, itemIDs []int) error
+
+	// QueryItems returns one page of stored items matching the query's filters,
+	// in the requested order. Pass the returned NextCursor to fetch the next page.
+	QueryItems(ctx context.Context, q ItemQuery) (ItemPage, error)
+
+	// SearchItems runs a ranked full-text search over the stored items.
+	SearchItems(ctx context.Context, q SearchQuery) ([]SearchResult, error)
+
+	// Prune removes the data the retention policy no longer keeps and reports
+	// how many rows went. With dryRun nothing is removed.
+	Prune(ctx context.Context, policy RetentionPolicy, dryRun bool) (PruneReport, error)
+
+	// RecordScrapeRun appends a run to the scrape audit log.
+	RecordScrapeRun(ctx context.Context, run ScrapeRun) (int64, error)
+
+	// ListScrapeRuns returns scrape runs, newest first.
+	ListScrapeRuns(ctx context.Context, q RunQuery) ([]ScrapeRun, error)
+
+	// MarketStats aggregates prices and engagement for a topic or brand.
+	MarketStats(ctx context.Context, q StatsQuery) (MarketStats, error)
+
+	// StreamItems, StreamPhotos, StreamPriceHistory and StreamScrapeRuns call
+	// fn for each row matching the filters of q without buffering the result.
+	StreamItems(ctx context.Context, q ItemQuery, fn func(StoredItem) error) error
+	StreamPhotos(ctx context.Context, q ItemQuery, fn func(ItemPhoto) error) error
+	StreamPriceHistory(ctx context.Context, q ItemQuery, fn func(PricePoint) error) error
+	StreamScrapeRuns(ctx context.Context, q ItemQuery, fn func(ScrapeRun) error) error
+
+	// CreateWebhook, ListWebhooks, GetWebhook and DeleteWebhook manage the
+	// outbound webhooks of a workspace; GetWebhook and DeleteWebhook return
+	// ErrNotFound.
+	CreateWebhook(ctx context.Context, hook Webhook) (Webhook, error)
+	ListWebhooks(ctx context.Context, workspaceID int64) ([]Webhook, error)
+	GetWebhook(ctx context.Context, workspaceID, id int64) (Webhook, error)
+	DeleteWebhook(ctx context.Context, workspaceID, id int64) error
+
+	// AdvanceWebhook and AdvanceWebhookAlerts move the event and alert
+	// cursors of a webhook forward.
+	AdvanceWebhook(ctx context.Context, id, lastEventID int64) error
+	AdvanceWebhookAlerts(ctx context.Context, id, lastAlertID int64) error
+
+	// RecordWebhookDelivery appends an attempt to the delivery log.
+	RecordWebhookDelivery(ctx context.Context, d WebhookDelivery) (int64, error)
+
+	// ListWebhookDeliveries returns the latest attempts for a webhook, newest first.
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error)
+
+	// CreateSavedSearch, ListSavedSearches, GetSavedSearch and
+	// DeleteSavedSearch manage the saved searches of a workspace with their
+	// alert rules; GetSavedSearch and DeleteSavedSearch return ErrNotFound.
+	CreateSavedSearch(ctx context.Context, search SavedSearch) (SavedSearch, error)
+	ListSavedSearches(ctx context.Context, workspaceID int64) ([]SavedSearch, error)
+	GetSavedSearch(ctx context.Context, workspaceID, id int64) (SavedSearch, error)
+	DeleteSavedSearch(ctx context.Context, workspaceID, id int64) error
+
+	// AddAlertRule and DeleteAlertRule manage the rules of a saved search;
+	// DeleteAlertRule returns ErrNotFound.
+	AddAlertRule(ctx context.Context, rule AlertRule) (AlertRule, error)
+	DeleteAlertRule(ctx context.Context, savedSearchID, ruleID int64) error
+
+	// ListAlerts returns the alerts raised by alert rules during ingestion.
+	ListAlerts(ctx context.Context, q AlertQuery) ([]Alert, error)
+
+	// LatestAlertID returns the id of the newest alert, or 0.
+	LatestAlertID(ctx context.Context) (int64, error)
+
+	// CreateScrapeJob, GetScrapeJob and ListScrapeJobs manage the background
+	// scrape jobs; GetScrapeJob returns ErrNotFound.
+	CreateScrapeJob(ctx context.Context, job ScrapeJob) (ScrapeJob, error)
+	GetScrapeJob(ctx context.Context, id int64) (ScrapeJob, error)
+	ListScrapeJobs(ctx context.Context, q JobQuery) ([]ScrapeJob, error)
+
+	// ClaimScrapeJob leases the next ready job of the queue to a worker, or
+	// returns ErrNotFound; ClaimScrapeJobID leases one job if it is ready.
+	// HeartbeatScrapeJob renews the lease.
+	ClaimScrapeJob(ctx context.Context, owner string, lease time.Duration) (ScrapeJob, error)
+	ClaimScrapeJobID(ctx context.Context, id int64, owner string, lease time.Duration) (ScrapeJob, error)
+	HeartbeatScrapeJob(ctx context.Context, id int64, owner string, lease time.Duration) error
+
+	// UpdateScrapeJob stores the progress of a job its worker is running,
+	// or returns ErrNotFound once the worker lost it.
+	UpdateScrapeJob(ctx context.Context, job ScrapeJob) error
+
+	// CancelScrapeJob stops a queued or running job and RetryScrapeJob
+	// queues a dead one again; both return ErrNotFound.
+	CancelScrapeJob(ctx context.Context, id int64) (ScrapeJob, error)
+	RetryScrapeJob(ctx context.Context, id int64) (ScrapeJob, error)
+
+	// AcquireLeadership takes the named leader lock for holder or returns
+	// ErrNotLeader; Leader returns the current holder, or "".
+	AcquireLeadership(ctx context.Context, name, holder string) (*LeaderLock, error)
+	Leader(ctx context.Context, name string) (string, error)
+
+	// CreateAPIKey stores a key with a new secret, shown this once.
+	CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+
+	// ListAPIKeys returns the API keys of a workspace, revoked ones included.
+	ListAPIKeys(ctx context.Context, workspaceID int64) ([]APIKey, error)
+
+	// AuthenticateAPIKey returns the unrevoked key of a secret, or ErrNotFound.
+	AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error)
+
+	// RevokeAPIKey revokes a key of a workspace, or returns ErrNotFound.
+	RevokeAPIKey(ctx context.Context, workspaceID, id int64) (APIKey, error)
+
+	// RecordAudit logs a privileged action and ListAuditLog pages the log.
+	RecordAudit(ctx context.Context, entry AuditEntry) (int64, error)
+	ListAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
 }
 
 type service struct {
 	db *sql.DB
+	// reads is the pool the Stream methods read from, when it is not db.
+	reads *sql.DB
 }
 
 var (
+	driver     = os.Getenv("DB_DRIVER")
+	dbPath     = os.Getenv("DB_PATH")
 	database   = os.Getenv("DB_DATABASE")
 	password   = os.Getenv("DB_PASSWORD")
 	username   = os.Getenv("DB_USERNAME")
 	port       = os.Getenv("DB_PORT")
 	host       = os.Getenv("DB_HOST")
 	schema     = os.Getenv("DB_SCHEMA")
-	dbInstance *service
+	dbInstance Service
 )
 
+// New returns the process-wide Service for the backend selected by DB_DRIVER
+// ("postgres" by default, or "sqlite"), applying pending migrations on first use.
 func New() Service {
 	// Reuse Connection
 	if dbInstance != nil {
 		return dbInstance
 	}
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
-	db, err := sql.Open("pgx", connStr)
+	var err error
+	switch driver {
+	case "", "postgres":
+		connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
+		dbInstance, err = NewPostgres(connStr)
+	case "sqlite":
+		path := dbPath
+		if path == "" {
+			path = "vinted.db"
+		}
+		dbInstance, err = NewSQLite(path)
+	default:
+		err = fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	dbInstance = &service{
-		db: db,
-	}
 	return dbInstance
 }
 
+// NewPostgres opens a Postgres-backed Service for connStr and migrates its schema.
+func NewPostgres(connStr string) (Service, error) {
+	db, err := sql.Open("pgx", connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, "postgres"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &service{
+		db: db,
+	}, nil
+}
+
 // Health checks the health of the database connection by pinging the database.
 // It returns a map with keys indicating various health statistics.
 func (s *service) Health() map[string]string {
@@ -92,7 +273,7 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
+		log.Printf("db down: %v", err)
 		return stats
 	}
 
@@ -139,30 +320,68 @@ func (s *service) Close() error {
 	return s.db.Close()
 }
 
-func (s *service) AddItems(items []vinted_scraper.Item, topic string) error {
+// IngestResult summarises one AddItems call.
+type IngestResult struct {
+	TopicID  int64 `json:"topic_id"`
+	New      int   `json:"new"`
+	Updated  int   `json:"updated"`
+	Rejected int   `json:"rejected"`
+	// Alerts counts the alerts raised by the rules of saved searches.
+	Alerts int `json:"alerts"`
+}
+
+// ValidateItem reports why an item cannot be stored, or nil if it can.
+func ValidateItem(item vinted_scraper.Item) error {
+	if item.ID <= 0 {
+		return fmt.Errorf("item has no id")
+	}
+	if item.Title == "" {
+		return fmt.Errorf("item %d has no title", item.ID)
+	}
+	if _, err := strconv.ParseFloat(item.Price, 64); err != nil {
+		return fmt.Errorf("item %d has invalid price %q", item.ID, item.Price)
+	}
+	return nil
+}
+
+func (s *service) AddItems(items []vinted_scraper.Item, topic string, domain string) (IngestResult, error) {
+	var result IngestResult
+	if domain == "" {
+		domain = DefaultDomain
+	}
+
 	// Begin a transaction
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return IngestResult{}, err
 	}
 
 	// Insert the topic into the Topic table (if it doesn't already exist)
-	var topicID int
-	err = s.db.QueryRow("INSERT INTO Topic (name) VALUES ($1) ON CONFLICT (name) DO UPDATE SET name = $1 RETURNING id", topic).Scan(&topicID)
+	var topicID int64
+	err = tx.QueryRow("INSERT INTO Topic (name) VALUES ($1) ON CONFLICT (name) DO UPDATE SET name = $1 RETURNING id", topic).Scan(&topicID)
 	if err != nil {
 		// If topic insertion fails, rollback the transaction and return the error
 		tx.Rollback()
-		return fmt.Errorf("error inserting topic %s: %v", topic, err)
+		return IngestResult{}, fmt.Errorf("error inserting topic %s: %v", topic, err)
 	}
 
+	seenAt := time.Now().UTC()
+	// changed are the items stored or repriced, which alert rules check.
+	var changed []vinted_scraper.Item
+
 	// Loop through each item and insert photos and thumbnails
 	for _, item := range items {
+		if ValidateItem(item) != nil {
+			result.Rejected++
+			continue
+		}
+
 		// Insert photos
 		photoID, err := s.insertPhoto(tx, item.Photo)
 		if err != nil {
 			// If photo insertion fails, rollback the transaction and return the error
 			tx.Rollback()
-			return fmt.Errorf("error inserting photo for item %d: %v", item.ID, err)
+			return IngestResult{}, fmt.Errorf("error inserting photo for item %d: %v", item.ID, err)
 		}
 
 		// Insert thumbnails for each photo
@@ -172,37 +391,104 @@ func (s *service) AddItems(items []vinted_scraper.Item, topic string) error {
 			if err != nil {
 				// If thumbnail insertion fails, rollback the transaction and return the error
 				tx.Rollback()
-				return fmt.Errorf("error inserting thumbnail for photo %d: %v", item.Photo.ID, err)
+				return IngestResult{}, fmt.Errorf("error inserting thumbnail for photo %d: %v", item.Photo.ID, err)
 			}
 		}
 
-		// Insert item into Item table and into Item_Topic
+		// Remember the stored price so a change can be recorded after the upsert
+		var oldPrice, oldCurrency sql.NullString
+		err = tx.QueryRow("SELECT price, currency FROM Item WHERE id = $1", item.ID).Scan(&oldPrice, &oldCurrency)
+		if err != nil && err != sql.ErrNoRows {
+			tx.Rollback()
+			return IngestResult{}, fmt.Errorf("error reading price of item %d: %v", item.ID, err)
+		}
+		if oldPrice.Valid {
+			result.Updated++
+		} else {
+			result.New++
+		}
+		// A search in another currency reprices the item without changing
+		// it: compare with the last price seen in this currency, and record
+		// nothing when there is none.
+		repriced := false
+		if oldPrice.Valid && oldCurrency.Valid && !strings.EqualFold(oldCurrency.String, item.Currency) {
+			err = tx.QueryRow(`SELECT price FROM price_history WHERE item_id = $1 AND currency = $2
+                ORDER BY observed_at DESC, id DESC LIMIT 1`, item.ID, item.Currency).Scan(&oldPrice)
+			if err == sql.ErrNoRows {
+				repriced = true
+			} else if err != nil {
+				tx.Rollback()
+				return IngestResult{}, fmt.Errorf("error reading %s price of item %d: %v", item.Currency, item.ID, err)
+			}
+		}
+
+		// Insert item into Item table, then record its topic in Item_Topic
 		_, err = tx.Exec(`INSERT INTO Item (
 			id, title, price, is_visible, discount, currency, brand_title,
 			user_id, url, promoted, photo_id, favourite_count, is_favourite,
 			badge, conversion, service_fee, total_item_price, total_item_price_rounded,
-			view_count, size_title, content_source, status, icon_badges, search_tracking_params,topic_id
+			view_count, size_title, content_source, status, icon_badges, search_tracking_params,topic_id,
+			user_login, user_business, first_seen_at, last_seen_at, domain
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25
-		) ON CONFLICT (id) DO UPDATE SET title = $2, price = $3, is_visible = $4, discount = $5, currency = $6, brand_title = $7, user_id = $8, url = $9, promoted = $10, photo_id = $11, favourite_count = $12, is_favourite = $13, badge = $14, conversion = $15, service_fee = $16, total_item_price = $17, total_item_price_rounded = $18, view_count = $19, size_title = $20, content_source = $21, status = $22, icon_badges = $23, search_tracking_params = $24, topic_id = $25`,
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $28, $29
+		) ON CONFLICT (id) DO UPDATE SET title = $2, price = $3, is_visible = $4, discount = $5, currency = $6, brand_title = $7, user_id = $8, url = $9, promoted = $10, photo_id = $11, favourite_count = $12, is_favourite = $13, badge = $14, conversion = $15, service_fee = $16, total_item_price = $17, total_item_price_rounded = $18, view_count = $19, size_title = $20, content_source = $21, status = $22, icon_badges = $23, search_tracking_params = $24, topic_id = $25, user_login = $26, user_business = $27, last_seen_at = $28, domain = $29`,
 			item.ID, item.Title, item.Price, item.IsVisible, item.Discount, item.Currency, item.BrandTitle,
 			item.User.ID, item.URL, item.Promoted, photoID, item.FavouriteCount, item.IsFavourite,
 			item.Badge, item.Conversion, item.ServiceFee, item.TotalItemPrice, item.TotalItemPriceRounded,
-			item.ViewCount, item.SizeTitle, item.ContentSource, item.Status, nil, nil, topicID)
+			item.ViewCount, item.SizeTitle, item.ContentSource, item.Status, nil, nil, topicID,
+			item.User.Login, item.User.Business, seenAt, domain)
 		if err != nil {
 			// If item insertion fails, rollback the transaction and return the error
 			tx.Rollback()
-			return fmt.Errorf("error inserting item %d: %v", item.ID, err)
+			return IngestResult{}, fmt.Errorf("error inserting item %d: %v", item.ID, err)
+		}
+		_, err = tx.Exec(`INSERT INTO Item_Topic (item_id, topic_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, item.ID, topicID)
+		if err != nil {
+			tx.Rollback()
+			return IngestResult{}, fmt.Errorf("error linking item %d to its topic: %v", item.ID, err)
+		}
+
+		if repriced {
+			continue
+		}
+		if !oldPrice.Valid || priceChanged(oldPrice.String, item.Price) {
+			changed = append(changed, item)
+			_, err = tx.Exec("INSERT INTO price_history (item_id, price, currency, observed_at) VALUES ($1, $2, $3, $4)",
+				item.ID, item.Price, item.Currency, seenAt)
+			if err != nil {
+				tx.Rollback()
+				return IngestResult{}, fmt.Errorf("error recording price of item %d: %v", item.ID, err)
+			}
+		}
+		if err := recordEvent(tx, topicID, item, oldPrice, seenAt); err != nil {
+			tx.Rollback()
+			return IngestResult{}, fmt.Errorf("error recording event of item %d: %v", item.ID, err)
 		}
 	}
 
+	if result.Alerts, err = evaluateAlerts(tx, topicID, topic, domain, changed, seenAt); err != nil {
+		tx.Rollback()
+		return IngestResult{}, fmt.Errorf("error evaluating alert rules: %v", err)
+	}
+
 	// Commit the transaction if everything is successful
 	err = tx.Commit()
 	if err != nil {
-		return fmt.Errorf("error committing transaction: %v", err)
+		return IngestResult{}, fmt.Errorf("error committing transaction: %v", err)
 	}
 
-	return nil
+	result.TopicID = topicID
+	return result, nil
+}
+
+// priceChanged compares two decimal prices numerically, so "4" and "4.0" are equal.
+func priceChanged(old, new string) bool {
+	oldValue, oldErr := strconv.ParseFloat(old, 64)
+	newValue, newErr := strconv.ParseFloat(new, 64)
+	if oldErr != nil || newErr != nil {
+		return old != new
+	}
+	return oldValue != newValue
 }
 
 // Helper function to insert a photo and return its ID
@@ -218,8 +504,8 @@ func (s *service) insertPhoto(tx *sql.Tx, photo vinted_scraper.Photo) (int, erro
 	return photoID, nil
 }
 
-func (s *service) ExistsTopic(topic string) (int8, error) {
-	var topicId int8
+func (s *service) ExistsTopic(topic string) (int64, error) {
+	var topicId int64
 	err := s.db.QueryRow("SELECT id FROM Topic WHERE name = $1", topic).Scan(&topicId)
 	fmt.Println("ExistsTopic", topic, ":", topicId)
 	if err != nil {
@@ -228,24 +514,28 @@ func (s *service) ExistsTopic(topic string) (int8, error) {
 	return topicId, nil
 }
 
-func (s *service) GetItems(topicId int8) (items []vinted_scraper.Item, err error) {
-	query := `
-        SELECT 
+// itemColumns is the select list shared by every query that returns full
+// items; scanItem reads a row produced by it.
+const itemColumns = `
             Item.id, Item.title, Item.price, Item.is_visible, Item.discount, 
             Item.currency, Item.brand_title, Item.user_id, Item.url, Item.promoted, 
             Item.photo_id, Item.favourite_count, Item.is_favourite, Item.badge, 
             Item.conversion, Item.service_fee, Item.total_item_price, Item.total_item_price_rounded, 
             Item.view_count, Item.size_title, Item.content_source, Item.status, 
-            Item.icon_badges, Item.search_tracking_params,
+            Item.icon_badges, Item.search_tracking_params, Item.user_login, Item.user_business,
             photos.id, photos.ImageNo, photos.Width, photos.Height, photos.DominantColor, 
             photos.DominantColorOpaque, photos.URL, photos.IsMain,
-            photos.IsSuspicious, photos.FullSizeURL, photos.IsHidden
+            photos.IsSuspicious, photos.FullSizeURL, photos.IsHidden`
+
+func (s *service) GetItems(topicId int64) (items []vinted_scraper.Item, err error) {
+	query := `
+        SELECT ` + itemColumns + `
         FROM 
             Item
         JOIN 
             photos ON Item.photo_id = photos.id
         WHERE 
-            Item.topic_id = $1`
+            Item.id IN (SELECT item_id FROM Item_Topic WHERE topic_id = $1)`
 
 	rows, err := s.db.Query(query, topicId)
 	if err != nil {
@@ -254,56 +544,69 @@ func (s *service) GetItems(topicId int8) (items []vinted_scraper.Item, err error
 	defer rows.Close() // Close the rows when we're done with them
 
 	for rows.Next() {
-		var item vinted_scraper.Item            // Declare a variable to store the current row
-		var photo vinted_scraper.Photo          // Declare a variable to store the current row
-		var iconBadges sql.NullString           // Declare a variable to store the current row
-		var searchTrackingParams sql.NullString // Declare a variable to store the current row
-		var highResID sql.NullString            // High resolution fields
-		var highResTimestamp sql.NullInt64
-		var highResOrientation sql.NullString
-
-		err = rows.Scan(&item.ID, &item.Title, &item.Price, &item.IsVisible, &item.Discount, &item.Currency, &item.BrandTitle,
-			&item.User.ID, &item.URL, &item.Promoted, &item.Photo.ID, &item.FavouriteCount, &item.IsFavourite,
-			&item.Badge, &item.Conversion, &item.ServiceFee, &item.TotalItemPrice, &item.TotalItemPriceRounded,
-			&item.ViewCount, &item.SizeTitle, &item.ContentSource, &item.Status, &iconBadges, &searchTrackingParams,
-			&photo.ID, &photo.ImageNo, &photo.Width, &photo.Height, &photo.DominantColor,
-			&photo.DominantColorOpaque, &photo.URL, &photo.IsMain,
-			&photo.IsSuspicious, &photo.FullSizeURL, &photo.IsHidden) // Scan the current row into the variables
+		item, err := scanItem(rows)
 		if err != nil { // Check for errors
 			return nil, err
 		}
+		items = append(items, item) // Append the current row to the items slice
+	}
 
-		if iconBadges.Valid { // If the iconBadges variable is not null
-			item.IconBadges = parseIconBadges(iconBadges.String) // Parse the iconBadges string into a slice of interfaces
-		} else { // If the iconBadges variable is null
-			item.IconBadges = []interface{}{} // Set the iconBadges slice to an empty slice
-		}
+	return items, rows.Err()
+}
 
-		if searchTrackingParams.Valid { // If the searchTrackingParams variable is not null
-			item.SearchTrackingParams = parseSearchTrackingParams(searchTrackingParams.String) // Parse the searchTrackingParams string into a struct
-		} else { // If the searchTrackingParams variable is null
-			item.SearchTrackingParams = struct {
-				Score          float64  `json:"score"`
-				MatchedQueries []string `json:"matched_queries"`
-			}{} // Set the searchTrackingParams struct to an empty struct
-		}
+// scanItem scans a row selected with itemColumns (plus any trailing dest
+// columns) into an Item.
+func scanItem(rows *sql.Rows, dest ...interface{}) (vinted_scraper.Item, error) {
+	var item vinted_scraper.Item            // Declare a variable to store the current row
+	var photo vinted_scraper.Photo          // Declare a variable to store the current row
+	var iconBadges sql.NullString           // Declare a variable to store the current row
+	var searchTrackingParams sql.NullString // Declare a variable to store the current row
+	var userLogin sql.NullString
+	var highResID sql.NullString // High resolution fields
+	var highResTimestamp sql.NullInt64
+	var highResOrientation sql.NullString
+
+	err := rows.Scan(append([]interface{}{&item.ID, &item.Title, &item.Price, &item.IsVisible, &item.Discount, &item.Currency, &item.BrandTitle,
+		&item.User.ID, &item.URL, &item.Promoted, &item.Photo.ID, &item.FavouriteCount, &item.IsFavourite,
+		&item.Badge, &item.Conversion, &item.ServiceFee, &item.TotalItemPrice, &item.TotalItemPriceRounded,
+		&item.ViewCount, &item.SizeTitle, &item.ContentSource, &item.Status, &iconBadges, &searchTrackingParams,
+		&userLogin, &item.User.Business,
+		&photo.ID, &photo.ImageNo, &photo.Width, &photo.Height, &photo.DominantColor,
+		&photo.DominantColorOpaque, &photo.URL, &photo.IsMain,
+		&photo.IsSuspicious, &photo.FullSizeURL, &photo.IsHidden}, dest...)...) // Scan the current row into the variables
+	if err != nil {
+		return item, err
+	}
+	item.User.Login = userLogin.String
 
-		// Handle HighResolution field
-		if highResID.Valid {
-			photo.HighResolution.ID = highResID.String
-		}
-		if highResTimestamp.Valid {
-			photo.HighResolution.Timestamp = int(highResTimestamp.Int64)
-		}
-		if highResOrientation.Valid {
-			photo.HighResolution.Orientation = highResOrientation.String
-		}
+	if iconBadges.Valid { // If the iconBadges variable is not null
+		item.IconBadges = parseIconBadges(iconBadges.String) // Parse the iconBadges string into a slice of interfaces
+	} else { // If the iconBadges variable is null
+		item.IconBadges = []interface{}{} // Set the iconBadges slice to an empty slice
+	}
 
-		item.Photo = photo          // Set the item's photo to the current row's photo
-		items = append(items, item) // Append the current row to the items slice
+	if searchTrackingParams.Valid { // If the searchTrackingParams variable is not null
+		item.SearchTrackingParams = parseSearchTrackingParams(searchTrackingParams.String) // Parse the searchTrackingParams string into a struct
+	} else { // If the searchTrackingParams variable is null
+		item.SearchTrackingParams = struct {
+			Score          float64  `json:"score"`
+			MatchedQueries []string `json:"matched_queries"`
+		}{} // Set the searchTrackingParams struct to an empty struct
+	}
+
+	// Handle HighResolution field
+	if highResID.Valid {
+		photo.HighResolution.ID = highResID.String
+	}
+	if highResTimestamp.Valid {
+		photo.HighResolution.Timestamp = int(highResTimestamp.Int64)
+	}
+	if highResOrientation.Valid {
+		photo.HighResolution.Orientation = highResOrientation.String
 	}
 
-	return items, nil
+	item.Photo = photo // Set the item's photo to the current row's photo
+	return item, nil
 }
 
 func parseIconBadges(iconBadges string) []interface{} {
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	vinted_scraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// EventKind is what happened to an item.
+type EventKind string
+
+const (
+	// EventNew is an item stored for the first time.
+	EventNew EventKind = "new"
+	// EventPriceDrop and EventPriceRise are a stored item seen at a lower
+	// or higher price than before.
+	EventPriceDrop EventKind = "price_drop"
+	EventPriceRise EventKind = "price_rise"
+	// EventRemoved is an item that left the results of a search.
+	EventRemoved EventKind = "removed"
+)
+
+// ItemEvent is one change ingestion made to an item of a topic. IDs
+// increase with time, so a reader resumes from the last ID it saw.
+type ItemEvent struct {
+	ID            int64               `json:"id"`
+	TopicID       int64               `json:"topic_id"`
+	Kind          EventKind           `json:"kind"`
+	Price         string              `json:"price"`
+	PreviousPrice string              `json:"previous_price,omitempty"`
+	Currency      string              `json:"currency"`
+	CreatedAt     time.Time           `json:"created_at"`
+	Item          vinted_scraper.Item `json:"item"`
+}
+
+// EventQuery selects events after an event id, oldest first. Zero values
+// mean "no filter".
+type EventQuery struct {
+	// WorkspaceID selects the events of the topics a workspace sees.
+	WorkspaceID int64
+	TopicID     int64
+	// SellerID selects the events of items sold by a user.
+	SellerID int
+	// Signature selects the events of items in the results of a search, and
+	// of items that left them, that pass the signature's filters.
+	Signature string
+	AfterID   int64
+	// UpToID bounds the events to those up to and including an id.
+	UpToID int64
+	// Kinds restricts the events to these kinds; empty means every kind.
+	Kinds []EventKind
+	Limit int
+}
+
+// recordEvent stores the event of item changing from oldPrice, if any,
+// as part of the ingestion transaction tx.
+func recordEvent(tx *sql.Tx, topicID int64, item vinted_scraper.Item, oldPrice sql.NullString, at time.Time) error {
+	kind := EventNew
+	if oldPrice.Valid {
+		if !priceChanged(oldPrice.String, item.Price) {
+			return nil
+		}
+		kind = EventPriceRise
+		if priceBelow(item.Price, oldPrice.String) {
+			kind = EventPriceDrop
+		}
+	}
+	_, err := tx.Exec(`INSERT INTO item_events (topic_id, item_id, kind, price, previous_price, currency, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		topicID, item.ID, string(kind), item.Price, oldPrice, item.Currency, at)
+	return err
+}
+
+// priceBelow reports whether price a is lower than price b.
+func priceBelow(a, b string) bool {
+	var x, y float64
+	if _, err := fmt.Sscan(a, &x); err != nil {
+		return false
+	}
+	if _, err := fmt.Sscan(b, &y); err != nil {
+		return false
+	}
+	return x < y
+}
+
+// LatestEventID returns the id of the newest event of topicID, or of any
+// topic for 0, or 0 when there is none.
+func (s *service) LatestEventID(ctx context.Context, topicID int64) (int64, error) {
+	var id sql.NullInt64
+	var err error
+	if topicID == 0 {
+		err = s.db.QueryRowContext(ctx, "SELECT MAX(id) FROM item_events").Scan(&id)
+	} else {
+		err = s.db.QueryRowContext(ctx, "SELECT MAX(id) FROM item_events WHERE topic_id = $1", topicID).Scan(&id)
+	}
+	return id.Int64, err
+}
+
+// ListItemEvents returns the events selected by q with their items, oldest first.
+func (s *service) ListItemEvents(ctx context.Context, q EventQuery) ([]ItemEvent, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+	w := &whereBuilder{}
+	w.add("item_events.id > ?", q.AfterID)
+	if q.UpToID != 0 {
+		w.add("item_events.id <= ?", q.UpToID)
+	}
+	w.addWorkspace("item_events.topic_id "+inWorkspace, q.WorkspaceID)
+	if q.TopicID != 0 {
+		w.add("item_events.topic_id = ?", q.TopicID)
+	}
+	if q.SellerID != 0 {
+		w.add("Item.user_id = ?", q.SellerID)
+	}
+	if q.Signature != "" {
+		// The results are stored without the filters, which only narrow
+		// down their items.
+		sig, err := ParseSearchSignature(q.Signature)
+		if err != nil {
+			return nil, err
+		}
+		filters, err := ParseItemQuery(sig.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filters of search %q: %v", q.Signature, err)
+		}
+		unfiltered := sig.Unfiltered().String()
+		w.add("(item_events.signature = ? OR item_events.item_id IN (SELECT item_id FROM search_results WHERE signature = ?))", unfiltered, unfiltered)
+		filters.WorkspaceID = AllWorkspaces
+		w.addItemFilters(filters)
+	}
+	if len(q.Kinds) > 0 {
+		kinds := make([]interface{}, len(q.Kinds))
+		placeholders := "?"
+		for i, kind := range q.Kinds {
+			kinds[i] = string(kind)
+			if i > 0 {
+				placeholders += ", ?"
+			}
+		}
+		w.add("item_events.kind IN ("+placeholders+")", kinds...)
+	}
+	query := fmt.Sprintf(`
+        SELECT %s, item_events.id, item_events.topic_id, item_events.kind, item_events.price,
+            item_events.previous_price, item_events.currency, item_events.created_at
+        FROM item_events
+        JOIN Item ON Item.id = item_events.item_id
+        JOIN photos ON Item.photo_id = photos.id
+        %s
+        ORDER BY item_events.id
+        LIMIT %d`, itemColumns, w, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []ItemEvent{}
+	for rows.Next() {
+		var event ItemEvent
+		var kind string
+		var previous sql.NullString
+		var createdAt nullTime
+		event.Item, err = scanItem(rows, &event.ID, &event.TopicID, &kind, &event.Price, &previous, &event.Currency, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Kind = EventKind(kind)
+		event.PreviousPrice = previous.String
+		event.CreatedAt = createdAt.Time
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JobStatus is where a scrape job is in its life.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobCancelled JobStatus = "cancelled"
+	// JobDead is a job that failed its last attempt: the dead-letter queue.
+	JobDead JobStatus = "dead"
+)
+
+// Done reports whether a job in this status will not run again.
+func (s JobStatus) Done() bool {
+	return s == JobSucceeded || s == JobCancelled || s == JobDead
+}
+
+// Kinds of scrape job, by who queued them.
+const (
+	JobKindAPI      = "api"
+	JobKindSchedule = "schedule"
+	// JobKindSearch jobs scrape the first page of a live search for the
+	// requests waiting on it, see ScrapeJob.Signature.
+	JobKindSearch = "search"
+)
+
+// ScrapeJob is a scrape of up to Pages pages of a search, queued for
+// whichever worker claims it first, with its progress so far.
+type ScrapeJob struct {
+	ID       int64     `json:"id"`
+	Kind     string    `json:"kind"`
+	Query    string    `json:"q"`
+	Order    string    `json:"order"`
+	Currency string    `json:"currency,omitempty"`
+	Pages    int       `json:"pages"`
+	Priority int       `json:"priority"`
+	Status   JobStatus `json:"status"`
+	// DedupeKey, if set, keeps a second job with the same key from being
+	// queued while this one is queued or running.
+	DedupeKey string `json:"-"`
+	// Signature is the search a JobKindSearch job answers, see
+	// SearchSignature.String.
+	Signature string `json:"signature,omitempty"`
+	// WorkspaceID is the workspace that queued the job, or whose saved
+	// search the scheduler refreshes; 0 for the scheduler's topic refreshes,
+	// which every workspace shares.
+	WorkspaceID int64 `json:"workspace_id,omitempty"`
+	// TopicID is the topic the items went to, once a page was stored.
+	TopicID      int64  `json:"topic_id,omitempty"`
+	PagesFetched int    `json:"pages_fetched"`
+	ItemsNew     int    `json:"items_new"`
+	ItemsUpdated int    `json:"items_updated"`
+	Errors       int    `json:"errors"`
+	LastError    string `json:"last_error,omitempty"`
+	// Attempts counts the claims of the job; it is dead-lettered when an
+	// attempt fails and MaxAttempts is reached.
+	Attempts    int `json:"attempts"`
+	MaxAttempts int `json:"max_attempts"`
+	// RunAfter holds a failed job back until its retry is due.
+	RunAfter *time.Time `json:"run_after,omitempty"`
+	// LeaseOwner is the worker running the job, which must renew the lease
+	// before LeaseExpiresAt or lose the job to another worker.
+	LeaseOwner     string     `json:"lease_owner,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobQuery selects scrape jobs, newest first. An empty Status or Kind, or
+// AllWorkspaces, means any; Before pages backwards from a job id.
+type JobQuery struct {
+	WorkspaceID int64
+	Status      JobStatus
+	Kind        string
+	Before      int64
+	Limit       int
+}
+
+// CreateScrapeJob queues job and returns it with its id. When an active
+// job has the same DedupeKey, that job is returned instead.
+func (s *service) CreateScrapeJob(ctx context.Context, job ScrapeJob) (ScrapeJob, error) {
+	job.Status = JobQueued
+	job.CreatedAt = time.Now().UTC()
+	if job.Kind == "" {
+		job.Kind = JobKindAPI
+	}
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.db.QueryRowContext(ctx, `INSERT INTO scrape_jobs (
+            kind, query, search_order, currency, pages, priority, status, dedupe_key, signature, workspace_id, max_attempts, created_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+        ON CONFLICT DO NOTHING RETURNING id`,
+			job.Kind, job.Query, job.Order, job.Currency, job.Pages, job.Priority, job.Status, nullString(job.DedupeKey),
+			nullString(job.Signature), sql.NullInt64{Int64: job.WorkspaceID, Valid: job.WorkspaceID != 0}, job.MaxAttempts, job.CreatedAt).Scan(&job.ID)
+		if !errors.Is(err, sql.ErrNoRows) || job.DedupeKey == "" {
+			if err != nil {
+				return job, fmt.Errorf("error creating scrape job: %v", err)
+			}
+			return job, nil
+		}
+		rows, err := s.db.QueryContext(ctx, "SELECT "+jobColumns+" FROM scrape_jobs WHERE dedupe_key = $1 AND status IN ($2, $3)",
+			job.DedupeKey, JobQueued, JobRunning)
+		if err != nil {
+			return job, err
+		}
+		active, err := scanOneJob(rows)
+		// The active job may finish between the insert and the select,
+		// freeing the key; insert again then.
+		if !errors.Is(err, ErrNotFound) || attempt >= maxCreateAttempts {
+			return active, err
+		}
+	}
+}
+
+// maxCreateAttempts bounds how often CreateScrapeJob races a job with the
+// same DedupeKey finishing.
+const maxCreateAttempts = 5
+
+// jobColumns is the select list read by scanJob.
+const jobColumns = `id, kind, query, search_order, currency, pages, priority, status, dedupe_key, signature, workspace_id, topic_id,
+            pages_fetched, items_new, items_updated, errors, last_error, attempts, max_attempts, run_after,
+            lease_owner, lease_expires_at, created_at, started_at, finished_at`
+
+// GetScrapeJob returns one job, or ErrNotFound.
+func (s *service) GetScrapeJob(ctx context.Context, id int64) (ScrapeJob, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+jobColumns+" FROM scrape_jobs WHERE id = $1", id)
+	if err != nil {
+		return ScrapeJob{}, err
+	}
+	return scanOneJob(rows)
+}
+
+// ListScrapeJobs returns the jobs selected by q, newest first.
+func (s *service) ListScrapeJobs(ctx context.Context, q JobQuery) ([]ScrapeJob, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+	w := &whereBuilder{}
+	w.addWorkspace("workspace_id = ?", q.WorkspaceID)
+	if q.Status != "" {
+		w.add("status = ?", q.Status)
+	}
+	if q.Kind != "" {
+		w.add("kind = ?", q.Kind)
+	}
+	if q.Before != 0 {
+		w.add("id < ?", q.Before)
+	}
+	query := fmt.Sprintf("SELECT %s FROM scrape_jobs %s ORDER BY id DESC LIMIT %d", jobColumns, w, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []ScrapeJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// ClaimScrapeJob leases the next job to owner for lease: the highest
+// priority, oldest job that is queued and due, or running under an
+// expired lease. Rows other workers are claiming are skipped rather than
+// waited for. It returns ErrNotFound when no job is ready.
+func (s *service) ClaimScrapeJob(ctx context.Context, owner string, lease time.Duration) (ScrapeJob, error) {
+	return s.claimScrapeJob(ctx, "FOR UPDATE SKIP LOCKED", owner, lease, 0)
+}
+
+// ClaimScrapeJobID leases the job id to owner like ClaimScrapeJob, if it
+// is ready, or returns ErrNotFound.
+func (s *service) ClaimScrapeJobID(ctx context.Context, id int64, owner string, lease time.Duration) (ScrapeJob, error) {
+	return s.claimScrapeJob(ctx, "FOR UPDATE SKIP LOCKED", owner, lease, id)
+}
+
+// ClaimScrapeJob claims like the Postgres backend; SQLite has a single
+// writer, so there are no row locks to skip.
+func (s *sqliteService) ClaimScrapeJob(ctx context.Context, owner string, lease time.Duration) (ScrapeJob, error) {
+	return s.claimScrapeJob(ctx, "", owner, lease, 0)
+}
+
+func (s *sqliteService) ClaimScrapeJobID(ctx context.Context, id int64, owner string, lease time.Duration) (ScrapeJob, error) {
+	return s.claimScrapeJob(ctx, "", owner, lease, id)
+}
+
+// claimScrapeJob claims the next ready job, or the job id when it is not 0.
+func (s *service) claimScrapeJob(ctx context.Context, lock, owner string, lease time.Duration, id int64) (ScrapeJob, error) {
+	now := time.Now().UTC()
+	// Jobs whose worker died on their last attempt are dead-lettered
+	// rather than claimed again.
+	_, err := s.db.ExecContext(ctx, `UPDATE scrape_jobs
+        SET status = $1, last_error = COALESCE(last_error, 'lease expired'), lease_owner = NULL,
+            lease_expires_at = NULL, finished_at = $2
+        WHERE status = $3 AND lease_expires_at < $2 AND attempts >= max_attempts`,
+		JobDead, now, JobRunning)
+	if err != nil {
+		return ScrapeJob{}, fmt.Errorf("error dead-lettering scrape jobs: %v", err)
+	}
+
+	args := []interface{}{JobRunning, owner, now.Add(lease), now, JobQueued}
+	only := ""
+	if id != 0 {
+		args = append(args, id)
+		only = "AND id = $6"
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`UPDATE scrape_jobs
+        SET status = $1, attempts = attempts + 1, lease_owner = $2, lease_expires_at = $3,
+            started_at = COALESCE(started_at, $4)
+        WHERE id = (
+            SELECT id FROM scrape_jobs
+            WHERE ((status = $5 AND (run_after IS NULL OR run_after <= $4))
+               OR (status = $1 AND lease_expires_at < $4)) %s
+            ORDER BY priority DESC, id
+            LIMIT 1
+            %s
+        )
+        RETURNING `+jobColumns, only, lock), args...)
+	if err != nil {
+		return ScrapeJob{}, fmt.Errorf("error claiming scrape job: %v", err)
+	}
+	return scanOneJob(rows)
+}
+
+// HeartbeatScrapeJob extends the lease owner holds on a running job. It
+// returns ErrNotFound when owner lost the job: it was cancelled, or its
+// lease expired and another worker claimed it.
+func (s *service) HeartbeatScrapeJob(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE scrape_jobs SET lease_expires_at = $1 WHERE id = $2 AND status = $3 AND lease_owner = $4",
+		time.Now().UTC().Add(lease), id, JobRunning, owner)
+	if err != nil {
+		return fmt.Errorf("error renewing lease of scrape job %d: %v", id, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateScrapeJob stores the status and progress of a job its worker,
+// job.LeaseOwner, is running; the lease is released unless the job is
+// still running. It returns ErrNotFound when the worker lost the job.
+func (s *service) UpdateScrapeJob(ctx context.Context, job ScrapeJob) error {
+	var finishedAt *time.Time
+	if job.Status.Done() {
+		now := time.Now().UTC()
+		finishedAt = &now
+	}
+	owner, expires := nullString(job.LeaseOwner), job.LeaseExpiresAt
+	if job.Status != JobRunning {
+		owner, expires = sql.NullString{}, nil
+	}
+	result, err := s.db.ExecContext(ctx, `UPDATE scrape_jobs
+        SET status = $1, topic_id = $2, pages_fetched = $3, items_new = $4, items_updated = $5,
+            errors = $6, last_error = $7, run_after = $8, lease_owner = $9, lease_expires_at = $10, finished_at = $11
+        WHERE id = $12 AND status = $13 AND lease_owner = $14`,
+		job.Status, sql.NullInt64{Int64: job.TopicID, Valid: job.TopicID != 0}, job.PagesFetched, job.ItemsNew, job.ItemsUpdated,
+		job.Errors, nullString(job.LastError), utcPtr(job.RunAfter), owner, utcPtr(expires), finishedAt,
+		job.ID, JobRunning, job.LeaseOwner)
+	if err != nil {
+		return fmt.Errorf("error updating scrape job %d: %v", job.ID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CancelScrapeJob cancels a queued or running job and returns it; jobs
+// that are already done are returned unchanged. It returns ErrNotFound for
+// unknown jobs.
+func (s *service) CancelScrapeJob(ctx context.Context, id int64) (ScrapeJob, error) {
+	_, err := s.db.ExecContext(ctx, `UPDATE scrape_jobs
+        SET status = $1, lease_owner = NULL, lease_expires_at = NULL, finished_at = $2
+        WHERE id = $3 AND status IN ($4, $5)`,
+		JobCancelled, time.Now().UTC(), id, JobQueued, JobRunning)
+	if err != nil {
+		return ScrapeJob{}, fmt.Errorf("error cancelling scrape job %d: %v", id, err)
+	}
+	return s.GetScrapeJob(ctx, id)
+}
+
+// RetryScrapeJob queues a dead job again with fresh attempts, resuming
+// after its last fetched page, and returns it; other jobs are returned
+// unchanged. It returns ErrNotFound for unknown jobs.
+func (s *service) RetryScrapeJob(ctx context.Context, id int64) (ScrapeJob, error) {
+	_, err := s.db.ExecContext(ctx, `UPDATE scrape_jobs
+        SET status = $1, attempts = 0, run_after = NULL, dedupe_key = NULL, finished_at = NULL
+        WHERE id = $2 AND status = $3`,
+		JobQueued, id, JobDead)
+	if err != nil {
+		return ScrapeJob{}, fmt.Errorf("error retrying scrape job %d: %v", id, err)
+	}
+	return s.GetScrapeJob(ctx, id)
+}
+
+// utcPtr converts t to UTC for storage, keeping nil as NULL.
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// scanOneJob reads the single job of rows and closes them, returning
+// ErrNotFound when there is none.
+func scanOneJob(rows *sql.Rows) (ScrapeJob, error) {
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return ScrapeJob{}, err
+		}
+		return ScrapeJob{}, ErrNotFound
+	}
+	return scanJob(rows)
+}
+
+func scanJob(rows *sql.Rows) (ScrapeJob, error) {
+	var job ScrapeJob
+	var workspaceID, topicID sql.NullInt64
+	var dedupeKey, signature, lastError, leaseOwner sql.NullString
+	var runAfter, leaseExpires, createdAt, startedAt, finishedAt nullTime
+	err := rows.Scan(&job.ID, &job.Kind, &job.Query, &job.Order, &job.Currency, &job.Pages, &job.Priority, &job.Status,
+		&dedupeKey, &signature, &workspaceID, &topicID, &job.PagesFetched, &job.ItemsNew, &job.ItemsUpdated, &job.Errors, &lastError,
+		&job.Attempts, &job.MaxAttempts, &runAfter, &leaseOwner, &leaseExpires, &createdAt, &startedAt, &finishedAt)
+	if err != nil {
+		return job, err
+	}
+	job.DedupeKey = dedupeKey.String
+	job.Signature = signature.String
+	job.WorkspaceID = workspaceID.Int64
+	job.TopicID = topicID.Int64
+	job.LastError = lastError.String
+	job.LeaseOwner = leaseOwner.String
+	job.CreatedAt = createdAt.Time
+	if runAfter.Valid {
+		job.RunAfter = &runAfter.Time
+	}
+	if leaseExpires.Valid {
+		job.LeaseExpiresAt = &leaseExpires.Time
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+)
+
+// ErrNotLeader is returned by AcquireLeadership when another process holds
+// the lock.
+var ErrNotLeader = errors.New("another process is leader")
+
+// LeaderLock is a held leader lock. On Postgres it is a session advisory
+// lock on a connection of its own, so it is lost if that connection dies.
+type LeaderLock struct {
+	Name   string
+	Holder string
+	Since  time.Time
+
+	conn    *sql.Conn
+	key     int64
+	release func()
+}
+
+// lockKey maps a lock name onto the 64-bit key of a Postgres advisory lock.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// AcquireLeadership tries once to take the leader lock name for holder,
+// returning ErrNotLeader if another session holds it. The holder is
+// published as the connection's application_name, which is how Leader
+// finds it.
+func (s *service) AcquireLeadership(ctx context.Context, name, holder string) (*LeaderLock, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(name)
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error taking leader lock %s: %v", name, err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrNotLeader
+	}
+	lock := &LeaderLock{Name: name, Holder: holder, Since: time.Now().UTC(), conn: conn, key: key}
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('application_name', $1, false)", holder); err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("error naming leader of %s: %v", name, err)
+	}
+	return lock, nil
+}
+
+// Leader returns the holder of the leader lock name, or "" when nobody
+// holds it.
+func (s *service) Leader(ctx context.Context, name string) (string, error) {
+	key := uint64(lockKey(name))
+	var holder string
+	err := s.db.QueryRowContext(ctx, `SELECT a.application_name
+        FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+        WHERE l.locktype = 'advisory' AND l.granted AND l.classid = $1 AND l.objid = $2 AND l.objsubid = 1`,
+		int64(key>>32), int64(key&0xffffffff)).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error finding leader of %s: %v", name, err)
+	}
+	return holder, nil
+}
+
+// AcquireLeadership takes the leader lock name for holder unless another
+// holder in this process has it. A SQLite database is served by a single
+// process, so that is the whole election.
+func (s *sqliteService) AcquireLeadership(ctx context.Context, name, holder string) (*LeaderLock, error) {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+	if _, held := s.leaders[name]; held {
+		return nil, ErrNotLeader
+	}
+	s.leaders[name] = holder
+	return &LeaderLock{Name: name, Holder: holder, Since: time.Now().UTC(), release: func() {
+		s.leaderMu.Lock()
+		defer s.leaderMu.Unlock()
+		delete(s.leaders, name)
+	}}, nil
+}
+
+// Leader returns the holder of the leader lock name in this process.
+func (s *sqliteService) Leader(ctx context.Context, name string) (string, error) {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+	return s.leaders[name], nil
+}
+
+// Check reports whether the lock is still held, returning an error once
+// its connection is gone.
+func (l *LeaderLock) Check(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		return fmt.Errorf("lost leader lock %s: %v", l.Name, err)
+	}
+	return nil
+}
+
+// Release gives the lock up.
+func (l *LeaderLock) Release() error {
+	if l.release != nil {
+		l.release()
+	}
+	if l.conn == nil {
+		return nil
+	}
+	defer l.conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1), set_config('application_name', '', false)", l.key); err != nil {
+		return fmt.Errorf("error releasing leader lock %s: %v", l.Name, err)
+	}
+	return nil
+}
+
+// lockMigrations holds a Postgres advisory lock until the returned unlock is
+// called, so replicas starting together apply migrations one at a time.
+func lockMigrations(db *sql.DB) (unlock func(), err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey("schema_migrations")
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error locking migrations: %v", err)
+	}
+	return func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key); err != nil {
+			log.Printf("Error unlocking migrations: %v", err)
+		}
+		conn.Close()
+	}, nil
+}
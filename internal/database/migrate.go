@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// migrate applies every migration under migrations/<dialect> that has not
+// been recorded in schema_migrations yet, in lexical order. Each file runs in
+// its own transaction so a failing migration leaves the schema untouched.
+func migrate(db *sql.DB, dialect string) error {
+	// Replicas starting together would otherwise race to apply the same
+	// migration.
+	if dialect == "postgres" {
+		unlock, err := lockMigrations(db)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return fmt.Errorf("error reading %s migrations: %v", dialect, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		version := entry.Name()
+
+		var applied int
+		err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = $1", version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("error checking migration %s: %v", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := migrations.ReadFile(path.Join(dir, version))
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %v", version, err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %s: %v", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording migration %s: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing migration %s: %v", version, err)
+		}
+		log.Printf("Applied %s migration %s", dialect, version)
+	}
+
+	return nil
+}
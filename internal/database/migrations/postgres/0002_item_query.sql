@@ -0,0 +1,10 @@
+ALTER TABLE Item ADD COLUMN IF NOT EXISTS user_login TEXT;
+ALTER TABLE Item ADD COLUMN IF NOT EXISTS user_business BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE Item ADD COLUMN IF NOT EXISTS first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE Item ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS item_topic_idx ON Item (topic_id);
+CREATE INDEX IF NOT EXISTS item_brand_idx ON Item (LOWER(brand_title));
+CREATE INDEX IF NOT EXISTS item_user_idx ON Item (user_id);
+CREATE INDEX IF NOT EXISTS item_first_seen_idx ON Item (first_seen_at, id);
+CREATE INDEX IF NOT EXISTS item_price_idx ON Item (price, id);
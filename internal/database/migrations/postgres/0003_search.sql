@@ -0,0 +1,38 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- vinted_search_config maps a Vinted domain to the text search configuration
+-- for the language its listings are written in.
+CREATE OR REPLACE FUNCTION vinted_search_config(domain TEXT) RETURNS regconfig AS
+$$
+SELECT CASE domain
+           WHEN 'co.uk' THEN 'english'
+           WHEN 'com' THEN 'english'
+           WHEN 'ie' THEN 'english'
+           WHEN 'fr' THEN 'french'
+           WHEN 'be' THEN 'french'
+           WHEN 'lu' THEN 'french'
+           WHEN 'de' THEN 'german'
+           WHEN 'at' THEN 'german'
+           WHEN 'es' THEN 'spanish'
+           WHEN 'it' THEN 'italian'
+           WHEN 'nl' THEN 'dutch'
+           WHEN 'pt' THEN 'portuguese'
+           WHEN 'se' THEN 'swedish'
+           WHEN 'dk' THEN 'danish'
+           WHEN 'fi' THEN 'finnish'
+           WHEN 'hu' THEN 'hungarian'
+           WHEN 'ro' THEN 'romanian'
+           ELSE 'simple'
+           END::regconfig
+$$ LANGUAGE sql IMMUTABLE;
+
+ALTER TABLE Item ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT 'co.uk';
+ALTER TABLE Item ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector(vinted_search_config(domain), coalesce(title, '')), 'A') ||
+    setweight(to_tsvector(vinted_search_config(domain), coalesce(brand_title, '')), 'B') ||
+    setweight(to_tsvector(vinted_search_config(domain), coalesce(size_title, '')), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS item_search_idx ON Item USING gin (search_vector);
+CREATE INDEX IF NOT EXISTS item_title_trgm_idx ON Item USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS item_brand_trgm_idx ON Item USING gin (brand_title gin_trgm_ops);
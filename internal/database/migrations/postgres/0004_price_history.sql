@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS price_history
+(
+    id          BIGSERIAL PRIMARY KEY,
+    item_id     int8        NOT NULL REFERENCES Item (id) ON DELETE CASCADE,
+    price       NUMERIC     NOT NULL,
+    currency    TEXT        NOT NULL,
+    observed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS price_history_item_idx ON price_history (item_id, observed_at);
+CREATE INDEX IF NOT EXISTS price_history_observed_idx ON price_history (observed_at);
+CREATE INDEX IF NOT EXISTS item_last_seen_idx ON Item (last_seen_at);
+CREATE INDEX IF NOT EXISTS thumbnails_photo_idx ON Thumbnails (photo_id);
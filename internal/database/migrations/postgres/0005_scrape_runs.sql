@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS scrape_runs
+(
+    id            BIGSERIAL PRIMARY KEY,
+    topic         TEXT        NOT NULL,
+    topic_id      int8 REFERENCES Topic (id),
+    query_params  TEXT        NOT NULL,
+    domain        TEXT        NOT NULL,
+    started_at    TIMESTAMPTZ NOT NULL,
+    finished_at   TIMESTAMPTZ NOT NULL,
+    pages         int8        NOT NULL DEFAULT 0,
+    items_new     int8        NOT NULL DEFAULT 0,
+    items_updated int8        NOT NULL DEFAULT 0,
+    http_status   int8,
+    error_class   TEXT,
+    error_message TEXT
+);
+
+CREATE INDEX IF NOT EXISTS scrape_runs_topic_idx ON scrape_runs (topic_id, id);
@@ -0,0 +1,20 @@
+-- searches holds one row per canonical search signature (see
+-- SearchSignature) and when Vinted was last asked for it.
+CREATE TABLE IF NOT EXISTS searches
+(
+    signature  TEXT PRIMARY KEY,
+    topic_id   int8        NOT NULL REFERENCES Topic (id),
+    fetched_at TIMESTAMPTZ NOT NULL
+);
+
+-- search_results keeps the order Vinted returned the items of a search in.
+CREATE TABLE IF NOT EXISTS search_results
+(
+    signature TEXT NOT NULL REFERENCES searches (signature) ON DELETE CASCADE,
+    position  int8 NOT NULL,
+    item_id   int8 NOT NULL REFERENCES Item (id) ON DELETE CASCADE,
+    PRIMARY KEY (signature, position),
+    UNIQUE (signature, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS search_results_item_idx ON search_results (item_id);
@@ -0,0 +1,16 @@
+-- item_events is the feed of changes ingestion makes to items, read by the
+-- topic streams. Its ids are the event ids clients resume from.
+CREATE TABLE IF NOT EXISTS item_events
+(
+    id             BIGSERIAL PRIMARY KEY,
+    topic_id       int8        NOT NULL REFERENCES Topic (id),
+    item_id        int8        NOT NULL REFERENCES Item (id) ON DELETE CASCADE,
+    kind           TEXT        NOT NULL,
+    price          NUMERIC     NOT NULL,
+    previous_price NUMERIC,
+    currency       TEXT        NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS item_events_topic_idx ON item_events (topic_id, id);
+CREATE INDEX IF NOT EXISTS item_events_item_idx ON item_events (item_id);
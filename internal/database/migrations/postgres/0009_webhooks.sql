@@ -0,0 +1,29 @@
+-- webhooks are the outbound subscriptions to item events. last_event_id is
+-- the newest event handed to the webhook, delivered or given up on.
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id            BIGSERIAL PRIMARY KEY,
+    url           TEXT        NOT NULL,
+    secret        TEXT        NOT NULL,
+    event_types   TEXT        NOT NULL,
+    topic_id      int8 REFERENCES Topic (id),
+    last_event_id int8        NOT NULL DEFAULT 0,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+-- webhook_deliveries logs every attempt to deliver a payload to a webhook.
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id          BIGSERIAL PRIMARY KEY,
+    webhook_id  int8        NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id    int8,
+    event_type  TEXT        NOT NULL,
+    attempt     int8        NOT NULL,
+    status_code int8,
+    error       TEXT,
+    success     BOOLEAN     NOT NULL,
+    duration_ms int8        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
@@ -0,0 +1,3 @@
+-- last_read_at is when the API last served a topic, so the scheduler can
+-- leave topics nobody reads alone.
+ALTER TABLE Topic ADD COLUMN last_read_at TIMESTAMPTZ;
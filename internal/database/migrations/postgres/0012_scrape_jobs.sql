@@ -0,0 +1,23 @@
+-- scrape_jobs are scrapes requested through POST /v1/jobs and run in the
+-- background. pages is the requested depth; the other counters are the
+-- progress so far, kept so an interrupted job resumes where it stopped.
+CREATE TABLE IF NOT EXISTS scrape_jobs
+(
+    id            BIGSERIAL PRIMARY KEY,
+    query         TEXT        NOT NULL,
+    search_order  TEXT        NOT NULL,
+    currency      TEXT        NOT NULL,
+    pages         int8        NOT NULL,
+    status        TEXT        NOT NULL,
+    topic_id      int8 REFERENCES Topic (id),
+    pages_fetched int8        NOT NULL DEFAULT 0,
+    items_new     int8        NOT NULL DEFAULT 0,
+    items_updated int8        NOT NULL DEFAULT 0,
+    errors        int8        NOT NULL DEFAULT 0,
+    last_error    TEXT,
+    created_at    TIMESTAMPTZ NOT NULL,
+    started_at    TIMESTAMPTZ,
+    finished_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS scrape_jobs_status_idx ON scrape_jobs (status, id);
@@ -0,0 +1,20 @@
+-- scrape_jobs becomes a work queue shared by every replica. Workers claim
+-- jobs with FOR UPDATE SKIP LOCKED and hold them under a lease they renew
+-- by heartbeat; a job whose lease expires is claimed again. Failed jobs are
+-- retried once run_after passes until max_attempts, then dead-lettered.
+-- dedupe_key keeps one active job per key, so replicas scheduling the same
+-- topic queue it once.
+ALTER TABLE scrape_jobs ADD COLUMN kind TEXT NOT NULL DEFAULT 'api';
+ALTER TABLE scrape_jobs ADD COLUMN priority int8 NOT NULL DEFAULT 0;
+ALTER TABLE scrape_jobs ADD COLUMN dedupe_key TEXT;
+ALTER TABLE scrape_jobs ADD COLUMN attempts int8 NOT NULL DEFAULT 0;
+ALTER TABLE scrape_jobs ADD COLUMN max_attempts int8 NOT NULL DEFAULT 1;
+ALTER TABLE scrape_jobs ADD COLUMN run_after TIMESTAMPTZ;
+ALTER TABLE scrape_jobs ADD COLUMN lease_owner TEXT;
+ALTER TABLE scrape_jobs ADD COLUMN lease_expires_at TIMESTAMPTZ;
+
+UPDATE scrape_jobs SET status = 'dead' WHERE status = 'failed';
+
+DROP INDEX IF EXISTS scrape_jobs_status_idx;
+CREATE INDEX IF NOT EXISTS scrape_jobs_queue_idx ON scrape_jobs (status, priority DESC, id);
+CREATE UNIQUE INDEX IF NOT EXISTS scrape_jobs_dedupe_idx ON scrape_jobs (dedupe_key) WHERE status IN ('queued', 'running');
@@ -0,0 +1,8 @@
+-- Item_Topic records every topic that scraped an item. Item.topic_id only
+-- keeps the last one, so an item found by two topics belongs to both.
+CREATE UNIQUE INDEX IF NOT EXISTS item_topic_idx ON Item_Topic (item_id, topic_id);
+CREATE INDEX IF NOT EXISTS item_topic_topic_idx ON Item_Topic (topic_id, item_id);
+
+INSERT INTO Item_Topic (item_id, topic_id)
+SELECT id, topic_id FROM Item WHERE topic_id IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,16 @@
+-- topic_schedules is the scheduler's state for each topic, saved by the
+-- replica running the scheduler so that every replica can report it.
+-- scheduler is the instance that saved it, as the leader election names it.
+CREATE TABLE IF NOT EXISTS topic_schedules
+(
+    topic_id    int8 PRIMARY KEY REFERENCES Topic (id),
+    scheduler   TEXT NOT NULL,
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    last_status TEXT,
+    last_job_id int8,
+    last_error  TEXT,
+    runs        int8 NOT NULL DEFAULT 0,
+    failures    int8 NOT NULL DEFAULT 0,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
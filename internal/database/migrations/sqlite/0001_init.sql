@@ -0,0 +1,99 @@
+CREATE TABLE IF NOT EXISTS Colour
+(
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS SIZE
+(
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS Topic
+(
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS Photos
+(
+    id                  INTEGER PRIMARY KEY,
+    ImageNo             INTEGER NOT NULL,
+    Width               INTEGER NOT NULL,
+    Height              INTEGER NOT NULL,
+    DominantColor       TEXT    NOT NULL,
+    DominantColorOpaque TEXT    NOT NULL,
+    URL                 TEXT    NOT NULL,
+    IsMain              BOOLEAN NOT NULL,
+    HighResolution      TEXT,
+    IsSuspicious        BOOLEAN NOT NULL,
+    FullSizeURL         TEXT    NOT NULL,
+    IsHidden            BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS Thumbnails
+(
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    Type     TEXT    NOT NULL,
+    URL      TEXT    NOT NULL,
+    Width    INTEGER NOT NULL,
+    Height   INTEGER NOT NULL,
+    photo_id INTEGER NOT NULL,
+    FOREIGN KEY (photo_id) REFERENCES Photos (id)
+);
+
+CREATE TABLE IF NOT EXISTS Item
+(
+    id                       INTEGER PRIMARY KEY,
+    title                    TEXT    NOT NULL,
+    price                    NUMERIC NOT NULL,
+    is_visible               INTEGER NOT NULL,
+    discount                 NUMERIC,
+    currency                 TEXT    NOT NULL,
+    brand_title              TEXT    NOT NULL,
+    user_id                  INTEGER NOT NULL,
+    url                      TEXT    NOT NULL,
+    promoted                 BOOLEAN NOT NULL,
+    photo_id                 INTEGER NOT NULL,
+    favourite_count          INTEGER NOT NULL,
+    is_favourite             BOOLEAN NOT NULL,
+    badge                    TEXT,
+    conversion               TEXT,
+    service_fee              TEXT    NOT NULL,
+    total_item_price         NUMERIC NOT NULL,
+    total_item_price_rounded NUMERIC,
+    view_count               INTEGER NOT NULL,
+    size_title               TEXT    NOT NULL,
+    content_source           TEXT    NOT NULL,
+    status                   TEXT,
+    icon_badges              TEXT,
+    search_tracking_params   TEXT,
+    topic_id                 INTEGER,
+    FOREIGN KEY (topic_id) REFERENCES Topic (id),
+    FOREIGN KEY (photo_id) REFERENCES Photos (id)
+);
+
+CREATE TABLE IF NOT EXISTS Item_Topic
+(
+    topic_id INTEGER NOT NULL,
+    item_id  INTEGER NOT NULL,
+    FOREIGN KEY (topic_id) REFERENCES Topic (id),
+    FOREIGN KEY (item_id) REFERENCES Item (id)
+);
+
+CREATE TABLE IF NOT EXISTS Item_Colour
+(
+    colour_id INTEGER NOT NULL,
+    item_id   INTEGER NOT NULL,
+    FOREIGN KEY (colour_id) REFERENCES Colour (id),
+    FOREIGN KEY (item_id) REFERENCES Item (id)
+);
+
+CREATE TABLE IF NOT EXISTS Item_Size
+(
+    size_id INTEGER NOT NULL,
+    item_id INTEGER NOT NULL,
+    FOREIGN KEY (size_id) REFERENCES SIZE (id),
+    FOREIGN KEY (item_id) REFERENCES Item (id)
+);
@@ -0,0 +1,11 @@
+ALTER TABLE Item ADD COLUMN user_login TEXT;
+ALTER TABLE Item ADD COLUMN user_business BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE Item ADD COLUMN first_seen_at TIMESTAMP;
+ALTER TABLE Item ADD COLUMN last_seen_at TIMESTAMP;
+UPDATE Item SET first_seen_at = CURRENT_TIMESTAMP, last_seen_at = CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS item_topic_idx ON Item (topic_id);
+CREATE INDEX IF NOT EXISTS item_brand_idx ON Item (LOWER(brand_title));
+CREATE INDEX IF NOT EXISTS item_user_idx ON Item (user_id);
+CREATE INDEX IF NOT EXISTS item_first_seen_idx ON Item (first_seen_at, id);
+CREATE INDEX IF NOT EXISTS item_price_idx ON Item (price, id);
@@ -0,0 +1,35 @@
+ALTER TABLE Item ADD COLUMN domain TEXT NOT NULL DEFAULT 'co.uk';
+
+-- item_search indexes the searchable Item columns; the triggers below keep it
+-- in step with the Item table it reads its content from.
+CREATE VIRTUAL TABLE IF NOT EXISTS item_search USING fts5
+(
+    title,
+    brand_title,
+    size_title,
+    content = 'Item',
+    content_rowid = 'id',
+    tokenize = 'porter unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS item_search_insert AFTER INSERT ON Item
+BEGIN
+    INSERT INTO item_search (rowid, title, brand_title, size_title)
+    VALUES (new.id, new.title, new.brand_title, new.size_title);
+END;
+
+CREATE TRIGGER IF NOT EXISTS item_search_delete AFTER DELETE ON Item
+BEGIN
+    INSERT INTO item_search (item_search, rowid, title, brand_title, size_title)
+    VALUES ('delete', old.id, old.title, old.brand_title, old.size_title);
+END;
+
+CREATE TRIGGER IF NOT EXISTS item_search_update AFTER UPDATE ON Item
+BEGIN
+    INSERT INTO item_search (item_search, rowid, title, brand_title, size_title)
+    VALUES ('delete', old.id, old.title, old.brand_title, old.size_title);
+    INSERT INTO item_search (rowid, title, brand_title, size_title)
+    VALUES (new.id, new.title, new.brand_title, new.size_title);
+END;
+
+INSERT INTO item_search (item_search) VALUES ('rebuild');
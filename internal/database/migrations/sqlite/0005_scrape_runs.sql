@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS scrape_runs
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic         TEXT      NOT NULL,
+    topic_id      INTEGER REFERENCES Topic (id),
+    query_params  TEXT      NOT NULL,
+    domain        TEXT      NOT NULL,
+    started_at    TIMESTAMP NOT NULL,
+    finished_at   TIMESTAMP NOT NULL,
+    pages         INTEGER   NOT NULL DEFAULT 0,
+    items_new     INTEGER   NOT NULL DEFAULT 0,
+    items_updated INTEGER   NOT NULL DEFAULT 0,
+    http_status   INTEGER,
+    error_class   TEXT,
+    error_message TEXT
+);
+
+CREATE INDEX IF NOT EXISTS scrape_runs_topic_idx ON scrape_runs (topic_id, id);
@@ -0,0 +1,16 @@
+-- item_events is the feed of changes ingestion makes to items, read by the
+-- topic streams. Its ids are the event ids clients resume from.
+CREATE TABLE IF NOT EXISTS item_events
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic_id       INTEGER   NOT NULL REFERENCES Topic (id),
+    item_id        INTEGER   NOT NULL REFERENCES Item (id) ON DELETE CASCADE,
+    kind           TEXT      NOT NULL,
+    price          NUMERIC   NOT NULL,
+    previous_price NUMERIC,
+    currency       TEXT      NOT NULL,
+    created_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS item_events_topic_idx ON item_events (topic_id, id);
+CREATE INDEX IF NOT EXISTS item_events_item_idx ON item_events (item_id);
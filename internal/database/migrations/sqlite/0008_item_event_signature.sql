@@ -0,0 +1,3 @@
+-- Removed events name the search the item left, since it is no longer
+-- among that search's results.
+ALTER TABLE item_events ADD COLUMN signature TEXT;
@@ -0,0 +1,29 @@
+-- webhooks are the outbound subscriptions to item events. last_event_id is
+-- the newest event handed to the webhook, delivered or given up on.
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    url           TEXT      NOT NULL,
+    secret        TEXT      NOT NULL,
+    event_types   TEXT      NOT NULL,
+    topic_id      INTEGER REFERENCES Topic (id),
+    last_event_id INTEGER   NOT NULL DEFAULT 0,
+    created_at    TIMESTAMP NOT NULL
+);
+
+-- webhook_deliveries logs every attempt to deliver a payload to a webhook.
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id  INTEGER   NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id    INTEGER,
+    event_type  TEXT      NOT NULL,
+    attempt     INTEGER   NOT NULL,
+    status_code INTEGER,
+    error       TEXT,
+    success     BOOLEAN   NOT NULL,
+    duration_ms INTEGER   NOT NULL,
+    created_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
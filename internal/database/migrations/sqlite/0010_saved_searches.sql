@@ -0,0 +1,45 @@
+-- saved_searches own a full search query; filters and the conditions of
+-- alert_rules are canonical query strings (see ItemQuery.FilterValues).
+CREATE TABLE IF NOT EXISTS saved_searches
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    name         TEXT      NOT NULL,
+    text         TEXT      NOT NULL,
+    search_order TEXT      NOT NULL,
+    domain       TEXT      NOT NULL,
+    currency     TEXT      NOT NULL,
+    filters      TEXT      NOT NULL,
+    created_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alert_rules
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    saved_search_id INTEGER   NOT NULL REFERENCES saved_searches (id) ON DELETE CASCADE,
+    name            TEXT      NOT NULL,
+    conditions      TEXT      NOT NULL,
+    created_at      TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS alert_rules_saved_search_idx ON alert_rules (saved_search_id);
+
+-- alerts are the items ingestion found matching an alert rule, once per
+-- rule, item and price.
+CREATE TABLE IF NOT EXISTS alerts
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_id         INTEGER   NOT NULL REFERENCES alert_rules (id) ON DELETE CASCADE,
+    saved_search_id INTEGER   NOT NULL REFERENCES saved_searches (id) ON DELETE CASCADE,
+    topic_id        INTEGER   NOT NULL REFERENCES Topic (id),
+    item_id         INTEGER   NOT NULL REFERENCES Item (id) ON DELETE CASCADE,
+    price           NUMERIC   NOT NULL,
+    currency        TEXT      NOT NULL,
+    created_at      TIMESTAMP NOT NULL,
+    UNIQUE (rule_id, item_id, price)
+);
+
+CREATE INDEX IF NOT EXISTS alerts_saved_search_idx ON alerts (saved_search_id, id);
+CREATE INDEX IF NOT EXISTS alerts_item_idx ON alerts (item_id);
+
+-- last_alert_id is the newest alert handed to a webhook subscribed to alerts.
+ALTER TABLE webhooks ADD COLUMN last_alert_id INTEGER NOT NULL DEFAULT 0;
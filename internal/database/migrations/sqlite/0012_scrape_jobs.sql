@@ -0,0 +1,23 @@
+-- scrape_jobs are scrapes requested through POST /v1/jobs and run in the
+-- background. pages is the requested depth; the other counters are the
+-- progress so far, kept so an interrupted job resumes where it stopped.
+CREATE TABLE IF NOT EXISTS scrape_jobs
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    query         TEXT      NOT NULL,
+    search_order  TEXT      NOT NULL,
+    currency      TEXT      NOT NULL,
+    pages         INTEGER   NOT NULL,
+    status        TEXT      NOT NULL,
+    topic_id      INTEGER REFERENCES Topic (id),
+    pages_fetched INTEGER   NOT NULL DEFAULT 0,
+    items_new     INTEGER   NOT NULL DEFAULT 0,
+    items_updated INTEGER   NOT NULL DEFAULT 0,
+    errors        INTEGER   NOT NULL DEFAULT 0,
+    last_error    TEXT,
+    created_at    TIMESTAMP NOT NULL,
+    started_at    TIMESTAMP,
+    finished_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS scrape_jobs_status_idx ON scrape_jobs (status, id);
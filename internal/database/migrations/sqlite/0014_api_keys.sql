@@ -0,0 +1,17 @@
+-- api_keys authenticate API clients. Only the SHA-256 of a key is stored;
+-- prefix is the start of the key, kept to tell keys apart. rate_limit
+-- (requests per minute) and scrape_limit (live scrapes per hour) override
+-- the server's defaults when positive.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    name         TEXT      NOT NULL,
+    prefix       TEXT      NOT NULL,
+    key_hash     TEXT      NOT NULL UNIQUE,
+    admin        BOOLEAN   NOT NULL DEFAULT FALSE,
+    rate_limit   INTEGER   NOT NULL DEFAULT 0,
+    scrape_limit INTEGER   NOT NULL DEFAULT 0,
+    created_at   TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at   TIMESTAMP
+);
@@ -0,0 +1,22 @@
+-- API keys get a role instead of the admin flag: readers may read,
+-- operators may also change webhooks, saved searches and jobs, and admins
+-- may also manage keys and read the audit log.
+ALTER TABLE api_keys ADD COLUMN role TEXT NOT NULL DEFAULT 'reader';
+UPDATE api_keys SET role = 'admin' WHERE admin;
+ALTER TABLE api_keys DROP COLUMN admin;
+
+-- audit_log records every privileged action, allowed or not. actor is the
+-- prefix of the API key, or who acted without one (such as the CLI).
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    api_key_id INTEGER REFERENCES api_keys (id),
+    actor      TEXT      NOT NULL,
+    action     TEXT      NOT NULL,
+    path       TEXT      NOT NULL,
+    status     INTEGER   NOT NULL,
+    request_id TEXT,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_api_key_idx ON audit_log (api_key_id, id);
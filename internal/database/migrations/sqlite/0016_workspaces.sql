@@ -0,0 +1,36 @@
+-- workspaces separate the teams sharing a deployment. A workspace owns its
+-- saved searches, webhooks, API keys and jobs, and sees the topics in
+-- workspace_topics; topics and their items stay shared, so a search made by
+-- two workspaces is scraped once. Everything so far belongs to workspace 1.
+-- SQLite cannot add a column with a foreign key and a default, so the
+-- workspace_id columns below go unchecked.
+CREATE TABLE IF NOT EXISTS workspaces
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT      NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO workspaces (id, name) VALUES (1, 'default') ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS workspace_topics
+(
+    workspace_id INTEGER   NOT NULL REFERENCES workspaces (id),
+    topic_id     INTEGER   NOT NULL REFERENCES Topic (id) ON DELETE CASCADE,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (workspace_id, topic_id)
+);
+INSERT INTO workspace_topics (workspace_id, topic_id) SELECT 1, id FROM Topic WHERE true ON CONFLICT DO NOTHING;
+
+ALTER TABLE saved_searches ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE webhooks ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE api_keys ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+-- Scheduled jobs refresh shared topics and belong to no workspace.
+ALTER TABLE scrape_jobs ADD COLUMN workspace_id INTEGER REFERENCES workspaces (id);
+UPDATE scrape_jobs SET workspace_id = 1 WHERE kind = 'api';
+ALTER TABLE audit_log ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS saved_searches_workspace_idx ON saved_searches (workspace_id, id);
+CREATE INDEX IF NOT EXISTS webhooks_workspace_idx ON webhooks (workspace_id, id);
+CREATE INDEX IF NOT EXISTS api_keys_workspace_idx ON api_keys (workspace_id, id);
+CREATE INDEX IF NOT EXISTS scrape_jobs_workspace_idx ON scrape_jobs (workspace_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_workspace_idx ON audit_log (workspace_id, id);
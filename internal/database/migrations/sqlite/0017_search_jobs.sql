@@ -0,0 +1,4 @@
+-- Live searches are scraped through the job queue too, so replicas serving
+-- the same search share one scrape. signature is the search the job
+-- answers, with the filters its results are saved under.
+ALTER TABLE scrape_jobs ADD COLUMN signature TEXT;
@@ -0,0 +1,16 @@
+-- topic_schedules is the scheduler's state for each topic, saved by the
+-- replica running the scheduler so that every replica can report it.
+-- scheduler is the instance that saved it, as the leader election names it.
+CREATE TABLE IF NOT EXISTS topic_schedules
+(
+    topic_id    INTEGER PRIMARY KEY REFERENCES Topic (id),
+    scheduler   TEXT NOT NULL,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_status TEXT,
+    last_job_id INTEGER,
+    last_error  TEXT,
+    runs        INTEGER NOT NULL DEFAULT 0,
+    failures    INTEGER NOT NULL DEFAULT 0,
+    updated_at  TIMESTAMP NOT NULL
+);
@@ -0,0 +1,388 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	vinted_scraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// ItemSort names the column stored items are ordered by.
+type ItemSort string
+
+const (
+	SortPrice      ItemSort = "price"
+	SortFavourites ItemSort = "favourites"
+	SortViews      ItemSort = "views"
+	SortRecency    ItemSort = "recency"
+	// SortRelevance keeps the order a search returned its items in; it
+	// requires ItemQuery.Signature.
+	SortRelevance ItemSort = "relevance"
+)
+
+// sortColumns maps each ItemSort to the Item column it orders by.
+var sortColumns = map[ItemSort]string{
+	SortPrice:      "Item.price",
+	SortFavourites: "Item.favourite_count",
+	SortViews:      "Item.view_count",
+	SortRecency:    "Item.first_seen_at",
+}
+
+const (
+	DefaultItemLimit = 50
+	MaxItemLimit     = 200
+)
+
+// ItemQuery filters, orders and pages the stored items. Zero values mean
+// "no filter"; Sort defaults to recency, newest first.
+type ItemQuery struct {
+	TopicID  int64
+	Topic    string
+	Brand    string
+	Size     string
+	MinPrice *float64
+	MaxPrice *float64
+	Status   string
+	SellerID int
+	Business *bool
+	// SeenAfter and SeenBefore bound when an item was first scraped.
+	SeenAfter  time.Time
+	SeenBefore time.Time
+	// Signature restricts the items to the stored results of a search.
+	Signature string
+
+	// WorkspaceID restricts the items to the topics a workspace sees, see
+	// AllWorkspaces. It is set by the server, not parsed.
+	WorkspaceID int64
+
+	Sort ItemSort
+	Desc bool
+	// Limit caps the page size; it defaults to DefaultItemLimit and is clamped to MaxItemLimit.
+	Limit int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+}
+
+// ItemPage is one page of a QueryItems result. NextCursor is empty on the last page.
+type ItemPage struct {
+	Items      []vinted_scraper.Item `json:"items"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// ParseItemSort parses "price", "favourites", "views" or "recency", with an
+// optional leading "-" for descending order. An empty string sorts by recency, newest first.
+func ParseItemSort(value string) (ItemSort, bool, error) {
+	if value == "" {
+		return SortRecency, true, nil
+	}
+	desc := strings.HasPrefix(value, "-")
+	sort := ItemSort(strings.TrimPrefix(value, "-"))
+	if _, ok := sortColumns[sort]; !ok {
+		return "", false, fmt.Errorf("unknown sort %q", value)
+	}
+	return sort, desc, nil
+}
+
+// ErrInvalidCursor is returned by QueryItems for a cursor that is malformed or
+// was issued for a different sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// itemCursor is the keyset position a page ended on: the sort key and id of its last item.
+type itemCursor struct {
+	Sort ItemSort `json:"s"`
+	Desc bool     `json:"d"`
+	Key  string   `json:"k"`
+	ID   int      `json:"i"`
+}
+
+func encodeCursor(c itemCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (itemCursor, error) {
+	var c itemCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// cursorKey returns the sort key of item in the form stored in a cursor.
+func cursorKey(sort ItemSort, item vinted_scraper.Item, firstSeen time.Time, position int64) string {
+	switch sort {
+	case SortRelevance:
+		return fmt.Sprint(position)
+	case SortPrice:
+		return item.Price
+	case SortFavourites:
+		return fmt.Sprint(item.FavouriteCount)
+	case SortViews:
+		return fmt.Sprint(item.ViewCount)
+	default:
+		return firstSeen.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// cursorArg converts a cursor key back into a value comparable with the sort column.
+func cursorArg(sort ItemSort, key string) (interface{}, error) {
+	switch sort {
+	case SortPrice:
+		var price float64
+		_, err := fmt.Sscan(key, &price)
+		return price, err
+	case SortFavourites, SortViews, SortRelevance:
+		var count int64
+		_, err := fmt.Sscan(key, &count)
+		return count, err
+	default:
+		return time.Parse(time.RFC3339Nano, key)
+	}
+}
+
+// whereBuilder accumulates SQL conditions with numbered placeholders.
+type whereBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add appends a condition in which every "?" is replaced by the next placeholder.
+func (w *whereBuilder) add(condition string, args ...interface{}) {
+	for _, arg := range args {
+		w.args = append(w.args, arg)
+		condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(w.args)), 1)
+	}
+	w.conditions = append(w.conditions, condition)
+}
+
+func (w *whereBuilder) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+// itemFilters translates the filters of q (everything but ordering and paging) into conditions.
+func itemFilters(q ItemQuery) *whereBuilder {
+	w := &whereBuilder{}
+	w.addItemFilters(q)
+	return w
+}
+
+// addItemFilters appends the conditions of itemFilters to w.
+func (w *whereBuilder) addItemFilters(q ItemQuery) {
+	w.addWorkspace(itemInWorkspace, q.WorkspaceID)
+	if q.TopicID != 0 {
+		w.add("Item.id IN (SELECT item_id FROM Item_Topic WHERE topic_id = ?)", q.TopicID)
+	}
+	if q.Topic != "" {
+		w.add("Item.id IN (SELECT item_id FROM Item_Topic WHERE topic_id = (SELECT id FROM Topic WHERE name = ?))", q.Topic)
+	}
+	if q.Brand != "" {
+		w.add("LOWER(Item.brand_title) = LOWER(?)", q.Brand)
+	}
+	if q.Size != "" {
+		w.add("LOWER(Item.size_title) = LOWER(?)", q.Size)
+	}
+	if q.MinPrice != nil {
+		w.add("Item.price >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		w.add("Item.price <= ?", *q.MaxPrice)
+	}
+	if q.Status != "" {
+		w.add("LOWER(Item.status) = LOWER(?)", q.Status)
+	}
+	if q.SellerID != 0 {
+		w.add("Item.user_id = ?", q.SellerID)
+	}
+	if q.Business != nil {
+		w.add("Item.user_business = ?", *q.Business)
+	}
+	if !q.SeenAfter.IsZero() {
+		w.add("Item.first_seen_at >= ?", q.SeenAfter.UTC())
+	}
+	if !q.SeenBefore.IsZero() {
+		w.add("Item.first_seen_at < ?", q.SeenBefore.UTC())
+	}
+	if q.Signature != "" {
+		w.add("search_results.signature = ?", q.Signature)
+	}
+}
+
+// QueryItems returns one page of stored items matching q, using keyset
+// pagination on (sort column, id) so pages stay stable while items are ingested.
+func (s *service) QueryItems(ctx context.Context, q ItemQuery) (ItemPage, error) {
+	if q.Sort == "" {
+		q.Sort, q.Desc = SortRecency, true
+	}
+	column, ok := sortColumns[q.Sort]
+	if q.Sort == SortRelevance && q.Signature != "" {
+		column, ok = "search_results.position", true
+	}
+	if !ok {
+		return ItemPage{}, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+
+	w := itemFilters(q)
+	direction, comparison := "ASC", ">"
+	if q.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return ItemPage{}, err
+		}
+		if cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return ItemPage{}, fmt.Errorf("%w: issued for a different sort", ErrInvalidCursor)
+		}
+		key, err := cursorArg(cursor.Sort, cursor.Key)
+		if err != nil {
+			return ItemPage{}, ErrInvalidCursor
+		}
+		w.add(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND Item.id %[2]s ?))", column, comparison), key, key, cursor.ID)
+	}
+
+	join, position := "", "0"
+	if q.Signature != "" {
+		join, position = "JOIN search_results ON search_results.item_id = Item.id", "search_results.position"
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	query := fmt.Sprintf(`
+        SELECT %s, Item.first_seen_at, %s
+        FROM Item
+        JOIN photos ON Item.photo_id = photos.id
+        %s
+        %s
+        ORDER BY %s %s, Item.id %s
+        LIMIT %d`, itemColumns, position, join, w, column, direction, direction, q.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return ItemPage{}, err
+	}
+	defer rows.Close()
+
+	page := ItemPage{Items: []vinted_scraper.Item{}}
+	var lastSeen time.Time
+	var lastPosition int64
+	for rows.Next() {
+		var firstSeen time.Time
+		var position int64
+		item, err := scanItem(rows, &firstSeen, &position)
+		if err != nil {
+			return ItemPage{}, err
+		}
+		if len(page.Items) == q.Limit {
+			last := page.Items[len(page.Items)-1]
+			page.NextCursor = encodeCursor(itemCursor{
+				Sort: q.Sort,
+				Desc: q.Desc,
+				Key:  cursorKey(q.Sort, last, lastSeen, lastPosition),
+				ID:   last.ID,
+			})
+			break
+		}
+		page.Items = append(page.Items, item)
+		lastSeen, lastPosition = firstSeen, position
+	}
+	return page, rows.Err()
+}
+
+// ParseItemQuery reads the item filters from the query string:
+//
+//	topic, topic_id, brand, size, status, seller, business (true/false),
+//	min_price, max_price, seen_after, seen_before (RFC 3339),
+//	sort (price, favourites, views, recency; prefix "-" for descending),
+//	limit, cursor
+func ParseItemQuery(values url.Values) (ItemQuery, error) {
+	q := ItemQuery{
+		Topic:  values.Get("topic"),
+		Brand:  values.Get("brand"),
+		Size:   values.Get("size"),
+		Status: values.Get("status"),
+		Cursor: values.Get("cursor"),
+	}
+	var err error
+
+	if v := values.Get("topic_id"); v != "" {
+		if q.TopicID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, fmt.Errorf("invalid topic_id %q", v)
+		}
+	}
+	if v := values.Get("seller"); v != "" {
+		if q.SellerID, err = strconv.Atoi(v); err != nil {
+			return q, fmt.Errorf("invalid seller %q", v)
+		}
+	}
+	if v := values.Get("business"); v != "" {
+		business, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid business %q", v)
+		}
+		q.Business = &business
+	}
+	if q.MinPrice, err = parsePrice(values, "min_price"); err != nil {
+		return q, err
+	}
+	if q.MaxPrice, err = parsePrice(values, "max_price"); err != nil {
+		return q, err
+	}
+	if q.SeenAfter, err = parseTime(values, "seen_after"); err != nil {
+		return q, err
+	}
+	if q.SeenBefore, err = parseTime(values, "seen_before"); err != nil {
+		return q, err
+	}
+	if q.Sort, q.Desc, err = ParseItemSort(values.Get("sort")); err != nil {
+		return q, err
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+func parsePrice(values url.Values, name string) (*float64, error) {
+	v := values.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	price, err := strconv.ParseFloat(v, 64)
+	if err != nil || price < 0 {
+		return nil, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return &price, nil
+}
+
+func parseTime(values url.Values, name string) (time.Time, error) {
+	v := values.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("invalid %s %q: expected RFC 3339", name, v)
+	}
+	return t, nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetentionPolicy describes what Prune removes. A zero duration keeps that
+// data forever.
+type RetentionPolicy struct {
+	// ItemMaxAge removes items that no scrape has returned for this long,
+	// together with their price history.
+	ItemMaxAge time.Duration
+	// PriceHistoryMaxAge removes price observations older than this.
+	PriceHistoryMaxAge time.Duration
+	// Orphans removes photos no item references, their thumbnails and
+	// duplicate thumbnails left behind by repeated scrapes.
+	Orphans bool
+}
+
+// DefaultRetentionPolicy keeps items for 90 days after they were last seen,
+// price history for a year, and cleans up orphans.
+var DefaultRetentionPolicy = RetentionPolicy{
+	ItemMaxAge:         90 * 24 * time.Hour,
+	PriceHistoryMaxAge: 365 * 24 * time.Hour,
+	Orphans:            true,
+}
+
+// RetentionPolicyFromEnv returns DefaultRetentionPolicy overridden by
+// RETENTION_ITEM_DAYS, RETENTION_PRICE_HISTORY_DAYS (0 keeps forever) and
+// RETENTION_ORPHANS (true/false).
+func RetentionPolicyFromEnv() (RetentionPolicy, error) {
+	policy := DefaultRetentionPolicy
+	days := func(name string, target *time.Duration) error {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		*target = time.Duration(n) * 24 * time.Hour
+		return nil
+	}
+	if err := days("RETENTION_ITEM_DAYS", &policy.ItemMaxAge); err != nil {
+		return policy, err
+	}
+	if err := days("RETENTION_PRICE_HISTORY_DAYS", &policy.PriceHistoryMaxAge); err != nil {
+		return policy, err
+	}
+	if v := os.Getenv("RETENTION_ORPHANS"); v != "" {
+		orphans, err := strconv.ParseBool(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid RETENTION_ORPHANS %q", v)
+		}
+		policy.Orphans = orphans
+	}
+	return policy, nil
+}
+
+// PruneReport counts the rows Prune removed, or would remove on a dry run.
+type PruneReport struct {
+	DryRun       bool  `json:"dry_run"`
+	Items        int64 `json:"items"`
+	PriceHistory int64 `json:"price_history"`
+	Thumbnails   int64 `json:"thumbnails"`
+	Photos       int64 `json:"photos"`
+}
+
+// Prune deletes the data policy no longer retains. A dry run performs the
+// same deletes inside a transaction that is rolled back, so its report is
+// exactly what a real run would remove at that moment.
+func (s *service) Prune(ctx context.Context, policy RetentionPolicy, dryRun bool) (PruneReport, error) {
+	report := PruneReport{DryRun: dryRun}
+	now := time.Now().UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	// Each step deletes rows and adds the number removed to its counter.
+	type step struct {
+		counter *int64
+		query   string
+		args    []interface{}
+	}
+	var ignored int64
+	var steps []step
+
+	if policy.ItemMaxAge > 0 {
+		cutoff := now.Add(-policy.ItemMaxAge)
+		steps = append(steps,
+			// Unused link tables still reference items, clear them first.
+			step{&ignored, "DELETE FROM Item_Topic WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&ignored, "DELETE FROM Item_Colour WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&ignored, "DELETE FROM Item_Size WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&ignored, "DELETE FROM item_events WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&ignored, "DELETE FROM search_results WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&ignored, "DELETE FROM alerts WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&report.PriceHistory, "DELETE FROM price_history WHERE item_id IN (SELECT id FROM Item WHERE last_seen_at < $1)", []interface{}{cutoff}},
+			step{&report.Items, "DELETE FROM Item WHERE last_seen_at < $1", []interface{}{cutoff}},
+		)
+	}
+	if policy.PriceHistoryMaxAge > 0 {
+		steps = append(steps, step{&report.PriceHistory, "DELETE FROM price_history WHERE observed_at < $1", []interface{}{now.Add(-policy.PriceHistoryMaxAge)}})
+	}
+	if policy.Orphans {
+		steps = append(steps,
+			step{&report.Thumbnails, `DELETE FROM Thumbnails
+				WHERE photo_id NOT IN (SELECT photo_id FROM Item)
+				   OR id > (SELECT MIN(t.id) FROM Thumbnails t
+				            WHERE t.photo_id = Thumbnails.photo_id AND t.Type = Thumbnails.Type AND t.URL = Thumbnails.URL)`, nil},
+			step{&report.Photos, "DELETE FROM Photos WHERE id NOT IN (SELECT photo_id FROM Item)", nil},
+		)
+	}
+
+	for _, step := range steps {
+		result, err := tx.ExecContext(ctx, step.query, step.args...)
+		if err != nil {
+			return report, fmt.Errorf("error pruning: %v", err)
+		}
+		n, _ := result.RowsAffected()
+		*step.counter += n
+	}
+
+	if dryRun {
+		return report, tx.Rollback()
+	}
+	return report, tx.Commit()
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ScrapeRun records one scrape of a topic: what was asked of Vinted, how
+// long it took and what came back. ErrorClass is empty for successful runs.
+type ScrapeRun struct {
+	ID           int64     `json:"id"`
+	Topic        string    `json:"topic"`
+	TopicID      int64     `json:"topic_id,omitempty"`
+	QueryParams  string    `json:"query_params"`
+	Domain       string    `json:"domain"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Pages        int       `json:"pages"`
+	ItemsNew     int       `json:"items_new"`
+	ItemsUpdated int       `json:"items_updated"`
+	HTTPStatus   int       `json:"http_status,omitempty"`
+	ErrorClass   string    `json:"error_class,omitempty"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+}
+
+// RunQuery selects scrape runs, newest first. TopicID 0 means every topic;
+// Before pages backwards from a run id.
+type RunQuery struct {
+	// WorkspaceID selects the runs of the topics a workspace sees.
+	WorkspaceID int64
+	TopicID     int64
+	Before      int64
+	Limit       int
+}
+
+// RecordScrapeRun stores run and returns its id. The run is linked to its
+// topic by name, so runs that failed before the topic was created have no topic id.
+func (s *service) RecordScrapeRun(ctx context.Context, run ScrapeRun) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO scrape_runs (
+			topic, topic_id, query_params, domain, started_at, finished_at,
+			pages, items_new, items_updated, http_status, error_class, error_message
+		) VALUES (
+			$1, (SELECT id FROM Topic WHERE name = $1), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		) RETURNING id`,
+		run.Topic, run.QueryParams, run.Domain, run.StartedAt.UTC(), run.FinishedAt.UTC(),
+		run.Pages, run.ItemsNew, run.ItemsUpdated, nullInt(run.HTTPStatus), nullString(run.ErrorClass), nullString(run.ErrorMessage),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error recording scrape run for %s: %v", run.Topic, err)
+	}
+	return id, nil
+}
+
+// ListScrapeRuns returns the runs selected by q, newest first.
+func (s *service) ListScrapeRuns(ctx context.Context, q RunQuery) ([]ScrapeRun, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+	w := &whereBuilder{}
+	w.addWorkspace("topic_id "+inWorkspace, q.WorkspaceID)
+	if q.TopicID != 0 {
+		w.add("topic_id = ?", q.TopicID)
+	}
+	if q.Before != 0 {
+		w.add("id < ?", q.Before)
+	}
+	query := fmt.Sprintf(`
+        SELECT %s
+        FROM scrape_runs
+        %s
+        ORDER BY id DESC
+        LIMIT %d`, runColumns, w, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScrapeRun{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// runColumns is the select list read by scanRun.
+const runColumns = `id, topic, topic_id, query_params, domain, started_at, finished_at,
+            pages, items_new, items_updated, http_status, error_class, error_message`
+
+func scanRun(rows *sql.Rows) (ScrapeRun, error) {
+	var run ScrapeRun
+	var topicID, httpStatus sql.NullInt64
+	var errorClass, errorMessage sql.NullString
+	err := rows.Scan(&run.ID, &run.Topic, &topicID, &run.QueryParams, &run.Domain, &run.StartedAt, &run.FinishedAt,
+		&run.Pages, &run.ItemsNew, &run.ItemsUpdated, &httpStatus, &errorClass, &errorMessage)
+	if err != nil {
+		return run, err
+	}
+	run.TopicID = topicID.Int64
+	run.HTTPStatus = int(httpStatus.Int64)
+	run.ErrorClass = errorClass.String
+	run.ErrorMessage = errorMessage.String
+	return run, nil
+}
+
+// nullString stores "" as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullInt stores 0 as NULL.
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	vinted_scraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// DefaultDomain is the Vinted domain items are scraped from unless stated otherwise.
+const DefaultDomain = "co.uk"
+
+// ErrEmptySearch is returned by SearchItems when the query has no text.
+var ErrEmptySearch = errors.New("empty search query")
+
+// SearchQuery is a full-text search over the stored items of one domain.
+type SearchQuery struct {
+	// WorkspaceID selects the items of the topics a workspace sees, see
+	// AllWorkspaces.
+	WorkspaceID int64
+	Text        string
+	// Domain selects both the items searched and the language used to stem
+	// the query; it defaults to DefaultDomain.
+	Domain string
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a stored item with its relevance to the search; higher ranks first.
+type SearchResult struct {
+	vinted_scraper.Item
+	Rank float64 `json:"rank"`
+}
+
+func (q *SearchQuery) normalize() error {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Text == "" {
+		return ErrEmptySearch
+	}
+	if q.Domain == "" {
+		q.Domain = DefaultDomain
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultItemLimit
+	}
+	if q.Limit > MaxItemLimit {
+		q.Limit = MaxItemLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	return nil
+}
+
+// SearchItems ranks items by full-text match on title, brand and size in the
+// domain's language, falling back to trigram similarity on title so misspelt
+// queries still find listings.
+func (s *service) SearchItems(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	w := q.filters(q.Text)
+	w.add("(Item.search_vector @@ query OR Item.title % $1 OR Item.brand_title % $1)")
+	query := `
+        SELECT ` + itemColumns + `,
+            ts_rank_cd(Item.search_vector, query) + similarity(Item.title, $1) AS rank
+        FROM Item
+        JOIN photos ON Item.photo_id = photos.id,
+            websearch_to_tsquery(vinted_search_config($2), $1) AS query
+        ` + w.String() + `
+        ORDER BY rank DESC, Item.id DESC
+        LIMIT $3 OFFSET $4`
+	return s.scanSearchResults(ctx, query, w.args...)
+}
+
+// filters starts the conditions of a search for match, whose arguments
+// are $1 match, $2 domain, $3 limit and $4 offset, with the domain and
+// workspace.
+func (q SearchQuery) filters(match string) *whereBuilder {
+	w := &whereBuilder{args: []interface{}{match, q.Domain, q.Limit, q.Offset}}
+	w.add("Item.domain = $2")
+	w.addWorkspace(itemInWorkspace, q.WorkspaceID)
+	return w
+}
+
+func (s *service) scanSearchResults(ctx context.Context, query string, args ...interface{}) ([]SearchResult, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		result.Item, err = scanItem(rows, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// SearchItems ranks items with the item_search FTS5 index. Every query term
+// is matched as a prefix; bm25 scores are negated so higher ranks first, as
+// on Postgres.
+func (s *sqliteService) SearchItems(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	match := ftsMatch(q.Text)
+	if match == "" {
+		return []SearchResult{}, nil
+	}
+	w := q.filters(match)
+	w.add("item_search MATCH $1")
+	query := `
+        SELECT ` + itemColumns + `,
+            -bm25(item_search, 10.0, 5.0, 1.0) AS rank
+        FROM item_search
+        JOIN Item ON Item.id = item_search.rowid
+        JOIN photos ON Item.photo_id = photos.id
+        ` + w.String() + `
+        ORDER BY rank DESC, Item.id DESC
+        LIMIT $3 OFFSET $4`
+	return s.scanSearchResults(ctx, query, w.args...)
+}
+
+// ftsMatch turns free text into an FTS5 query of quoted prefix terms, so
+// user input can never be parsed as FTS5 syntax.
+func ftsMatch(text string) string {
+	var terms []string
+	for _, field := range strings.Fields(text) {
+		field = strings.ReplaceAll(field, `"`, "")
+		if field != "" {
+			terms = append(terms, `"`+field+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
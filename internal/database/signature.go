@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is the currency searches are priced in when none is given.
+const DefaultCurrency = "GBP"
+
+// SearchSignature identifies a search independently of how it was spelled:
+// two requests with the same signature would get the same answer from a
+// live search, so one's results can answer the other.
+type SearchSignature struct {
+	// Text is the search text, lower-cased with runs of spaces collapsed.
+	Text     string
+	Order    string
+	Domain   string
+	Currency string
+	// Filters are the item filters applied to the results, as returned by
+	// ItemQuery.FilterValues. Vinted never sees them, see Unfiltered.
+	Filters url.Values
+}
+
+// Unfiltered returns the signature without its filters: the search Vinted
+// is asked, which keys the scrape and the stored order of its results. The
+// filters only narrow those results down when they are queried.
+func (s SearchSignature) Unfiltered() SearchSignature {
+	s.Filters = nil
+	return s
+}
+
+// NewSearchSignature normalizes a search into its signature. An empty
+// domain or currency stands for DefaultDomain and DefaultCurrency.
+func NewSearchSignature(text, order, domain, currency string, filters url.Values) SearchSignature {
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return SearchSignature{
+		Text:     strings.ToLower(strings.Join(strings.Fields(text), " ")),
+		Order:    order,
+		Domain:   strings.ToLower(domain),
+		Currency: strings.ToUpper(currency),
+		Filters:  filters,
+	}
+}
+
+// String returns the canonical form of the signature, a query string with
+// its keys sorted, used as the key of the search everywhere.
+func (s SearchSignature) String() string {
+	values := url.Values{}
+	for key, value := range s.Filters {
+		values[key] = value
+	}
+	values.Set("q", s.Text)
+	values.Set("order", s.Order)
+	values.Set("domain", s.Domain)
+	values.Set("currency", s.Currency)
+	return values.Encode()
+}
+
+// ParseSearchSignature parses the canonical form String returns.
+func ParseSearchSignature(v string) (SearchSignature, error) {
+	values, err := url.ParseQuery(v)
+	if err != nil {
+		return SearchSignature{}, fmt.Errorf("invalid search signature %q: %v", v, err)
+	}
+	sig := SearchSignature{
+		Text:     values.Get("q"),
+		Order:    values.Get("order"),
+		Domain:   values.Get("domain"),
+		Currency: values.Get("currency"),
+	}
+	for _, key := range []string{"q", "order", "domain", "currency"} {
+		values.Del(key)
+	}
+	if len(values) > 0 {
+		sig.Filters = values
+	}
+	return sig, nil
+}
+
+// FilterValues returns the filters of q in a canonical form: the names
+// ParseItemQuery reads them from, values normalized so equal filters
+// compare equal. The topic, ordering and paging are left out.
+func (q ItemQuery) FilterValues() url.Values {
+	values := url.Values{}
+	if q.Brand != "" {
+		values.Set("brand", strings.ToLower(q.Brand))
+	}
+	if q.Size != "" {
+		values.Set("size", strings.ToLower(q.Size))
+	}
+	if q.MinPrice != nil {
+		values.Set("min_price", strconv.FormatFloat(*q.MinPrice, 'f', -1, 64))
+	}
+	if q.MaxPrice != nil {
+		values.Set("max_price", strconv.FormatFloat(*q.MaxPrice, 'f', -1, 64))
+	}
+	if q.Status != "" {
+		values.Set("status", strings.ToLower(q.Status))
+	}
+	if q.SellerID != 0 {
+		values.Set("seller", strconv.Itoa(q.SellerID))
+	}
+	if q.Business != nil {
+		values.Set("business", strconv.FormatBool(*q.Business))
+	}
+	if !q.SeenAfter.IsZero() {
+		values.Set("seen_after", q.SeenAfter.UTC().Format(time.RFC3339))
+	}
+	if !q.SeenBefore.IsZero() {
+		values.Set("seen_before", q.SeenBefore.UTC().Format(time.RFC3339))
+	}
+	return values
+}
+
+// Search is the stored outcome of the last live search with a signature.
+type Search struct {
+	Signature string
+	TopicID   int64
+	FetchedAt time.Time
+}
+
+func (s *service) GetSearch(ctx context.Context, signature string) (Search, error) {
+	search := Search{Signature: signature}
+	var fetchedAt nullTime
+	err := s.db.QueryRowContext(ctx, "SELECT topic_id, fetched_at FROM searches WHERE signature = $1", signature).
+		Scan(&search.TopicID, &fetchedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return search, ErrNotFound
+	}
+	if err != nil {
+		return search, err
+	}
+	search.FetchedAt = fetchedAt.Time
+	return search, nil
+}
+
+// SaveSearch records that the search with signature returned itemIDs, in
+// that order, replacing the results it returned before, and records an
+// EventRemoved for each item that is no longer among them. Duplicate IDs
+// keep their first position.
+func (s *service) SaveSearch(ctx context.Context, search Search, itemIDs []int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO searches (signature, topic_id, fetched_at) VALUES ($1, $2, $3)
+        ON CONFLICT (signature) DO UPDATE SET topic_id = excluded.topic_id, fetched_at = excluded.fetched_at`,
+		search.Signature, search.TopicID, search.FetchedAt.UTC())
+	if err != nil {
+		return err
+	}
+	// Collect the previous results first: SQLite cannot write while rows are open.
+	rows, err := tx.QueryContext(ctx, "SELECT item_id FROM search_results WHERE signature = $1 ORDER BY position", search.Signature)
+	if err != nil {
+		return err
+	}
+	var previous []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		previous = append(previous, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM search_results WHERE signature = $1", search.Signature); err != nil {
+		return err
+	}
+	seen := make(map[int]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		if seen[id] {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO search_results (signature, position, item_id) VALUES ($1, $2, $3)",
+			search.Signature, len(seen), id)
+		if err != nil {
+			return err
+		}
+		seen[id] = true
+	}
+	for _, id := range previous {
+		if seen[id] {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO item_events (topic_id, item_id, kind, price, currency, created_at, signature)
+            SELECT $1, id, $2, price, currency, $3, $4 FROM Item WHERE id = $5`,
+			search.TopicID, string(EventRemoved), search.FetchedAt.UTC(), search.Signature, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
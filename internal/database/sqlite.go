@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStreamReaders bounds the read-only connections the Stream methods
+// read from at once.
+const sqliteStreamReaders = 4
+
+// sqliteService is the SQLite-backed Service used for single-user and
+// laptop deployments. The SQL shared with Postgres lives on the embedded
+// service; sqliteService only overrides what differs between the backends.
+type sqliteService struct {
+	*service
+	path string
+
+	// leaders holds the leader locks taken in this process, see
+	// AcquireLeadership.
+	leaderMu sync.Mutex
+	leaders  map[string]string
+}
+
+// NewSQLite opens (or creates) the SQLite database at path and migrates its
+// schema. Use ":memory:" for a throwaway database.
+func NewSQLite(path string) (Service, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialising connections avoids SQLITE_BUSY
+	// and keeps ":memory:" databases on one connection.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db, "sqlite"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteService{
+		service: &service{db: db},
+		path:    path,
+		leaders: map[string]string{},
+	}
+	// Streams may run as slowly as the client they are written to. In WAL
+	// mode readers do not block the writer, so they get connections of their
+	// own. An in-memory database only exists on its one connection.
+	if path != ":memory:" {
+		reads, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", path))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		reads.SetMaxOpenConns(sqliteStreamReaders)
+		s.reads = reads
+	}
+	return s, nil
+}
+
+// Health reports the same statistics as the Postgres backend, tagged with the driver.
+func (s *sqliteService) Health() map[string]string {
+	stats := s.service.Health()
+	stats["driver"] = "sqlite"
+	return stats
+}
+
+// Close closes the SQLite database file.
+func (s *sqliteService) Close() error {
+	log.Printf("Disconnected from database: %s", s.path)
+	if s.reads != nil {
+		s.reads.Close()
+	}
+	return s.db.Close()
+}
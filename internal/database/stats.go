@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// StatsQuery scopes MarketStats to a topic or a brand (exactly one of them)
+// and a window of time. A zero Window covers every stored item.
+type StatsQuery struct {
+	// WorkspaceID selects the items of the topics a workspace sees, see
+	// AllWorkspaces.
+	WorkspaceID int64
+	TopicID     int64
+	Brand       string
+	// Currency selects the items priced in a currency, DefaultCurrency if
+	// empty: prices in different currencies do not aggregate.
+	Currency string
+	Window   time.Duration
+}
+
+// PriceStats summarises the price distribution of a set of items.
+type PriceStats struct {
+	Min    float64 `json:"min"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	Max    float64 `json:"max"`
+}
+
+// MarketStats aggregates the items seen during a window. NewListings counts
+// items first scraped in the window and NewPerDay spreads them over its days.
+type MarketStats struct {
+	Count         int64      `json:"count"`
+	Price         PriceStats `json:"price"`
+	AvgFavourites float64    `json:"avg_favourites"`
+	AvgViews      float64    `json:"avg_views"`
+	BusinessShare float64    `json:"business_share"`
+	PrivateShare  float64    `json:"private_share"`
+	NewListings   int64      `json:"new_listings"`
+	NewPerDay     float64    `json:"new_per_day,omitempty"`
+	PriceChanges  int64      `json:"price_changes"`
+}
+
+// percentileFunc returns the 25th, 50th and 75th price percentiles of the
+// items matched by where.
+type percentileFunc func(ctx context.Context, where *whereBuilder) (p25, median, p75 float64, err error)
+
+func statsFilters(q StatsQuery, now time.Time) (*whereBuilder, error) {
+	if (q.TopicID == 0) == (q.Brand == "") {
+		return nil, fmt.Errorf("stats need exactly one of topic or brand")
+	}
+	w := itemFilters(ItemQuery{WorkspaceID: q.WorkspaceID, TopicID: q.TopicID, Brand: q.Brand})
+	currency := strings.ToUpper(q.Currency)
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	w.add("Item.currency = ?", currency)
+	if q.Window > 0 {
+		w.add("Item.last_seen_at >= ?", now.Add(-q.Window))
+	}
+	return w, nil
+}
+
+// MarketStats computes price and engagement statistics with SQL aggregates.
+func (s *service) MarketStats(ctx context.Context, q StatsQuery) (MarketStats, error) {
+	return s.marketStats(ctx, q, func(ctx context.Context, w *whereBuilder) (float64, float64, float64, error) {
+		var p25, median, p75 sql.NullFloat64
+		err := s.db.QueryRowContext(ctx, `
+            SELECT
+                percentile_cont(0.25) WITHIN GROUP (ORDER BY Item.price::float8),
+                percentile_cont(0.5) WITHIN GROUP (ORDER BY Item.price::float8),
+                percentile_cont(0.75) WITHIN GROUP (ORDER BY Item.price::float8)
+            FROM Item `+w.String(), w.args...).Scan(&p25, &median, &p75)
+		return p25.Float64, median.Float64, p75.Float64, err
+	})
+}
+
+// MarketStats computes the same statistics as on Postgres; SQLite has no
+// percentile aggregate, so percentiles are interpolated from the sorted prices.
+func (s *sqliteService) MarketStats(ctx context.Context, q StatsQuery) (MarketStats, error) {
+	return s.marketStats(ctx, q, func(ctx context.Context, w *whereBuilder) (float64, float64, float64, error) {
+		rows, err := s.db.QueryContext(ctx, "SELECT CAST(Item.price AS REAL) FROM Item "+w.String()+" ORDER BY 1", w.args...)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		defer rows.Close()
+		var prices []float64
+		for rows.Next() {
+			var price float64
+			if err := rows.Scan(&price); err != nil {
+				return 0, 0, 0, err
+			}
+			prices = append(prices, price)
+		}
+		return percentile(prices, 0.25), percentile(prices, 0.5), percentile(prices, 0.75), rows.Err()
+	})
+}
+
+func (s *service) marketStats(ctx context.Context, q StatsQuery, percentiles percentileFunc) (MarketStats, error) {
+	var stats MarketStats
+	now := time.Now().UTC()
+	w, err := statsFilters(q, now)
+	if err != nil {
+		return stats, err
+	}
+
+	var min, max, favourites, views, business sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `
+        SELECT
+            COUNT(*),
+            MIN(Item.price), MAX(Item.price),
+            AVG(Item.favourite_count), AVG(Item.view_count),
+            AVG(CASE WHEN Item.user_business THEN 1.0 ELSE 0.0 END)
+        FROM Item `+w.String(), w.args...).Scan(&stats.Count, &min, &max, &favourites, &views, &business)
+	if err != nil {
+		return stats, fmt.Errorf("error aggregating items: %v", err)
+	}
+	if stats.Count == 0 {
+		return stats, nil
+	}
+	stats.Price.Min, stats.Price.Max = min.Float64, max.Float64
+	stats.AvgFavourites, stats.AvgViews = favourites.Float64, views.Float64
+	stats.BusinessShare = business.Float64
+	stats.PrivateShare = 1 - business.Float64
+
+	stats.Price.P25, stats.Price.Median, stats.Price.P75, err = percentiles(ctx, w)
+	if err != nil {
+		return stats, fmt.Errorf("error computing price percentiles: %v", err)
+	}
+
+	var since time.Time
+	if q.Window > 0 {
+		since = now.Add(-q.Window)
+	}
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(`
+        SELECT
+            (SELECT COUNT(*) FROM Item %[1]s AND Item.first_seen_at >= $%[2]d),
+            (SELECT COUNT(*) FROM price_history JOIN Item ON Item.id = price_history.item_id
+             %[1]s AND price_history.observed_at >= $%[2]d AND price_history.observed_at > Item.first_seen_at
+                AND price_history.currency = Item.currency)`,
+		w, len(w.args)+1), append(w.args, since)...).Scan(&stats.NewListings, &stats.PriceChanges)
+	if err != nil {
+		return stats, fmt.Errorf("error counting new listings: %v", err)
+	}
+	if q.Window > 0 {
+		stats.NewPerDay = float64(stats.NewListings) / q.Window.Hours() * 24
+	}
+	return stats, nil
+}
+
+// percentile linearly interpolates the p-th percentile of sorted values, as
+// Postgres' percentile_cont does.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p * float64(len(sorted)-1)
+	lower, upper := math.Floor(rank), math.Ceil(rank)
+	return sorted[int(lower)] + (sorted[int(upper)]-sorted[int(lower)])*(rank-lower)
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	vinted_scraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// StoredItem is an item together with the bookkeeping columns stored beside it.
+type StoredItem struct {
+	vinted_scraper.Item
+	TopicID     int64     `json:"topic_id"`
+	Domain      string    `json:"domain"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// ItemPhoto is the main photo of a stored item.
+type ItemPhoto struct {
+	ItemID int `json:"item_id"`
+	vinted_scraper.Photo
+}
+
+// PricePoint is one observed price of an item.
+type PricePoint struct {
+	ItemID     int       `json:"item_id"`
+	Price      string    `json:"price"`
+	Currency   string    `json:"currency"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+// The Stream methods call fn for every row matching the filters of q (its
+// sort, limit and cursor are ignored) in id order, reading rows from the
+// database as fn consumes them rather than loading the result into memory.
+// On SQLite they read from a read-only pool of their own, so a slow
+// consumer does not hold the connection writers wait on. Returning an error
+// from fn stops the stream.
+
+// StreamItems streams the items matching q.
+func (s *service) StreamItems(ctx context.Context, q ItemQuery, fn func(StoredItem) error) error {
+	w := itemFilters(q)
+	query := `
+        SELECT ` + itemColumns + `, Item.topic_id, Item.domain, Item.first_seen_at, Item.last_seen_at
+        FROM Item
+        JOIN photos ON Item.photo_id = photos.id
+        ` + w.String() + `
+        ORDER BY Item.id`
+	return s.stream(ctx, query, w.args, func(rows *sql.Rows) error {
+		var item StoredItem
+		var topicID sql.NullInt64
+		var err error
+		item.Item, err = scanItem(rows, &topicID, &item.Domain, &item.FirstSeenAt, &item.LastSeenAt)
+		if err != nil {
+			return err
+		}
+		item.TopicID = topicID.Int64
+		return fn(item)
+	})
+}
+
+// StreamPhotos streams the main photos of the items matching q.
+func (s *service) StreamPhotos(ctx context.Context, q ItemQuery, fn func(ItemPhoto) error) error {
+	w := itemFilters(q)
+	query := `
+        SELECT Item.id, photos.id, photos.ImageNo, photos.Width, photos.Height, photos.DominantColor,
+            photos.DominantColorOpaque, photos.URL, photos.IsMain,
+            photos.IsSuspicious, photos.FullSizeURL, photos.IsHidden
+        FROM Item
+        JOIN photos ON Item.photo_id = photos.id
+        ` + w.String() + `
+        ORDER BY Item.id`
+	return s.stream(ctx, query, w.args, func(rows *sql.Rows) error {
+		var photo ItemPhoto
+		err := rows.Scan(&photo.ItemID, &photo.ID, &photo.ImageNo, &photo.Width, &photo.Height, &photo.DominantColor,
+			&photo.DominantColorOpaque, &photo.URL, &photo.IsMain,
+			&photo.IsSuspicious, &photo.FullSizeURL, &photo.IsHidden)
+		if err != nil {
+			return err
+		}
+		return fn(photo)
+	})
+}
+
+// StreamPriceHistory streams the price history of the items matching q.
+func (s *service) StreamPriceHistory(ctx context.Context, q ItemQuery, fn func(PricePoint) error) error {
+	w := itemFilters(q)
+	query := `
+        SELECT price_history.item_id, price_history.price, price_history.currency, price_history.observed_at
+        FROM price_history
+        JOIN Item ON Item.id = price_history.item_id
+        ` + w.String() + `
+        ORDER BY price_history.item_id, price_history.observed_at`
+	return s.stream(ctx, query, w.args, func(rows *sql.Rows) error {
+		var point PricePoint
+		if err := rows.Scan(&point.ItemID, &point.Price, &point.Currency, &point.ObservedAt); err != nil {
+			return err
+		}
+		return fn(point)
+	})
+}
+
+// StreamScrapeRuns streams the scrape runs of the topic and workspace
+// selected by q, started within its seen window, oldest first.
+func (s *service) StreamScrapeRuns(ctx context.Context, q ItemQuery, fn func(ScrapeRun) error) error {
+	w := &whereBuilder{}
+	w.addWorkspace("topic_id "+inWorkspace, q.WorkspaceID)
+	if q.TopicID != 0 {
+		w.add("topic_id = ?", q.TopicID)
+	}
+	if q.Topic != "" {
+		w.add("topic = ?", q.Topic)
+	}
+	if !q.SeenAfter.IsZero() {
+		w.add("started_at >= ?", q.SeenAfter.UTC())
+	}
+	if !q.SeenBefore.IsZero() {
+		w.add("started_at < ?", q.SeenBefore.UTC())
+	}
+	query := `
+        SELECT ` + runColumns + `
+        FROM scrape_runs
+        ` + w.String() + `
+        ORDER BY id`
+	return s.stream(ctx, query, w.args, func(rows *sql.Rows) error {
+		run, err := scanRun(rows)
+		if err != nil {
+			return err
+		}
+		return fn(run)
+	})
+}
+
+// stream runs query and hands each row to scan until the rows or ctx run out.
+func (s *service) stream(ctx context.Context, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	db := s.db
+	if s.reads != nil {
+		db = s.reads
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error streaming rows: %v", err)
+	}
+	return nil
+}
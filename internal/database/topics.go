@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when a requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Topic is a search the scraper stores items under.
+type Topic struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Items int64  `json:"items"`
+	// LastScrapedAt is when the last successful scrape finished, if any.
+	LastScrapedAt *time.Time `json:"last_scraped_at,omitempty"`
+	// UpdatedAt is when items were last written to the topic, by a scrape
+	// or an import.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// LastReadAt is when the API last served the topic, see MarkTopicRead.
+	// It is left out of responses so reading a topic does not change it.
+	LastReadAt *time.Time `json:"-"`
+}
+
+const topicQuery = `
+        SELECT Topic.id, Topic.name,
+            (SELECT COUNT(*) FROM Item_Topic WHERE Item_Topic.topic_id = Topic.id),
+            (SELECT MAX(finished_at) FROM scrape_runs
+             WHERE scrape_runs.topic_id = Topic.id AND scrape_runs.error_class IS NULL),
+            (SELECT MAX(last_seen_at) FROM Item JOIN Item_Topic ON Item_Topic.item_id = Item.id
+             WHERE Item_Topic.topic_id = Topic.id),
+            Topic.last_read_at
+        FROM Topic`
+
+// ListTopics returns the topics a workspace sees, or every topic for
+// AllWorkspaces.
+func (s *service) ListTopics(ctx context.Context, workspaceID int64) ([]Topic, error) {
+	w := &whereBuilder{}
+	w.addWorkspace("Topic.id "+inWorkspace, workspaceID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("%s %s ORDER BY Topic.name", topicQuery, w), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := []Topic{}
+	for rows.Next() {
+		topic, err := scanTopic(rows)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+// GetTopic returns a topic the workspace sees, any topic for
+// AllWorkspaces, or ErrNotFound.
+func (s *service) GetTopic(ctx context.Context, workspaceID, id int64) (Topic, error) {
+	w := &whereBuilder{}
+	w.add("Topic.id = ?", id)
+	w.addWorkspace("Topic.id "+inWorkspace, workspaceID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("%s %s", topicQuery, w), w.args...)
+	if err != nil {
+		return Topic{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Topic{}, err
+		}
+		return Topic{}, ErrNotFound
+	}
+	return scanTopic(rows)
+}
+
+func scanTopic(rows *sql.Rows) (Topic, error) {
+	var topic Topic
+	var lastScraped, updated, lastRead nullTime
+	if err := rows.Scan(&topic.ID, &topic.Name, &topic.Items, &lastScraped, &updated, &lastRead); err != nil {
+		return topic, err
+	}
+	if lastScraped.Valid {
+		topic.LastScrapedAt = &lastScraped.Time
+	}
+	if updated.Valid {
+		topic.UpdatedAt = &updated.Time
+	}
+	if lastRead.Valid {
+		topic.LastReadAt = &lastRead.Time
+	}
+	return topic, nil
+}
+
+// MarkTopicRead records that the topic was served at at, unless a later
+// read is already recorded.
+func (s *service) MarkTopicRead(ctx context.Context, topicID int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE Topic SET last_read_at = $1 WHERE id = $2 AND (last_read_at IS NULL OR last_read_at < $1)",
+		at.UTC(), topicID)
+	return err
+}
+
+// TopicScheduleState is the scheduler's state for a topic as the replica
+// running the scheduler last saved it.
+type TopicScheduleState struct {
+	TopicID int64
+	// Scheduler is the instance that saved the state.
+	Scheduler  string
+	NextRunAt  *time.Time
+	LastRunAt  *time.Time
+	LastStatus string
+	LastJobID  int64
+	LastError  string
+	Runs       int
+	Failures   int
+	UpdatedAt  time.Time
+}
+
+// SaveTopicSchedule stores the schedule state of a topic, replacing the
+// previous one.
+func (s *service) SaveTopicSchedule(ctx context.Context, st TopicScheduleState) error {
+	utc := func(t *time.Time) interface{} {
+		if t == nil {
+			return nil
+		}
+		return t.UTC()
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO topic_schedules
+            (topic_id, scheduler, next_run_at, last_run_at, last_status, last_job_id, last_error, runs, failures, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        ON CONFLICT (topic_id) DO UPDATE SET scheduler = excluded.scheduler, next_run_at = excluded.next_run_at,
+            last_run_at = excluded.last_run_at, last_status = excluded.last_status, last_job_id = excluded.last_job_id,
+            last_error = excluded.last_error, runs = excluded.runs, failures = excluded.failures, updated_at = excluded.updated_at`,
+		st.TopicID, st.Scheduler, utc(st.NextRunAt), utc(st.LastRunAt), st.LastStatus, st.LastJobID, st.LastError,
+		st.Runs, st.Failures, st.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error saving schedule of topic %d: %v", st.TopicID, err)
+	}
+	return nil
+}
+
+// GetTopicSchedule returns the saved schedule state of a topic, or
+// ErrNotFound when the scheduler has not saved one.
+func (s *service) GetTopicSchedule(ctx context.Context, topicID int64) (TopicScheduleState, error) {
+	st := TopicScheduleState{TopicID: topicID}
+	var nextRun, lastRun, updated nullTime
+	var status, lastError sql.NullString
+	var jobID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT scheduler, next_run_at, last_run_at, last_status, last_job_id, last_error, runs, failures, updated_at
+        FROM topic_schedules WHERE topic_id = $1`, topicID).Scan(
+		&st.Scheduler, &nextRun, &lastRun, &status, &jobID, &lastError, &st.Runs, &st.Failures, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, ErrNotFound
+	}
+	if err != nil {
+		return st, err
+	}
+	if nextRun.Valid {
+		st.NextRunAt = &nextRun.Time
+	}
+	if lastRun.Valid {
+		st.LastRunAt = &lastRun.Time
+	}
+	st.LastStatus, st.LastJobID, st.LastError, st.UpdatedAt = status.String, jobID.Int64, lastError.String, updated.Time
+	return st, nil
+}
+
+// nullTime scans a nullable timestamp. Unlike sql.NullTime it also accepts
+// the text SQLite returns for timestamp expressions such as MAX(column),
+// which lose the column's declared type.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// sqliteTimeFormats are the layouts modernc.org/sqlite writes time.Time
+// values in, including time.Time.String for values with a location, and
+// CURRENT_TIMESTAMP produces.
+var sqliteTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+}
+
+func (t *nullTime) Scan(value interface{}) error {
+	t.Valid = false
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range sqliteTimeFormats {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				t.Time, t.Valid = parsed, true
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot parse %q as a timestamp", v)
+	}
+	return fmt.Errorf("cannot scan %T as a timestamp", value)
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Webhook is an outbound subscription to the item events of one topic, or
+// of every topic when TopicID is 0.
+type Webhook struct {
+	ID          int64  `json:"id"`
+	WorkspaceID int64  `json:"workspace_id"`
+	URL         string `json:"url"`
+	// Secret signs the payloads; it is only shown when the webhook is created.
+	Secret     string      `json:"secret,omitempty"`
+	EventTypes []EventKind `json:"event_types"`
+	TopicID    int64       `json:"topic_id,omitempty"`
+	// LastEventID and LastAlertID are the newest event and alert handed to
+	// the webhook.
+	LastEventID int64     `json:"last_event_id"`
+	LastAlertID int64     `json:"last_alert_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one attempt to deliver a payload to a webhook.
+type WebhookDelivery struct {
+	ID        int64 `json:"id"`
+	WebhookID int64 `json:"webhook_id"`
+	// EventID is the id of the item event, or of the alert for EventAlert.
+	EventID    int64     `json:"event_id,omitempty"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateWebhook stores hook and returns it with its id.
+func (s *service) CreateWebhook(ctx context.Context, hook Webhook) (Webhook, error) {
+	if hook.WorkspaceID == 0 {
+		hook.WorkspaceID = DefaultWorkspace
+	}
+	hook.CreatedAt = time.Now().UTC()
+	types := make([]string, len(hook.EventTypes))
+	for i, kind := range hook.EventTypes {
+		types[i] = string(kind)
+	}
+	err := s.db.QueryRowContext(ctx, `INSERT INTO webhooks (workspace_id, url, secret, event_types, topic_id, last_event_id, last_alert_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		hook.WorkspaceID, hook.URL, hook.Secret, strings.Join(types, ","), sql.NullInt64{Int64: hook.TopicID, Valid: hook.TopicID != 0},
+		hook.LastEventID, hook.LastAlertID, hook.CreatedAt).Scan(&hook.ID)
+	if err != nil {
+		return hook, fmt.Errorf("error creating webhook: %v", err)
+	}
+	return hook, nil
+}
+
+const webhookColumns = "id, workspace_id, url, secret, event_types, topic_id, last_event_id, last_alert_id, created_at"
+
+// ListWebhooks returns the webhooks of a workspace, or of all of them for 0,
+// oldest first, with their secrets.
+func (s *service) ListWebhooks(ctx context.Context, workspaceID int64) ([]Webhook, error) {
+	w := &whereBuilder{}
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM webhooks %s ORDER BY id", webhookColumns, w), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// GetWebhook returns one webhook of a workspace, or of any for 0, with its
+// secret, or ErrNotFound.
+func (s *service) GetWebhook(ctx context.Context, workspaceID, id int64) (Webhook, error) {
+	w := &whereBuilder{}
+	w.add("id = ?", id)
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM webhooks %s", webhookColumns, w), w.args...)
+	if err != nil {
+		return Webhook{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Webhook{}, err
+		}
+		return Webhook{}, ErrNotFound
+	}
+	return scanWebhook(rows)
+}
+
+func scanWebhook(rows *sql.Rows) (Webhook, error) {
+	var hook Webhook
+	var types string
+	var topicID sql.NullInt64
+	var createdAt nullTime
+	if err := rows.Scan(&hook.ID, &hook.WorkspaceID, &hook.URL, &hook.Secret, &types, &topicID, &hook.LastEventID, &hook.LastAlertID, &createdAt); err != nil {
+		return hook, err
+	}
+	for _, kind := range strings.Split(types, ",") {
+		hook.EventTypes = append(hook.EventTypes, EventKind(kind))
+	}
+	hook.TopicID = topicID.Int64
+	hook.CreatedAt = createdAt.Time
+	return hook, nil
+}
+
+// DeleteWebhook removes a webhook of a workspace, or of any for 0, and its
+// delivery log, or returns ErrNotFound.
+func (s *service) DeleteWebhook(ctx context.Context, workspaceID, id int64) error {
+	w := &whereBuilder{}
+	w.add("id = ?", id)
+	w.addWorkspace("workspace_id = ?", workspaceID)
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhooks "+w.String(), w.args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AdvanceWebhook records that the events of a webhook up to lastEventID
+// have been handed to it.
+func (s *service) AdvanceWebhook(ctx context.Context, id, lastEventID int64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE webhooks SET last_event_id = $1 WHERE id = $2 AND last_event_id < $1", lastEventID, id)
+	return err
+}
+
+// AdvanceWebhookAlerts records that the alerts up to lastAlertID have been
+// handed to a webhook.
+func (s *service) AdvanceWebhookAlerts(ctx context.Context, id, lastAlertID int64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE webhooks SET last_alert_id = $1 WHERE id = $2 AND last_alert_id < $1", lastAlertID, id)
+	return err
+}
+
+// RecordWebhookDelivery adds a delivery attempt to the log and returns its id.
+func (s *service) RecordWebhookDelivery(ctx context.Context, d WebhookDelivery) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO webhook_deliveries (
+            webhook_id, event_id, event_type, attempt, status_code, error, success, duration_ms, created_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		d.WebhookID, sql.NullInt64{Int64: d.EventID, Valid: d.EventID != 0}, d.EventType, d.Attempt,
+		nullInt(d.StatusCode), nullString(d.Error), d.Success, d.DurationMS, d.CreatedAt.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error recording delivery to webhook %d: %v", d.WebhookID, err)
+	}
+	return id, nil
+}
+
+// ListWebhookDeliveries returns the latest delivery attempts of a webhook,
+// newest first, or ErrNotFound if there is no such webhook.
+func (s *service) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	if _, err := s.GetWebhook(ctx, AllWorkspaces, webhookID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultItemLimit
+	}
+	if limit > MaxItemLimit {
+		limit = MaxItemLimit
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+        SELECT id, webhook_id, event_id, event_type, attempt, status_code, error, success, duration_ms, created_at
+        FROM webhook_deliveries
+        WHERE webhook_id = $1
+        ORDER BY id DESC
+        LIMIT %d`, limit), webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var eventID, statusCode sql.NullInt64
+		var deliveryErr sql.NullString
+		var createdAt nullTime
+		err := rows.Scan(&d.ID, &d.WebhookID, &eventID, &d.EventType, &d.Attempt, &statusCode, &deliveryErr,
+			&d.Success, &d.DurationMS, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		d.EventID = eventID.Int64
+		d.StatusCode = int(statusCode.Int64)
+		d.Error = deliveryErr.String
+		d.CreatedAt = createdAt.Time
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ParseEventKinds parses event kinds, rejecting unknown ones.
+func ParseEventKinds(values []string) ([]EventKind, error) {
+	kinds := make([]EventKind, 0, len(values))
+	for _, value := range values {
+		switch kind := EventKind(value); kind {
+		case EventNew, EventPriceDrop, EventPriceRise, EventRemoved, EventAlert:
+			kinds = append(kinds, kind)
+		default:
+			return nil, fmt.Errorf("unknown event type %q", value)
+		}
+	}
+	if len(kinds) == 0 {
+		return nil, errors.New("no event types")
+	}
+	return kinds, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultWorkspace owns what was created before workspaces existed and what
+// requests without an API key create.
+const DefaultWorkspace int64 = 1
+
+// ErrWorkspaceExists is returned by CreateWorkspace for a taken name.
+var ErrWorkspaceExists = errors.New("workspace already exists")
+
+// Workspace separates the teams sharing a deployment. It owns saved
+// searches, webhooks, API keys and jobs, and sees the topics it searched;
+// topics and items are shared, so each search is scraped once for all.
+type Workspace struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AllWorkspaces is the workspaceID of callers that act for every
+// workspace, such as the scheduler, webhook delivery and the command line.
+// Every other caller names its workspace; 0 is read as DefaultWorkspace, so
+// a caller that forgets to set one only sees the default workspace.
+const AllWorkspaces int64 = -1
+
+// inWorkspace is the condition selecting the topic ids of a workspace, for
+// whereBuilder.addWorkspace.
+const inWorkspace = "IN (SELECT topic_id FROM workspace_topics WHERE workspace_id = ?)"
+
+// itemInWorkspace is the condition selecting the items scraped by a topic
+// of a workspace. Membership comes from Item_Topic, not Item.topic_id, which
+// only keeps the topic that scraped the item last.
+const itemInWorkspace = "Item.id IN (SELECT item_id FROM Item_Topic WHERE topic_id " + inWorkspace + ")"
+
+// addWorkspace appends condition with workspaceID as its placeholder,
+// unless workspaceID is AllWorkspaces; 0 stands for DefaultWorkspace.
+func (w *whereBuilder) addWorkspace(condition string, workspaceID int64) {
+	switch workspaceID {
+	case AllWorkspaces:
+		return
+	case 0:
+		workspaceID = DefaultWorkspace
+	}
+	w.add(condition, workspaceID)
+}
+
+// CreateWorkspace stores a workspace named name and returns it.
+func (s *service) CreateWorkspace(ctx context.Context, name string) (Workspace, error) {
+	ws := Workspace{Name: strings.TrimSpace(name), CreatedAt: time.Now().UTC()}
+	if ws.Name == "" {
+		return ws, errors.New("missing workspace name")
+	}
+	err := s.db.QueryRowContext(ctx, `INSERT INTO workspaces (name, created_at) VALUES ($1, $2)
+        ON CONFLICT (name) DO NOTHING RETURNING id`, ws.Name, ws.CreatedAt).Scan(&ws.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ws, ErrWorkspaceExists
+	}
+	if err != nil {
+		return ws, fmt.Errorf("error creating workspace: %v", err)
+	}
+	return ws, nil
+}
+
+// ListWorkspaces returns every workspace, oldest first.
+func (s *service) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, created_at FROM workspaces ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var ws Workspace
+		var createdAt nullTime
+		if err := rows.Scan(&ws.ID, &ws.Name, &createdAt); err != nil {
+			return nil, err
+		}
+		ws.CreatedAt = createdAt.Time
+		workspaces = append(workspaces, ws)
+	}
+	return workspaces, rows.Err()
+}
+
+// GetWorkspace returns one workspace, or ErrNotFound.
+func (s *service) GetWorkspace(ctx context.Context, id int64) (Workspace, error) {
+	ws := Workspace{ID: id}
+	var createdAt nullTime
+	err := s.db.QueryRowContext(ctx, "SELECT name, created_at FROM workspaces WHERE id = $1", id).Scan(&ws.Name, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ws, ErrNotFound
+	}
+	ws.CreatedAt = createdAt.Time
+	return ws, err
+}
+
+// AddWorkspaceTopic lets a workspace see a topic, once it searched it.
+func (s *service) AddWorkspaceTopic(ctx context.Context, workspaceID, topicID int64) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO workspace_topics (workspace_id, topic_id, created_at) VALUES ($1, $2, $3)
+        ON CONFLICT DO NOTHING`, workspaceID, topicID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error adding topic %d to workspace %d: %v", topicID, workspaceID, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// encoder writes rows of type T in one format. Close flushes buffered rows
+// and writes any trailer the format needs.
+type encoder[T any] interface {
+	Write(row T) error
+	Close() error
+}
+
+func newEncoder[T any](out io.Writer, format Format) (encoder[T], error) {
+	switch format {
+	case CSV:
+		return newCSVEncoder[T](out), nil
+	case NDJSON:
+		buffered := bufio.NewWriter(out)
+		return &ndjsonEncoder[T]{buffered: buffered, enc: json.NewEncoder(buffered)}, nil
+	case Parquet:
+		return &parquetEncoder[T]{writer: parquet.NewGenericWriter[T](out)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type ndjsonEncoder[T any] struct {
+	buffered *bufio.Writer
+	enc      *json.Encoder
+}
+
+func (e *ndjsonEncoder[T]) Write(row T) error {
+	return e.enc.Encode(row)
+}
+
+func (e *ndjsonEncoder[T]) Close() error {
+	return e.buffered.Flush()
+}
+
+// parquetRowGroupSize bounds how many rows are buffered before a row group is written.
+const parquetRowGroupSize = 10000
+
+type parquetEncoder[T any] struct {
+	writer   *parquet.GenericWriter[T]
+	buffered int
+}
+
+func (e *parquetEncoder[T]) Write(row T) error {
+	if _, err := e.writer.Write([]T{row}); err != nil {
+		return err
+	}
+	e.buffered++
+	if e.buffered == parquetRowGroupSize {
+		e.buffered = 0
+		return e.writer.Flush()
+	}
+	return nil
+}
+
+func (e *parquetEncoder[T]) Close() error {
+	return e.writer.Close()
+}
+
+// csvEncoder writes one column per field of T, named by its json tag, with
+// a header row before the first record.
+type csvEncoder[T any] struct {
+	writer  *csv.Writer
+	header  []string
+	started bool
+}
+
+func newCSVEncoder[T any](out io.Writer) *csvEncoder[T] {
+	var header []string
+	rowType := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < rowType.NumField(); i++ {
+		name, _, _ := strings.Cut(rowType.Field(i).Tag.Get("json"), ",")
+		header = append(header, name)
+	}
+	return &csvEncoder[T]{writer: csv.NewWriter(out), header: header}
+}
+
+func (e *csvEncoder[T]) Write(row T) error {
+	if !e.started {
+		e.started = true
+		if err := e.writer.Write(e.header); err != nil {
+			return err
+		}
+	}
+	value := reflect.ValueOf(row)
+	record := make([]string, value.NumField())
+	for i := range record {
+		record[i] = csvValue(value.Field(i).Interface())
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvEncoder[T]) Close() error {
+	if !e.started {
+		e.started = true
+		e.writer.Write(e.header)
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
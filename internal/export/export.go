@@ -0,0 +1,229 @@
+// Package export streams stored data out of the database as CSV, NDJSON or
+// Parquet for offline analysis.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"vinted-scraper/internal/database"
+)
+
+// Format is an output file format.
+type Format string
+
+const (
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+)
+
+// Dataset is a kind of stored data that can be exported.
+type Dataset string
+
+const (
+	Items        Dataset = "items"
+	Photos       Dataset = "photos"
+	PriceHistory Dataset = "price_history"
+	Runs         Dataset = "runs"
+)
+
+// ParseFormat validates a format name.
+func ParseFormat(value string) (Format, error) {
+	switch f := Format(value); f {
+	case CSV, NDJSON, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q: use csv, ndjson or parquet", value)
+}
+
+// ParseDataset validates a dataset name.
+func ParseDataset(value string) (Dataset, error) {
+	switch d := Dataset(value); d {
+	case Items, Photos, PriceHistory, Runs:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown dataset %q: use items, photos, price_history or runs", value)
+}
+
+// ContentType returns the MIME type of files in format.
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv"
+	case NDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// Filename returns the conventional file name for dataset exported as f.
+func (f Format) Filename(dataset Dataset) string {
+	return string(dataset) + "." + string(f)
+}
+
+// Write streams dataset rows matching the filters of q to out in format.
+// Rows are encoded as they are read, so memory stays bounded however much
+// is exported; Parquet buffers at most one row group.
+func Write(ctx context.Context, db database.Service, out io.Writer, dataset Dataset, format Format, q database.ItemQuery) error {
+	switch dataset {
+	case Items:
+		return write(out, format, func(emit func(itemRow) error) error {
+			return db.StreamItems(ctx, q, func(item database.StoredItem) error { return emit(newItemRow(item)) })
+		})
+	case Photos:
+		return write(out, format, func(emit func(photoRow) error) error {
+			return db.StreamPhotos(ctx, q, func(photo database.ItemPhoto) error { return emit(newPhotoRow(photo)) })
+		})
+	case PriceHistory:
+		return write(out, format, func(emit func(priceRow) error) error {
+			return db.StreamPriceHistory(ctx, q, func(point database.PricePoint) error { return emit(newPriceRow(point)) })
+		})
+	case Runs:
+		return write(out, format, func(emit func(runRow) error) error {
+			return db.StreamScrapeRuns(ctx, q, func(run database.ScrapeRun) error { return emit(runRow(run)) })
+		})
+	}
+	return fmt.Errorf("unknown dataset %q", dataset)
+}
+
+// write encodes every row produced by stream to out.
+func write[T any](out io.Writer, format Format, stream func(emit func(T) error) error) error {
+	enc, err := newEncoder[T](out, format)
+	if err != nil {
+		return err
+	}
+	if err := stream(enc.Write); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// The row types flatten what the database returns into the columns written
+// to every format; the field tags name the columns.
+
+type itemRow struct {
+	ID             int64     `json:"id" parquet:"id"`
+	TopicID        int64     `json:"topic_id" parquet:"topic_id"`
+	Domain         string    `json:"domain" parquet:"domain"`
+	Title          string    `json:"title" parquet:"title"`
+	Price          float64   `json:"price" parquet:"price"`
+	Currency       string    `json:"currency" parquet:"currency"`
+	TotalItemPrice float64   `json:"total_item_price" parquet:"total_item_price"`
+	ServiceFee     float64   `json:"service_fee" parquet:"service_fee"`
+	BrandTitle     string    `json:"brand_title" parquet:"brand_title"`
+	SizeTitle      string    `json:"size_title" parquet:"size_title"`
+	Status         string    `json:"status" parquet:"status"`
+	URL            string    `json:"url" parquet:"url"`
+	UserID         int64     `json:"user_id" parquet:"user_id"`
+	UserLogin      string    `json:"user_login" parquet:"user_login"`
+	UserBusiness   bool      `json:"user_business" parquet:"user_business"`
+	Promoted       bool      `json:"promoted" parquet:"promoted"`
+	FavouriteCount int64     `json:"favourite_count" parquet:"favourite_count"`
+	ViewCount      int64     `json:"view_count" parquet:"view_count"`
+	PhotoID        int64     `json:"photo_id" parquet:"photo_id"`
+	FirstSeenAt    time.Time `json:"first_seen_at" parquet:"first_seen_at"`
+	LastSeenAt     time.Time `json:"last_seen_at" parquet:"last_seen_at"`
+}
+
+func newItemRow(item database.StoredItem) itemRow {
+	return itemRow{
+		ID:             int64(item.ID),
+		TopicID:        item.TopicID,
+		Domain:         item.Domain,
+		Title:          item.Title,
+		Price:          parseDecimal(item.Price),
+		Currency:       item.Currency,
+		TotalItemPrice: parseDecimal(item.TotalItemPrice),
+		ServiceFee:     parseDecimal(item.ServiceFee),
+		BrandTitle:     item.BrandTitle,
+		SizeTitle:      item.SizeTitle,
+		Status:         item.Status,
+		URL:            item.URL,
+		UserID:         int64(item.User.ID),
+		UserLogin:      item.User.Login,
+		UserBusiness:   item.User.Business,
+		Promoted:       item.Promoted,
+		FavouriteCount: int64(item.FavouriteCount),
+		ViewCount:      int64(item.ViewCount),
+		PhotoID:        int64(item.Photo.ID),
+		FirstSeenAt:    item.FirstSeenAt.UTC(),
+		LastSeenAt:     item.LastSeenAt.UTC(),
+	}
+}
+
+type photoRow struct {
+	ItemID              int64  `json:"item_id" parquet:"item_id"`
+	PhotoID             int64  `json:"photo_id" parquet:"photo_id"`
+	ImageNo             int64  `json:"image_no" parquet:"image_no"`
+	Width               int64  `json:"width" parquet:"width"`
+	Height              int64  `json:"height" parquet:"height"`
+	DominantColor       string `json:"dominant_color" parquet:"dominant_color"`
+	DominantColorOpaque string `json:"dominant_color_opaque" parquet:"dominant_color_opaque"`
+	URL                 string `json:"url" parquet:"url"`
+	FullSizeURL         string `json:"full_size_url" parquet:"full_size_url"`
+	IsMain              bool   `json:"is_main" parquet:"is_main"`
+	IsSuspicious        bool   `json:"is_suspicious" parquet:"is_suspicious"`
+	IsHidden            bool   `json:"is_hidden" parquet:"is_hidden"`
+}
+
+func newPhotoRow(photo database.ItemPhoto) photoRow {
+	return photoRow{
+		ItemID:              int64(photo.ItemID),
+		PhotoID:             int64(photo.ID),
+		ImageNo:             int64(photo.ImageNo),
+		Width:               int64(photo.Width),
+		Height:              int64(photo.Height),
+		DominantColor:       photo.DominantColor,
+		DominantColorOpaque: photo.DominantColorOpaque,
+		URL:                 photo.URL,
+		FullSizeURL:         photo.FullSizeURL,
+		IsMain:              photo.IsMain,
+		IsSuspicious:        photo.IsSuspicious,
+		IsHidden:            photo.IsHidden,
+	}
+}
+
+type priceRow struct {
+	ItemID     int64     `json:"item_id" parquet:"item_id"`
+	Price      float64   `json:"price" parquet:"price"`
+	Currency   string    `json:"currency" parquet:"currency"`
+	ObservedAt time.Time `json:"observed_at" parquet:"observed_at"`
+}
+
+func newPriceRow(point database.PricePoint) priceRow {
+	return priceRow{
+		ItemID:     int64(point.ItemID),
+		Price:      parseDecimal(point.Price),
+		Currency:   point.Currency,
+		ObservedAt: point.ObservedAt.UTC(),
+	}
+}
+
+// runRow has the same fields as database.ScrapeRun, with every column
+// present in each format.
+type runRow struct {
+	ID           int64     `json:"id" parquet:"id"`
+	Topic        string    `json:"topic" parquet:"topic"`
+	TopicID      int64     `json:"topic_id" parquet:"topic_id"`
+	QueryParams  string    `json:"query_params" parquet:"query_params"`
+	Domain       string    `json:"domain" parquet:"domain"`
+	StartedAt    time.Time `json:"started_at" parquet:"started_at"`
+	FinishedAt   time.Time `json:"finished_at" parquet:"finished_at"`
+	Pages        int       `json:"pages" parquet:"pages"`
+	ItemsNew     int       `json:"items_new" parquet:"items_new"`
+	ItemsUpdated int       `json:"items_updated" parquet:"items_updated"`
+	HTTPStatus   int       `json:"http_status" parquet:"http_status"`
+	ErrorClass   string    `json:"error_class" parquet:"error_class"`
+	ErrorMessage string    `json:"error_message" parquet:"error_message"`
+}
+
+// parseDecimal reads a decimal string such as a price, treating garbage as 0.
+func parseDecimal(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
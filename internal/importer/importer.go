@@ -0,0 +1,166 @@
+// Package importer loads saved Vinted catalog responses into the database
+// through the same AddItems path a live scrape uses.
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// batchSize bounds how many items are held in memory and written per transaction.
+const batchSize = 500
+
+// Report counts what an import did. Rejected items were decoded but failed
+// validation; Errors lists inputs that could not be read at all.
+type Report struct {
+	// TopicID is the topic the items went to, once a batch was stored.
+	TopicID   int64    `json:"topic_id,omitempty"`
+	Files     int      `json:"files"`
+	Responses int      `json:"responses"`
+	Inserted  int      `json:"inserted"`
+	Updated   int      `json:"updated"`
+	Rejected  int      `json:"rejected"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// Importer feeds decoded items into db under one topic and domain.
+type Importer struct {
+	db     database.Service
+	topic  string
+	domain string
+	batch  []vintedscraper.Item
+	report Report
+}
+
+// New returns an Importer adding items to topic, as scraped from domain.
+func New(db database.Service, topic string, domain string) *Importer {
+	return &Importer{db: db, topic: topic, domain: domain}
+}
+
+// ImportFile imports the file at path; "-" reads standard input. A file that
+// cannot be read is recorded in the report and does not stop later files.
+func (i *Importer) ImportFile(path string) {
+	i.report.Files++
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			i.report.Errors = append(i.report.Errors, err.Error())
+			return
+		}
+		defer file.Close()
+		in = file
+	}
+	if err := i.Import(in); err != nil {
+		i.report.Errors = append(i.report.Errors, fmt.Sprintf("%s: %v", path, err))
+	}
+}
+
+// Import reads a stream of JSON values: a single VintedApi_Response as
+// saved from the catalog API, newline-delimited responses or items, or
+// arrays of items.
+// Items are written in batches as they are decoded.
+func (i *Importer) Import(in io.Reader) error {
+	dec := json.NewDecoder(in)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			i.flush()
+			return fmt.Errorf("error decoding JSON: %v", err)
+		}
+		if err := i.add(raw); err != nil {
+			i.flush()
+			return err
+		}
+	}
+	return i.flush()
+}
+
+// add decodes one JSON value: a whole response, a single item or an array of items.
+func (i *Importer) add(raw json.RawMessage) error {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			i.report.Rejected++
+			return nil
+		}
+		for _, item := range items {
+			if err := i.add(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var probe struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		i.report.Rejected++
+		return nil
+	}
+
+	if probe.Items == nil {
+		var item vintedscraper.Item
+		if err := json.Unmarshal(raw, &item); err != nil {
+			i.report.Rejected++
+			return nil
+		}
+		return i.queue(item)
+	}
+
+	var response vintedscraper.VintedApi_Response
+	if err := json.Unmarshal(raw, &response); err != nil {
+		i.report.Rejected++
+		return nil
+	}
+	i.report.Responses++
+	for _, item := range response.Items {
+		if err := i.queue(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Importer) queue(item vintedscraper.Item) error {
+	i.batch = append(i.batch, item)
+	if len(i.batch) >= batchSize {
+		return i.flush()
+	}
+	return nil
+}
+
+// flush writes the pending batch.
+func (i *Importer) flush() error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+	result, err := i.db.AddItems(i.batch, i.topic, i.domain)
+	i.batch = i.batch[:0]
+	if err != nil {
+		return fmt.Errorf("error adding items: %v", err)
+	}
+	if result.TopicID != 0 {
+		i.report.TopicID = result.TopicID
+	}
+	i.report.Inserted += result.New
+	i.report.Updated += result.Updated
+	i.report.Rejected += result.Rejected
+	return nil
+}
+
+// Report returns the counts accumulated so far.
+func (i *Importer) Report() Report {
+	return i.report
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"vinted-scraper/internal/database"
+)
+
+// audit logs the privileged request r, answered with status, under its
+// route pattern.
+func (s *Server) audit(r *http.Request, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	entry := database.AuditEntry{
+		Actor:     "anonymous",
+		Action:    r.Method + " " + r.URL.Path,
+		Path:      r.URL.Path,
+		Status:    status,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		entry.Action = r.Method + " " + rctx.RoutePattern()
+	}
+	if key, ok := apiKeyFrom(r.Context()); ok {
+		entry.WorkspaceID, entry.APIKeyID, entry.Actor = key.WorkspaceID, key.ID, key.Prefix
+	}
+	// The action happened even if the client has gone away since.
+	if _, err := s.db.RecordAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+		log.Printf("[%s] %v", entry.RequestID, err)
+	}
+}
+
+// auditHandler serves GET /v1/audit, the entries of the workspace newest
+// first, optionally for one API key and paged with limit and cursor (the id
+// of the last entry seen).
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	q := database.AuditQuery{WorkspaceID: s.workspace(r.Context())}
+	var err error
+	if v := values.Get("api_key_id"); v != "" {
+		if q.APIKeyID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return badRequest("invalid api_key_id %q", v)
+		}
+	}
+	if v := values.Get("cursor"); v != "" {
+		if q.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return badRequest("invalid cursor %q", v)
+		}
+	}
+	q.Limit = database.DefaultItemLimit
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return badRequest("invalid limit %q", v)
+		}
+		if q.Limit > database.MaxItemLimit {
+			q.Limit = database.MaxItemLimit
+		}
+	}
+
+	entries, err := s.db.ListAuditLog(r.Context(), q)
+	if err != nil {
+		return err
+	}
+	page := &pagination{Limit: q.Limit}
+	if len(entries) == q.Limit {
+		page.NextCursor = strconv.FormatInt(entries[len(entries)-1].ID, 10)
+	}
+	return writeData(w, entries, responseMeta{Pagination: page})
+}
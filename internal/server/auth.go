@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"vinted-scraper/internal/database"
+)
+
+// authenticator requires an API key on the API and enforces its limits:
+// API_RATE_LIMIT requests (default 120/1m) and API_SCRAPE_LIMIT requests
+// that scrape Vinted live (default 10/1h), unless the key sets its own. The
+// limits are counted by each process.
+type authenticator struct {
+	requests, scrapes struct {
+		n      int
+		period time.Duration
+	}
+
+	mu     sync.Mutex
+	limits map[int64]*keyLimits
+	// topics holds the workspaceTopic pairs already stored, see seeTopic.
+	topics sync.Map
+}
+
+// keyLimits are the rate limits of one API key.
+type keyLimits struct {
+	requests, scrapes *tokenBucket
+}
+
+func newAuthenticator() *authenticator {
+	a := &authenticator{limits: map[int64]*keyLimits{}}
+	a.requests.n, a.requests.period = envRate("API_RATE_LIMIT", 120, time.Minute)
+	a.scrapes.n, a.scrapes.period = envRate("API_SCRAPE_LIMIT", 10, time.Hour)
+	return a
+}
+
+// WithAuth makes the Server require an API key on every route but / and
+// /health, see authenticator.
+func WithAuth() Option {
+	return func(s *Server) {
+		s.auth = newAuthenticator()
+	}
+}
+
+// limitsFor returns the rate limits of key, created on first use.
+func (a *authenticator) limitsFor(key database.APIKey) *keyLimits {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	limits, ok := a.limits[key.ID]
+	if !ok {
+		limits = &keyLimits{
+			requests: limitBucket(a.requests.n, a.requests.period),
+			scrapes:  limitBucket(a.scrapes.n, a.scrapes.period),
+		}
+		if key.RateLimit > 0 {
+			limits.requests = newTokenBucket(key.RateLimit, time.Minute)
+		}
+		if key.ScrapeLimit > 0 {
+			limits.scrapes = newTokenBucket(key.ScrapeLimit, time.Hour)
+		}
+		a.limits[key.ID] = limits
+	}
+	return limits
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFrom returns the API key a request was authenticated with.
+func apiKeyFrom(ctx context.Context) (database.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(database.APIKey)
+	return key, ok
+}
+
+// requestAPIKey returns the key sent as "Authorization: Bearer <key>" or in
+// X-API-Key.
+func requestAPIKey(r *http.Request) string {
+	if scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(key)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authenticate rejects requests without a valid API key, or over its rate
+// limit, when the Server requires keys.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := requestAPIKey(r)
+		if secret == "" {
+			writeError(w, r, unauthorized("missing API key"))
+			return
+		}
+		key, err := s.db.AuthenticateAPIKey(r.Context(), secret)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, r, unauthorized("invalid API key"))
+			return
+		}
+		if err != nil {
+			writeError(w, r, fmt.Errorf("error authenticating API key: %v", err))
+			return
+		}
+		if limits := s.auth.limitsFor(key); !limits.requests.allow() {
+			writeError(w, r, tooManyRequests(limits.requests.wait(), "rate limit of API key %s exceeded", key.Prefix))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// require rejects requests whose API key's role does not allow role, when
+// the Server requires keys. Requests to routes beyond a reader's are
+// privileged, so they are audited whether they are allowed or not.
+func (s *Server) require(role database.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if role != database.RoleReader {
+				ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+				defer func() { s.audit(r, ww.Status()) }()
+				w = ww
+			}
+			if key, _ := apiKeyFrom(r.Context()); s.auth != nil && !key.Role.Allows(role) {
+				writeError(w, r, forbidden("API key %s is a %s key, this takes %s", key.Prefix, key.Role, role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireRefresh requires an operator key, as require does, for requests
+// that force a scrape with refresh=true; other requests are left to next.
+func (s *Server) requireRefresh(next http.Handler) http.Handler {
+	operator := s.require(database.RoleOperator)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refresh, err := parseRefresh(r); err == nil && refresh {
+			operator.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowScrape takes a live scrape from the quota of the request's API key,
+// or returns a 429 once it is spent.
+func (s *Server) allowScrape(ctx context.Context) error {
+	key, ok := apiKeyFrom(ctx)
+	if s.auth == nil || !ok {
+		return nil
+	}
+	if limits := s.auth.limitsFor(key); !limits.scrapes.allow() {
+		return tooManyRequests(limits.scrapes.wait(), "live scrape quota of API key %s exceeded", key.Prefix)
+	}
+	return nil
+}
+
+// apiKeyRequest is the body of POST /v1/keys.
+type apiKeyRequest struct {
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	RateLimit   int    `json:"rate_limit"`
+	ScrapeLimit int    `json:"scrape_limit"`
+}
+
+// createAPIKeyHandler serves POST /v1/keys, answering with the new key;
+// its secret is never shown again.
+func (s *Server) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) error {
+	var req apiKeyRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return badRequest("missing name")
+	}
+	role, err := database.ParseRole(req.Role)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	if req.RateLimit < 0 || req.ScrapeLimit < 0 {
+		return badRequest("invalid limits, want 0 for the default or a positive count")
+	}
+	key, err := s.db.CreateAPIKey(r.Context(), database.APIKey{
+		WorkspaceID: s.owner(r.Context()),
+		Name:        req.Name,
+		Role:        role,
+		RateLimit:   req.RateLimit,
+		ScrapeLimit: req.ScrapeLimit,
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/v1/keys/"+strconv.FormatInt(key.ID, 10))
+	return writeDataStatus(w, http.StatusCreated, key, responseMeta{})
+}
+
+// apiKeysHandler serves GET /v1/keys, the keys of the workspace without
+// their secrets.
+func (s *Server) apiKeysHandler(w http.ResponseWriter, r *http.Request) error {
+	keys, err := s.db.ListAPIKeys(r.Context(), s.workspace(r.Context()))
+	if err != nil {
+		return err
+	}
+	return writeData(w, keys, responseMeta{})
+}
+
+// revokeAPIKeyHandler serves DELETE /v1/keys/{id}, answering with the
+// revoked key.
+func (s *Server) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	keyID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return badRequest("invalid API key id %q", id)
+	}
+	key, err := s.db.RevokeAPIKey(r.Context(), s.workspace(r.Context()), keyID)
+	if err == database.ErrNotFound {
+		return notFound("API key %d not found", keyID)
+	}
+	if err != nil {
+		return err
+	}
+	return writeData(w, key, responseMeta{})
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vinted-scraper/internal/database"
+)
+
+// cacheName identifies this service in Cache-Status headers (RFC 9211).
+const cacheName = "vinted-scraper"
+
+// cachePolicy decides when the stored items of a topic are good enough to
+// answer a search with, based on the age of its last successful scrape.
+type cachePolicy struct {
+	// Fresh is how long after a scrape items are served as they are.
+	Fresh time.Duration
+	// Stale is how long past Fresh items are still served while a refresh
+	// runs in the background. Older items wait for a refresh.
+	Stale time.Duration
+	// MaxAge is the oldest items may be to be served when a refresh fails.
+	MaxAge time.Duration
+}
+
+var defaultCachePolicy = cachePolicy{Fresh: 5 * time.Minute, Stale: time.Hour, MaxAge: 24 * time.Hour}
+
+// cachePolicies holds the default policy and per-topic overrides.
+type cachePolicies struct {
+	Default cachePolicy
+	Topics  map[string]cachePolicy
+}
+
+// For returns the policy of topic.
+func (p cachePolicies) For(topic string) cachePolicy {
+	if policy, ok := p.Topics[topic]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// cachePoliciesFromEnv reads CACHE_FRESH_TTL, CACHE_STALE_TTL and
+// CACHE_MAX_AGE (Go durations) for the default policy, and
+// CACHE_TOPIC_POLICIES for overrides, as comma separated
+// topic=fresh/stale/max_age entries such as "shoes=1m/10m/6h".
+func cachePoliciesFromEnv() (cachePolicies, error) {
+	policies := cachePolicies{Default: defaultCachePolicy, Topics: map[string]cachePolicy{}}
+	for key, dest := range map[string]*time.Duration{
+		"CACHE_FRESH_TTL": &policies.Default.Fresh,
+		"CACHE_STALE_TTL": &policies.Default.Stale,
+		"CACHE_MAX_AGE":   &policies.Default.MaxAge,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return policies, fmt.Errorf("invalid %s %q", key, v)
+		}
+		*dest = d
+	}
+
+	v := os.Getenv("CACHE_TOPIC_POLICIES")
+	if v == "" {
+		return policies, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		topic, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		parts := strings.Split(spec, "/")
+		if !ok || topic == "" || len(parts) != 3 {
+			return policies, fmt.Errorf("invalid CACHE_TOPIC_POLICIES entry %q, want topic=fresh/stale/max_age", entry)
+		}
+		var durations [3]time.Duration
+		for i, part := range parts {
+			d, err := time.ParseDuration(part)
+			if err != nil || d < 0 {
+				return policies, fmt.Errorf("invalid CACHE_TOPIC_POLICIES entry %q: bad duration %q", entry, part)
+			}
+			durations[i] = d
+		}
+		policies.Topics[topic] = cachePolicy{Fresh: durations[0], Stale: durations[1], MaxAge: durations[2]}
+	}
+	return policies, nil
+}
+
+// topicCache de-duplicates the scrapes of this process: every request for
+// a search signature that arrives while a scrape of it is running waits for
+// that scrape instead of starting another one. Replicas share scrapes
+// through the job queue, see scrapeSearch.
+type topicCache struct {
+	policies cachePolicies
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is one running scrape; done is closed once err is set.
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+func newTopicCache(policies cachePolicies) *topicCache {
+	return &topicCache{policies: policies, flights: map[string]*flight{}}
+}
+
+// do starts scrape for key unless a scrape of key is already running, and
+// returns the flight to wait on. The scrape outlives the request that
+// started it, so a client going away does not waste the work.
+func (c *topicCache) do(key string, scrape func() error) *flight {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	go func() {
+		f.err = scrape()
+		if f.err != nil {
+			log.Printf("Error refreshing %q: %v", key, f.err)
+		}
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// searchLookup is how a search was answered.
+type searchLookup struct {
+	// Signature is the canonical key of the search, see database.SearchSignature.
+	Signature string
+	TopicID   int64
+	// Scraped reports that this request waited for a live scrape rather
+	// than being answered from what was stored before.
+	Scraped bool
+	// ScrapedAt is when the items served were scraped.
+	ScrapedAt *time.Time
+	// CacheStatus is the Cache-Status header value describing the lookup.
+	CacheStatus string
+}
+
+// lookupSearch makes sure the stored results of the search sig are recent
+// enough to serve according to the cache policy of its text, scraping
+// Vinted when they are not. Results within the fresh TTL are served as
+// they are; results within the stale window are served while a background
+// refresh runs; older results, unknown searches and forced refreshes wait
+// for a scrape. If that scrape fails, results younger than the policy's
+// max age are served instead.
+func (s *Server) lookupSearch(ctx context.Context, sig database.SearchSignature, force bool) (searchLookup, error) {
+	lookup := searchLookup{Signature: sig.String()}
+	search, err := s.db.GetSearch(ctx, lookup.Signature)
+	switch {
+	case err == nil:
+		lookup.TopicID, lookup.ScrapedAt = search.TopicID, &search.FetchedAt
+	case !errors.Is(err, database.ErrNotFound):
+		return searchLookup{}, fmt.Errorf("error getting search: %v", err)
+	}
+
+	policy := s.cache.policies.For(sig.Text)
+	scrape := func() error {
+		return s.scrapeSearch(sig)
+	}
+	var age time.Duration
+	if lookup.ScrapedAt != nil {
+		age = time.Since(*lookup.ScrapedAt)
+	}
+
+	fwd := "miss"
+	switch {
+	case force:
+		fwd = "request"
+	case lookup.ScrapedAt == nil:
+	case age < policy.Fresh:
+		lookup.CacheStatus = fmt.Sprintf("%s; hit; ttl=%d", cacheName, seconds(policy.Fresh-age))
+		return lookup, nil
+	case age < policy.Fresh+policy.Stale:
+		s.cache.do(lookup.Signature, scrape)
+		lookup.CacheStatus = fmt.Sprintf("%s; hit; ttl=%d; detail=revalidating", cacheName, seconds(policy.Fresh-age))
+		return lookup, nil
+	default:
+		fwd = "stale"
+	}
+
+	if err := s.allowScrape(ctx); err != nil {
+		return searchLookup{}, err
+	}
+	f := s.cache.do(lookup.Signature, scrape)
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return searchLookup{}, ctx.Err()
+	}
+	if f.err != nil {
+		if lookup.ScrapedAt != nil && age < policy.MaxAge {
+			lookup.CacheStatus = fmt.Sprintf("%s; hit; ttl=%d; detail=stale-if-error", cacheName, seconds(policy.Fresh-age))
+			return lookup, nil
+		}
+		return searchLookup{}, badGateway(f.err)
+	}
+
+	if search, err = s.db.GetSearch(ctx, lookup.Signature); err != nil {
+		return searchLookup{}, fmt.Errorf("error getting scraped search: %v", err)
+	}
+	lookup.TopicID, lookup.ScrapedAt = search.TopicID, &search.FetchedAt
+	lookup.Scraped = true
+	lookup.CacheStatus = fmt.Sprintf("%s; fwd=%s; fwd-status=%d; stored", cacheName, fwd, http.StatusOK)
+	return lookup, nil
+}
+
+// setCacheHeaders writes the Cache-Status and Age headers of lookup.
+func setCacheHeaders(w http.ResponseWriter, lookup searchLookup) {
+	w.Header().Set("Cache-Status", lookup.CacheStatus)
+	if lookup.ScrapedAt != nil {
+		w.Header().Set("Age", strconv.FormatInt(max(seconds(time.Since(*lookup.ScrapedAt)), 0), 10))
+	}
+}
+
+// seconds truncates d to whole seconds.
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// parseRefresh reads the refresh query parameter, which forces a search to
+// wait for a fresh scrape.
+func parseRefresh(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("refresh")
+	if v == "" {
+		return false, nil
+	}
+	refresh, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, badRequest("invalid refresh %q", v)
+	}
+	return refresh, nil
+}
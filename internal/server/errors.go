@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"vinted-scraper/internal/database"
+)
+
+// apiError is an error a handler returns to answer with a specific HTTP
+// status. Detail is shown to the client; Err, if set, is only logged.
+type apiError struct {
+	Status int
+	Detail string
+	Err    error
+	// RetryAfter, if set, tells the client when to try again.
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Status, e.Detail)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Detail: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusNotFound, Detail: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusConflict, Detail: fmt.Sprintf(format, args...)}
+}
+
+// unauthorized reports a missing or unknown API key.
+func unauthorized(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusUnauthorized, Detail: fmt.Sprintf(format, args...)}
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusForbidden, Detail: fmt.Sprintf(format, args...)}
+}
+
+// tooManyRequests reports a spent rate limit that allows another request
+// after retryAfter.
+func tooManyRequests(retryAfter time.Duration, format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusTooManyRequests, Detail: fmt.Sprintf(format, args...), RetryAfter: retryAfter}
+}
+
+// badGateway reports that Vinted, not this service, failed.
+func badGateway(err error) error {
+	return &apiError{Status: http.StatusBadGateway, Detail: "error searching Vinted", Err: err}
+}
+
+// problem is an RFC 7807 problem details document.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// handlerFunc is an http.HandlerFunc that returns its error instead of
+// writing it, so every error is rendered the same way by handle.
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// handle adapts h to an http.HandlerFunc that renders returned errors as
+// problem+json.
+func (s *Server) handle(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// writeError renders err as problem+json. Errors that are not an apiError
+// become a 400 for known client mistakes and a 500 otherwise, whose cause is
+// logged with the request id but not shown to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, database.ErrNotFound):
+		apiErr = &apiError{Status: http.StatusNotFound, Detail: err.Error()}
+	case errors.Is(err, database.ErrInvalidCursor), errors.Is(err, database.ErrEmptySearch):
+		apiErr = &apiError{Status: http.StatusBadRequest, Detail: err.Error()}
+	default:
+		apiErr = &apiError{Status: http.StatusInternalServerError, Err: err}
+	}
+
+	switch apiErr.Status {
+	case http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vinted-scraper"`)
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(apiErr.RetryAfter, time.Second).Seconds()))))
+	}
+	requestID := middleware.GetReqID(r.Context())
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("[%s] %s %s: %v", requestID, r.Method, r.URL.Path, err)
+	}
+	writeProblem(w, problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(apiErr.Status),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Detail,
+		Instance:  r.URL.Path,
+		RequestID: requestID,
+	})
+}
+
+func writeProblem(w http.ResponseWriter, p problem) {
+	body, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(body)
+}
+
+// requestID echoes the id chi's RequestID middleware assigned to the
+// request in the X-Request-Id response header.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(middleware.RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverer turns a panicking handler into a 500 problem instead of a
+// dropped connection.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				writeError(w, r, fmt.Errorf("panic: %v", v))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
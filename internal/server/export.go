@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/export"
+)
+
+// exportWriteTimeout is how long an export waits for the client to accept
+// a batch of rows before giving up on it.
+const exportWriteTimeout = 30 * time.Second
+
+// deadlineWriter gives every write to the response its own deadline, so a
+// long export outlives the server's WriteTimeout but a stalled client does
+// not keep it open.
+type deadlineWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	if err := d.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return d.w.Write(p)
+}
+
+// exportHandler serves GET /v1/export/{dataset}?format=: items, photos,
+// price_history or runs as csv, ndjson (default) or parquet, filtered with
+// the same parameters and workspace as GET /v1/items. The file is streamed as
+// it is read.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) error {
+	dataset, err := export.ParseDataset(chi.URLParam(r, "dataset"))
+	if err != nil {
+		return notFound("%v", err)
+	}
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = string(export.NDJSON)
+	}
+	format, err := export.ParseFormat(formatName)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	q, err := database.ParseItemQuery(r.URL.Query())
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	if err := s.scopeItems(r.Context(), &q); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", format.Filename(dataset)))
+	if err := export.Write(r.Context(), s.db, deadlineWriter{w, http.NewResponseController(w)}, dataset, format, q); err != nil {
+		// The status line has already gone out; all we can do is cut the stream short.
+		log.Printf("[%s] error exporting %s: %v", middleware.GetReqID(r.Context()), dataset, err)
+	}
+	return nil
+}
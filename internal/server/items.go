@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"vinted-scraper/internal/database"
+)
+
+// itemsHandler serves GET /v1/items: stored items of the workspace's topics
+// filtered, sorted and paged according to the query string (see
+// database.ParseItemQuery).
+func (s *Server) itemsHandler(w http.ResponseWriter, r *http.Request) error {
+	q, err := database.ParseItemQuery(r.URL.Query())
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	if err := s.scopeItems(r.Context(), &q); err != nil {
+		return err
+	}
+	return s.writeItems(w, r, q, responseMeta{Source: sourceCache})
+}
+
+// topicItemsHandler serves GET /v1/topics/{id}/items, the stored items of
+// one topic with the same filters as GET /v1/items.
+func (s *Server) topicItemsHandler(w http.ResponseWriter, r *http.Request) error {
+	topic, err := s.topicFromURL(r)
+	if err != nil {
+		return err
+	}
+	s.markRead(r.Context(), topic.ID)
+	q, err := database.ParseItemQuery(r.URL.Query())
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	q.WorkspaceID, q.TopicID = s.workspace(r.Context()), topic.ID
+	meta := responseMeta{Source: sourceCache, FetchedAt: topic.LastScrapedAt}
+	response, err := s.renderItems(r.Context(), q, meta)
+	if err != nil {
+		return err
+	}
+	writeConditional(w, r, response, topic.UpdatedAt)
+	return nil
+}
+
+// writeItems queries one page of items and writes it with its pagination,
+// as writeConditional does with meta.FetchedAt as the Last-Modified time.
+func (s *Server) writeItems(w http.ResponseWriter, r *http.Request, q database.ItemQuery, meta responseMeta) error {
+	response, err := s.renderItems(r.Context(), q, meta)
+	if err != nil {
+		return err
+	}
+	writeConditional(w, r, response, meta.FetchedAt)
+	return nil
+}
+
+// renderItems queries one page of items and serializes it with its pagination.
+func (s *Server) renderItems(ctx context.Context, q database.ItemQuery, meta responseMeta) ([]byte, error) {
+	page, err := s.db.QueryItems(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit == 0 {
+		limit = database.DefaultItemLimit
+	}
+	meta.Pagination = &pagination{Limit: limit, NextCursor: page.NextCursor}
+	return marshalData(page.Items, meta)
+}
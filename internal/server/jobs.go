@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// maxJobPages bounds the depth of a scrape job.
+const maxJobPages = 100
+
+// envInt and envDuration read a positive setting of the job queue,
+// logging and using def when it is invalid.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Printf("Invalid %s %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %v: %v", key, v, def, err)
+		return def
+	}
+	return d
+}
+
+// jobRunner holds the settings of the job queue workers: JOB_WORKERS
+// (default 2) jobs run at once, each under a lease of JOB_LEASE (default
+// 1m) renewed every third of it. A failed attempt is retried after
+// JOB_RETRY_BACKOFF (default 30s), doubling with each attempt, up to
+// JOB_MAX_ATTEMPTS (default 3) attempts.
+type jobRunner struct {
+	workers     int
+	lease       time.Duration
+	backoff     time.Duration
+	maxAttempts int
+	// wake tells an idle worker a job was queued.
+	wake chan struct{}
+}
+
+func newJobRunner() *jobRunner {
+	return &jobRunner{
+		workers:     envInt("JOB_WORKERS", 2),
+		lease:       envDuration("JOB_LEASE", time.Minute),
+		backoff:     envDuration("JOB_RETRY_BACKOFF", 30*time.Second),
+		maxAttempts: envInt("JOB_MAX_ATTEMPTS", 3),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// notify wakes an idle worker, if any.
+func (j *jobRunner) notify() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retryAfter returns how long to wait before the next attempt of a job
+// that failed its attempt-th attempt.
+func (j *jobRunner) retryAfter(attempt int) time.Duration {
+	return j.backoff << min(attempt-1, 16)
+}
+
+// RunJobs runs jobs from the queue shared with other processes until ctx
+// is done. Idle workers wake when a job is queued here and at every
+// heartbeat, which also picks up jobs queued elsewhere, retries that came
+// due and jobs whose worker died.
+func (s *Server) RunJobs(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.jobs.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.jobWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// jobWorker claims and runs one job at a time until ctx is done.
+func (s *Server) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		job, err := s.db.ClaimScrapeJob(ctx, s.instance, s.jobs.lease)
+		if err == nil {
+			// More jobs may be ready; let another worker look.
+			s.jobs.notify()
+			s.runJob(ctx, job)
+			continue
+		}
+		if !errors.Is(err, database.ErrNotFound) && ctx.Err() == nil {
+			log.Printf("Error claiming scrape job: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.jobs.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runJob fetches the pages of a claimed job after the last one fetched,
+// storing its progress after each, until it reaches the job's depth or
+// Vinted's last page, a page fails, or the job is lost to cancellation or
+// an expired lease. A failed page queues the job for a retry, or
+// dead-letters it after its last attempt. Jobs wait for scrape budget
+// instead of borrowing it, so they never crowd out client requests.
+func (s *Server) runJob(ctx context.Context, job database.ScrapeJob) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.heartbeatJob(jobCtx, cancel, job.ID)
+
+	sig := database.NewSearchSignature(job.Query, job.Order, "", job.Currency, nil)
+	if job.Signature != "" {
+		parsed, err := database.ParseSearchSignature(job.Signature)
+		if err != nil {
+			log.Printf("Scrape job %d: %v", job.ID, err)
+		} else {
+			sig = parsed
+		}
+	}
+	for job.Status == database.JobRunning {
+		// Searches have clients waiting on them, so they borrow budget
+		// like any request does.
+		if wait := s.scrapes.wait(); wait > 0 && job.Kind != database.JobKindSearch {
+			select {
+			case <-jobCtx.Done():
+			case <-time.After(wait):
+				continue
+			}
+		}
+		if jobCtx.Err() != nil {
+			if ctx.Err() != nil {
+				// Shutting down: hand the job back to the queue at once
+				// rather than leaving it to its lease.
+				job.Status = database.JobQueued
+				job.RunAfter = nil
+				s.saveJob(job)
+			}
+			return
+		}
+
+		page := job.PagesFetched + 1
+		result, ingest, err := s.scrapePage(sig, page)
+		if ingest.TopicID != 0 {
+			if job.TopicID == 0 && job.WorkspaceID != 0 {
+				// The workspace that queued the job sees the topic it filled.
+				if err := s.db.AddWorkspaceTopic(context.Background(), job.WorkspaceID, ingest.TopicID); err != nil {
+					log.Printf("Scrape job %d: %v", job.ID, err)
+				}
+			}
+			job.TopicID = ingest.TopicID
+		}
+		if err != nil {
+			job.Errors++
+			job.LastError = err.Error()
+		}
+		switch {
+		case err != nil && job.Attempts >= job.MaxAttempts:
+			job.Status = database.JobDead
+		case err != nil:
+			job.Status = database.JobQueued
+			retry := time.Now().Add(s.jobs.retryAfter(job.Attempts))
+			job.RunAfter = &retry
+		default:
+			job.PagesFetched = page
+			job.ItemsNew += ingest.New
+			job.ItemsUpdated += ingest.Updated
+			if page >= job.Pages || page >= result.Pagination.TotalPages || len(result.Items) == 0 {
+				job.Status = database.JobSucceeded
+			}
+		}
+		if !s.saveJob(job) {
+			return
+		}
+	}
+}
+
+// searchJobPriority puts search jobs ahead of the jobs queued through the
+// API and by the scheduler.
+const searchJobPriority = 1 << 30
+
+// searchJobPoll is how often a request waits between checks on a search
+// job another process is running.
+const searchJobPoll = 100 * time.Millisecond
+
+// scrapeSearch scrapes the first page of the live search sig through the
+// job queue, so that replicas serving the same search share one scrape:
+// the job is deduplicated by its signature and run by whichever process
+// claims it. This process runs it when it can claim it, even without job
+// workers, and otherwise waits for the process that did.
+func (s *Server) scrapeSearch(sig database.SearchSignature) error {
+	ctx := context.Background()
+	job, err := s.db.CreateScrapeJob(ctx, database.ScrapeJob{
+		Kind:        database.JobKindSearch,
+		Query:       sig.Text,
+		Order:       sig.Order,
+		Currency:    sig.Currency,
+		Pages:       1,
+		Priority:    searchJobPriority,
+		DedupeKey:   "search:" + sig.String(),
+		Signature:   sig.String(),
+		MaxAttempts: 1,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		claimed, err := s.db.ClaimScrapeJobID(ctx, job.ID, s.instance, s.jobs.lease)
+		switch {
+		case err == nil:
+			s.runJob(ctx, claimed)
+		case !errors.Is(err, database.ErrNotFound):
+			return err
+		}
+		if job, err = s.db.GetScrapeJob(ctx, job.ID); err != nil {
+			return err
+		}
+		switch job.Status {
+		case database.JobSucceeded:
+			return nil
+		case database.JobDead, database.JobCancelled:
+			if job.LastError != "" {
+				return errors.New(job.LastError)
+			}
+			return fmt.Errorf("search job %d %s", job.ID, job.Status)
+		}
+		time.Sleep(searchJobPoll)
+	}
+}
+
+// saveJob stores the progress of a running job, reporting false when the
+// worker lost it. Progress is saved even during shutdown, so pages are
+// not fetched twice.
+func (s *Server) saveJob(job database.ScrapeJob) bool {
+	err := s.db.UpdateScrapeJob(context.Background(), job)
+	if errors.Is(err, database.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error saving progress of scrape job %d: %v", job.ID, err)
+		return false
+	}
+	return true
+}
+
+// heartbeatJob renews the lease of a running job until ctx is done,
+// calling cancel if the job was lost.
+func (s *Server) heartbeatJob(ctx context.Context, cancel context.CancelFunc, id int64) {
+	ticker := time.NewTicker(s.jobs.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := s.db.HeartbeatScrapeJob(ctx, id, s.instance, s.jobs.lease)
+		if errors.Is(err, database.ErrNotFound) {
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error renewing lease of scrape job %d: %v", id, err)
+		}
+	}
+}
+
+// jobRequest is the body of POST /v1/jobs.
+type jobRequest struct {
+	Q        string `json:"q"`
+	Order    string `json:"order"`
+	Currency string `json:"currency"`
+	Pages    int    `json:"pages"`
+	// Priority orders the queue: higher priorities are claimed first.
+	Priority int `json:"priority"`
+}
+
+// createJobHandler serves POST /v1/jobs: it queues a scrape of the first
+// pages (default 1) of a search for any worker of the deployment and
+// answers 202 with the job, whose progress GET /v1/jobs/{id} reports.
+func (s *Server) createJobHandler(w http.ResponseWriter, r *http.Request) error {
+	var req jobRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	sig := database.NewSearchSignature(req.Q, "", "", "", nil)
+	if sig.Text == "" {
+		return badRequest("missing q")
+	}
+	order, err := vintedscraper.ParseOrder(req.Order)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	currency := strings.ToUpper(req.Currency)
+	if currency != "" && !validCurrency(currency) {
+		return badRequest("invalid currency %q", req.Currency)
+	}
+	if req.Pages == 0 {
+		req.Pages = 1
+	}
+	if req.Pages < 1 || req.Pages > maxJobPages {
+		return badRequest("invalid pages %d, want 1 to %d", req.Pages, maxJobPages)
+	}
+
+	job, err := s.db.CreateScrapeJob(r.Context(), database.ScrapeJob{
+		WorkspaceID: s.owner(r.Context()),
+		Kind:        database.JobKindAPI,
+		Query:       sig.Text,
+		Order:       string(order),
+		Currency:    currency,
+		Pages:       req.Pages,
+		Priority:    req.Priority,
+		MaxAttempts: s.jobs.maxAttempts,
+	})
+	if err != nil {
+		return err
+	}
+	s.jobs.notify()
+	w.Header().Set("Location", "/v1/jobs/"+strconv.FormatInt(job.ID, 10))
+	return writeDataStatus(w, http.StatusAccepted, job, responseMeta{})
+}
+
+// jobsHandler serves GET /v1/jobs, the jobs of the workspace newest first,
+// optionally filtered by status and kind and paged with limit and cursor
+// (the id of the last job seen). ?status=dead lists the dead-letter queue.
+func (s *Server) jobsHandler(w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	q := database.JobQuery{
+		WorkspaceID: s.workspace(r.Context()),
+		Status:      database.JobStatus(values.Get("status")),
+		Kind:        values.Get("kind"),
+	}
+	switch q.Status {
+	case "", database.JobQueued, database.JobRunning, database.JobSucceeded, database.JobCancelled, database.JobDead:
+	default:
+		return badRequest("invalid status %q", q.Status)
+	}
+	switch q.Kind {
+	case "", database.JobKindAPI, database.JobKindSchedule, database.JobKindSearch:
+	default:
+		return badRequest("invalid kind %q", q.Kind)
+	}
+	var err error
+	if v := values.Get("cursor"); v != "" {
+		if q.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return badRequest("invalid cursor %q", v)
+		}
+	}
+	q.Limit = database.DefaultItemLimit
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return badRequest("invalid limit %q", v)
+		}
+		if q.Limit > database.MaxItemLimit {
+			q.Limit = database.MaxItemLimit
+		}
+	}
+
+	jobs, err := s.db.ListScrapeJobs(r.Context(), q)
+	if err != nil {
+		return err
+	}
+	page := &pagination{Limit: q.Limit}
+	if len(jobs) == q.Limit {
+		page.NextCursor = strconv.FormatInt(jobs[len(jobs)-1].ID, 10)
+	}
+	return writeData(w, jobs, responseMeta{Pagination: page})
+}
+
+// jobHandler serves GET /v1/jobs/{id}, a job with its progress.
+func (s *Server) jobHandler(w http.ResponseWriter, r *http.Request) error {
+	job, err := s.jobFromURL(r)
+	if err != nil {
+		return err
+	}
+	return writeData(w, job, responseMeta{})
+}
+
+// cancelJobHandler serves POST /v1/jobs/{id}/cancel. A running job stops
+// at its worker's next heartbeat, or after the page it is fetching; jobs
+// that already finished answer 409.
+func (s *Server) cancelJobHandler(w http.ResponseWriter, r *http.Request) error {
+	job, err := s.jobFromURL(r)
+	if err != nil {
+		return err
+	}
+	if job, err = s.db.CancelScrapeJob(r.Context(), job.ID); err != nil {
+		return err
+	}
+	if job.Status != database.JobCancelled {
+		return conflict("job %d already %s", job.ID, job.Status)
+	}
+	return writeData(w, job, responseMeta{})
+}
+
+// retryJobHandler serves POST /v1/jobs/{id}/retry, which takes a job off
+// the dead-letter queue with fresh attempts; other jobs answer 409.
+func (s *Server) retryJobHandler(w http.ResponseWriter, r *http.Request) error {
+	job, err := s.jobFromURL(r)
+	if err != nil {
+		return err
+	}
+	if job.Status != database.JobDead {
+		return conflict("job %d is %s, only dead jobs can be retried", job.ID, job.Status)
+	}
+	if job, err = s.db.RetryScrapeJob(r.Context(), job.ID); err != nil {
+		return err
+	}
+	s.jobs.notify()
+	return writeData(w, job, responseMeta{})
+}
+
+// jobFromURL loads the scrape job named by the {id} URL parameter, if the
+// workspace queued it.
+func (s *Server) jobFromURL(r *http.Request) (database.ScrapeJob, error) {
+	id := chi.URLParam(r, "id")
+	jobID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return database.ScrapeJob{}, badRequest("invalid job id %q", id)
+	}
+	job, err := s.db.GetScrapeJob(r.Context(), jobID)
+	if ws := s.workspace(r.Context()); err == database.ErrNotFound || (err == nil && ws != database.AllWorkspaces && job.WorkspaceID != ws) {
+		return database.ScrapeJob{}, notFound("job %d not found", jobID)
+	}
+	return job, err
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"vinted-scraper/internal/database"
+)
+
+// leaderLock is the lock the replicas sharing a database compete for.
+const leaderLock = "vinted-scraper/leader"
+
+// leaderTask is a background task run only by the leader.
+type leaderTask struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// election runs the leader-only tasks in whichever process holds the leader
+// lock. Every LEADER_CHECK_INTERVAL (default 10s) the leader checks it still
+// holds the lock and the others try to take it.
+type election struct {
+	interval time.Duration
+
+	mu    sync.Mutex
+	tasks []leaderTask
+	lock  *database.LeaderLock
+}
+
+func newElection() *election {
+	return &election{interval: envDuration("LEADER_CHECK_INTERVAL", 10*time.Second)}
+}
+
+// leading reports whether this process holds the leader lock.
+func (e *election) leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lock != nil
+}
+
+// RegisterLeaderTask makes run a leader-only task: while this process is
+// leader it runs with a context cancelled once leadership is lost or the
+// election stops. Tasks registered during a term start with the next one.
+func (s *Server) RegisterLeaderTask(name string, run func(ctx context.Context)) {
+	s.election.mu.Lock()
+	defer s.election.mu.Unlock()
+	s.election.tasks = append(s.election.tasks, leaderTask{name: name, run: run})
+}
+
+// RunLeaderElection competes for the leader lock until ctx is done, running
+// the leader-only tasks whenever this process holds it.
+func (s *Server) RunLeaderElection(ctx context.Context) {
+	for {
+		lock, err := s.db.AcquireLeadership(ctx, leaderLock, s.instance)
+		switch {
+		case err == nil:
+			s.lead(ctx, lock)
+		case !errors.Is(err, database.ErrNotLeader) && ctx.Err() == nil:
+			log.Printf("Error electing leader: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.election.interval):
+		}
+	}
+}
+
+// lead runs the leader-only tasks until ctx is done or lock is lost, then
+// waits for them to stop and releases the lock.
+func (s *Server) lead(ctx context.Context, lock *database.LeaderLock) {
+	s.election.mu.Lock()
+	s.election.lock = lock
+	tasks := append([]leaderTask(nil), s.election.tasks...)
+	s.election.mu.Unlock()
+	log.Printf("Leading as %s, running %d tasks", s.instance, len(tasks))
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task.run(taskCtx)
+			if taskCtx.Err() == nil {
+				log.Printf("Leader task %s stopped", task.name)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(s.election.interval)
+	defer ticker.Stop()
+	for held := true; held; {
+		select {
+		case <-ctx.Done():
+			held = false
+		case <-ticker.C:
+			if err := lock.Check(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Stepping down: %v", err)
+				held = false
+			}
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	s.election.mu.Lock()
+	s.election.lock = nil
+	s.election.mu.Unlock()
+	if err := lock.Release(); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// leaderHealth adds the leader election to a health report: this process's
+// instance, the current leader and whether this process is it.
+func (s *Server) leaderHealth(ctx context.Context, health map[string]string) {
+	health["instance"] = s.instance
+	health["is_leader"] = "false"
+	if s.election.leading() {
+		health["is_leader"] = "true"
+	}
+	leader, err := s.db.Leader(ctx, leaderLock)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	health["leader"] = leader
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a rate limit of n events per period with bursts of up
+// to n. A nil *tokenBucket allows everything.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(n int, period time.Duration) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(n) / period.Seconds(),
+		burst:  float64(n),
+		tokens: float64(n),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens earned since the last call. b.mu must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take takes a token whether or not one is available, borrowing up to a
+// full burst from the future, for events that cannot be refused.
+func (b *tokenBucket) take() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = max(b.tokens-1, -b.burst)
+}
+
+// allow takes a token if one is available and reports whether it did.
+func (b *tokenBucket) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait returns how long until a token is available.
+func (b *tokenBucket) wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// parseRate parses a rate limit written "<n>/<period>", such as "30/1m".
+func parseRate(v string) (int, time.Duration, error) {
+	count, period, ok := strings.Cut(v, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid rate %q, want <n>/<period>", v)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q: bad count", v)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q: bad period", v)
+	}
+	return n, d, nil
+}
+
+// envRate reads the rate limit in the environment variable key, written
+// like parseRate expects, falling back to n per period.
+func envRate(key string, n int, period time.Duration) (int, time.Duration) {
+	v := os.Getenv(key)
+	if v == "" {
+		return n, period
+	}
+	count, d, err := parseRate(v)
+	if err != nil {
+		log.Printf("Invalid %s, using %d/%v: %v", key, n, period, err)
+		return n, period
+	}
+	return count, d
+}
+
+// limitBucket returns a bucket of n tokens per period, or nil, which allows
+// everything, when n is 0.
+func limitBucket(n int, period time.Duration) *tokenBucket {
+	if n == 0 {
+		return nil
+	}
+	return newTokenBucket(n, period)
+}
+
+// scrapeLimit reads SCRAPE_RATE_LIMIT ("<n>/<period>", default 30/1m), how
+// often the server may call Vinted. A count of 0 removes the limit.
+// Scrapes requested by clients always run but use up the budget; the
+// job workers only scrape when budget is left.
+func scrapeLimit() *tokenBucket {
+	return limitBucket(envRate("SCRAPE_RATE_LIMIT", 30, time.Minute))
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sources reported in responseMeta.
+const (
+	sourceCache = "cache"
+	sourceLive  = "live"
+)
+
+// envelope wraps every /v1 response body.
+type envelope struct {
+	Data interface{}  `json:"data"`
+	Meta responseMeta `json:"meta"`
+}
+
+// responseMeta describes where the data came from and how to page through it.
+type responseMeta struct {
+	// Source is "live" when the request scraped Vinted, "cache" when it was
+	// answered from the database.
+	Source     string      `json:"source,omitempty"`
+	FetchedAt  *time.Time  `json:"fetched_at,omitempty"`
+	Pagination *pagination `json:"pagination,omitempty"`
+}
+
+type pagination struct {
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// maxRequestBody caps the size of JSON request bodies.
+const maxRequestBody = 1 << 16
+
+// decodeJSON reads a JSON request body into v, rejecting unknown fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// writeData writes data and meta as a JSON envelope.
+func writeData(w http.ResponseWriter, data interface{}, meta responseMeta) error {
+	response, err := marshalData(data, meta)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, response)
+	return nil
+}
+
+// writeDataStatus writes data and meta as a JSON envelope with status.
+func writeDataStatus(w http.ResponseWriter, status int, data interface{}, meta responseMeta) error {
+	response, err := marshalData(data, meta)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(response)
+	return nil
+}
+
+// marshalData serializes data and meta as a JSON envelope.
+func marshalData(data interface{}, meta responseMeta) ([]byte, error) {
+	response, err := json.Marshal(envelope{Data: data, Meta: meta})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling response: %v", err)
+	}
+	return response, nil
+}
+
+// writeJSON writes a serialized JSON response.
+func writeJSON(w http.ResponseWriter, response []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+// writeConditional writes a serialized JSON response with an ETag hashed
+// from its content and, if known, the Last-Modified time of the data it
+// shows, answering 304 Not Modified when the request's If-None-Match or
+// If-Modified-Since show the client already has it.
+func writeConditional(w http.ResponseWriter, r *http.Request, response []byte, lastModified *time.Time) {
+	sum := sha256.Sum256(response)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", "application/json")
+	var modified time.Time
+	if lastModified != nil {
+		modified = *lastModified
+	}
+	http.ServeContent(w, r, "", modified, bytes.NewReader(response))
+}
+
+// writeCached writes the response stored under key in the response cache,
+// or renders, writes and stores it on a miss, as writeConditional does.
+// The response is dropped when the items of topicID change, and is keyed
+// by lastModified too so a scrape by another replica, which this process
+// is not told about, is not answered from the cache.
+func (s *Server) writeCached(w http.ResponseWriter, r *http.Request, key string, topicID int64, lastModified *time.Time, render func() ([]byte, error)) error {
+	if lastModified != nil {
+		key = fmt.Sprintf("%s@%d", key, lastModified.UnixNano())
+	}
+	response, generation, ok := s.responses.Get(key, topicID)
+	if !ok {
+		var err error
+		if response, err = render(); err != nil {
+			return err
+		}
+		s.responses.Put(key, topicID, generation, response)
+	}
+	writeConditional(w, r, response, lastModified)
+	return nil
+}
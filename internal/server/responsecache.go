@@ -0,0 +1,172 @@
+package server
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultResponseCacheBytes bounds the response cache when
+// RESPONSE_CACHE_BYTES is not set.
+const defaultResponseCacheBytes = 64 << 20
+
+// responseCacheBytes reads RESPONSE_CACHE_BYTES, the total size of the
+// response bodies kept in memory. Zero disables the cache.
+func responseCacheBytes() int {
+	v := os.Getenv("RESPONSE_CACHE_BYTES")
+	if v == "" {
+		return defaultResponseCacheBytes
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Invalid RESPONSE_CACHE_BYTES %q, using %d: %v", v, defaultResponseCacheBytes, err)
+		return defaultResponseCacheBytes
+	}
+	return n
+}
+
+// defaultResponseCacheTTL bounds the age of a cached response when
+// RESPONSE_CACHE_TTL is not set.
+const defaultResponseCacheTTL = time.Minute
+
+// responseCacheTTL reads RESPONSE_CACHE_TTL, how long a response is served
+// from memory. Invalidations only reach this process, so the TTL bounds how
+// long a change made by another replica, such as items moving to another
+// topic, can go unseen.
+func responseCacheTTL() time.Duration {
+	return envDuration("RESPONSE_CACHE_TTL", defaultResponseCacheTTL)
+}
+
+// responseCache is a size-bounded LRU of serialized responses. Entries
+// belong to a topic, are dropped when that topic's items change and expire
+// after ttl.
+type responseCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	ttl      time.Duration
+	bytes    int
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+	// generations counts the invalidations of each topic and epoch those
+	// of the whole cache, so a response read before an invalidation is not
+	// stored after it.
+	generations map[int64]uint64
+	epoch       uint64
+	stats       responseCacheStats
+}
+
+type responseCacheEntry struct {
+	key     string
+	topicID int64
+	body    []byte
+	expires time.Time
+}
+
+// responseCacheStats are the counters served by GET /v1/cache.
+type responseCacheStats struct {
+	Entries       int    `json:"entries"`
+	Bytes         int    `json:"bytes"`
+	MaxBytes      int    `json:"max_bytes"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Expirations   uint64 `json:"expirations"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+func newResponseCache(maxBytes int, ttl time.Duration) *responseCache {
+	return &responseCache{
+		maxBytes:    maxBytes,
+		ttl:         ttl,
+		order:       list.New(),
+		entries:     map[string]*list.Element{},
+		generations: map[int64]uint64{},
+	}
+}
+
+// Get returns the unexpired body stored under key, and the generation of
+// topicID to pass to Put when there is none.
+func (c *responseCache) Get(key string, topicID int64) ([]byte, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*responseCacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(e)
+			c.stats.Hits++
+			return entry.body, 0, true
+		}
+		c.remove(e)
+		c.stats.Expirations++
+	}
+	c.stats.Misses++
+	return nil, c.generation(topicID), false
+}
+
+// Put stores body under key unless topicID was invalidated since
+// generation was returned by Get, or body alone exceeds the cache.
+func (c *responseCache) Put(key string, topicID int64, generation uint64, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(body) > c.maxBytes || c.generation(topicID) != generation {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&responseCacheEntry{key: key, topicID: topicID, body: body, expires: time.Now().Add(c.ttl)})
+	c.bytes += len(body)
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// InvalidateTopic drops every response of topicID.
+func (c *responseCache) InvalidateTopic(topicID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[topicID]++
+	for e := c.order.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*responseCacheEntry).topicID == topicID {
+			c.remove(e)
+			c.stats.Invalidations++
+		}
+		e = next
+	}
+}
+
+// Clear drops every response, for changes that may touch any topic.
+func (c *responseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.stats.Invalidations += uint64(len(c.entries))
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.bytes = 0
+}
+
+// Stats returns the current counters.
+func (c *responseCache) Stats() responseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries, stats.Bytes, stats.MaxBytes = len(c.entries), c.bytes, c.maxBytes
+	return stats
+}
+
+// generation changes whenever the responses of topicID are invalidated.
+func (c *responseCache) generation(topicID int64) uint64 {
+	return c.epoch + c.generations[topicID]
+}
+
+func (c *responseCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*responseCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.body)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"vinted-scraper/internal/database"
+)
+
+// retentionInterval reads RETENTION_INTERVAL (a Go duration, default 24h).
+// Zero or a negative value disables the background retention job.
+func retentionInterval() time.Duration {
+	v := os.Getenv("RETENTION_INTERVAL")
+	if v == "" {
+		return 24 * time.Hour
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid RETENTION_INTERVAL %q, retention job disabled: %v", v, err)
+		return 0
+	}
+	return interval
+}
+
+// runRetention prunes the database with policy every interval until ctx is done.
+func (s *Server) runRetention(ctx context.Context, policy database.RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.db.Prune(ctx, policy, false)
+			if err != nil {
+				log.Printf("Retention job failed: %v", err)
+				continue
+			}
+			if report.Items > 0 {
+				s.responses.Clear()
+			}
+			log.Printf("Retention job removed %d items, %d price history rows, %d thumbnails, %d photos",
+				report.Items, report.PriceHistory, report.Thumbnails, report.Photos)
+		}
+	}
+}
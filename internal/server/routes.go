@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"vinted-scraper/internal/database"
 	vintedscraper "vinted-scraper/internal/vinted-scraper"
 
 	"github.com/go-chi/chi/v5"
@@ -13,68 +18,179 @@ import (
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(requestID)
 	r.Use(middleware.Logger)
+	r.Use(recoverer)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, notFound("no route for %s", r.URL.Path))
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, &apiError{Status: http.StatusMethodNotAllowed, Detail: fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path)})
+	})
 
 	r.Get("/", s.HelloWorldHandler)
 
 	r.Get("/health", s.healthHandler)
-	r.Get("/vintedTopic/{topic}-{order}", s.vintedTopicHandler)
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.authenticate)
+
+		// Deprecated: use /v1/search, which also carries filters and pagination.
+		r.With(s.requireRefresh).Get("/vintedTopic/{topicOrder}", s.handle(s.vintedTopicHandler))
+
+		r.Route("/v1", func(r chi.Router) {
+			// Every key may read; the routes that change state, and
+			// searches forced with refresh=true, take an operator or admin
+			// key.
+			r.Get("/workspace", s.handle(s.workspaceHandler))
+			r.Get("/topics", s.handle(s.topicsHandler))
+			r.Get("/topics/{id}", s.handle(s.topicHandler))
+			r.Get("/topics/{id}/items", s.handle(s.topicItemsHandler))
+			r.Get("/topics/{id}/stream", s.handle(s.streamHandler))
+			r.Get("/topics/{id}/runs", s.handle(s.runsHandler))
+			r.Get("/topics/{id}/stats", s.handle(s.topicStatsHandler))
+			r.Get("/topics/{id}/schedule", s.handle(s.scheduleHandler))
+			r.Get("/brands/{name}/stats", s.handle(s.brandStatsHandler))
+			r.Get("/items", s.handle(s.itemsHandler))
+			r.With(s.requireRefresh).Get("/search", s.handle(s.searchHandler))
+			r.Get("/search/local", s.handle(s.localSearchHandler))
+			r.Get("/runs", s.handle(s.runsHandler))
+			r.Get("/export/{dataset}", s.handle(s.exportHandler))
+			r.Get("/cache", s.handle(s.cacheHandler))
+			r.Get("/ws", s.handle(s.websocketHandler))
+			r.Get("/webhooks", s.handle(s.webhooksHandler))
+			r.Get("/webhooks/{id}", s.handle(s.webhookHandler))
+			r.Get("/webhooks/{id}/deliveries", s.handle(s.webhookDeliveriesHandler))
+			r.Get("/saved-searches", s.handle(s.savedSearchesHandler))
+			r.Get("/saved-searches/{id}", s.handle(s.savedSearchHandler))
+			r.Get("/saved-searches/{id}/items", s.handle(s.savedSearchItemsHandler))
+			r.Get("/saved-searches/{id}/alerts", s.handle(s.alertsHandler))
+			r.Get("/alerts", s.handle(s.alertsHandler))
+			r.Get("/jobs", s.handle(s.jobsHandler))
+			r.Get("/jobs/{id}", s.handle(s.jobHandler))
+
+			r.Group(func(r chi.Router) {
+				r.Use(s.require(database.RoleOperator))
+				r.Post("/webhooks", s.handle(s.createWebhookHandler))
+				r.Delete("/webhooks/{id}", s.handle(s.deleteWebhookHandler))
+				r.Post("/webhooks/{id}/test", s.handle(s.testWebhookHandler))
+				r.Post("/saved-searches", s.handle(s.createSavedSearchHandler))
+				r.Delete("/saved-searches/{id}", s.handle(s.deleteSavedSearchHandler))
+				r.Post("/saved-searches/{id}/rules", s.handle(s.createAlertRuleHandler))
+				r.Delete("/saved-searches/{id}/rules/{rule}", s.handle(s.deleteAlertRuleHandler))
+				r.Post("/jobs", s.handle(s.createJobHandler))
+				r.Post("/jobs/{id}/cancel", s.handle(s.cancelJobHandler))
+				r.Post("/jobs/{id}/retry", s.handle(s.retryJobHandler))
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(s.require(database.RoleAdmin))
+				r.Post("/keys", s.handle(s.createAPIKeyHandler))
+				r.Get("/keys", s.handle(s.apiKeysHandler))
+				r.Delete("/keys/{id}", s.handle(s.revokeAPIKeyHandler))
+				r.Get("/audit", s.handle(s.auditHandler))
+			})
+		})
+	})
 	return r
 }
-func (s *Server) vintedTopicHandler(w http.ResponseWriter, r *http.Request) {
-	topic := chi.URLParam(r, "topic")
-	order := chi.URLParam(r, "order")
-	fmt.Println("topic:", topic)
-	topicId, err := s.db.ExistsTopic(topic)
-	fmt.Println("topicId:", topicId)
-
-	if topicId != 0 {
-		go func() {
-			_, err := SearchAndInsert(err, topic, order, s)
-			if err != nil {
-				fmt.Println("Error searching in goroutine:", err)
-			}
-		}()
-		fmt.Println("Topic found")
-		getCachedItems(s, w, topicId)
-		return
+
+// vintedTopicHandler serves the deprecated /vintedTopic/{topic}-{order}
+// route. Orders never contain a hyphen, so the topic is everything before
+// the last one and may itself contain hyphens.
+func (s *Server) vintedTopicHandler(w http.ResponseWriter, r *http.Request) error {
+	topic, order := chi.URLParam(r, "topicOrder"), ""
+	if i := strings.LastIndex(topic, "-"); i >= 0 {
+		topic, order = topic[:i], topic[i+1:]
+	}
+	successor := url.Values{"q": {topic}, "order": {order}}
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("</v1/search?%s>; rel=\"successor-version\"", successor.Encode()))
+
+	refresh, err := parseRefresh(r)
+	if err != nil {
+		return err
 	}
-	result, err := SearchAndInsert(err, topic, order, s)
-	response, err := json.Marshal(result)
+	sig := database.NewSearchSignature(topic, string(vintedscraper.ToOrder(order)), "", "", nil)
+	lookup, err := s.lookupSearch(r.Context(), sig, refresh)
 	if err != nil {
-		fmt.Println("Marshal error:", err)
-		return
+		return err
 	}
-	_, _ = w.Write(response)
+	setCacheHeaders(w, lookup)
+	s.markRead(r.Context(), lookup.TopicID)
+	s.seeTopic(r.Context(), lookup.TopicID)
 
+	return s.writeCached(w, r, "vintedTopic?"+lookup.Signature, lookup.TopicID, lookup.ScrapedAt, func() ([]byte, error) {
+		page, err := s.db.QueryItems(r.Context(), database.ItemQuery{
+			WorkspaceID: s.workspace(r.Context()),
+			Signature:   lookup.Signature,
+			Sort:        database.SortRelevance,
+			Limit:       database.MaxItemLimit,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting items from database: %v", err)
+		}
+		return json.Marshal(page.Items)
+	})
 }
 
-func SearchAndInsert(err error, topic string, order string, s *Server) (vintedscraper.VintedApi_Response, error) {
-	result, err := vintedscraper.Search(topic, vintedscraper.ToOrder(order), "GBP")
-	if err != nil {
-		fmt.Println("Error searching:", err)
-		return vintedscraper.VintedApi_Response{}, nil
+// scrapePage scrapes one page of the search sig and stores its items under
+// the topic named after its text, recording the attempt as a scrape run.
+// The first page is also saved as the search's results, in Vinted's order.
+func (s *Server) scrapePage(sig database.SearchSignature, page int) (vintedscraper.VintedApi_Response, database.IngestResult, error) {
+	order := vintedscraper.Order(sig.Order)
+	run := database.ScrapeRun{
+		Topic:       sig.Text,
+		QueryParams: vintedscraper.SearchParams(sig.Text, order, sig.Currency, page).Encode(),
+		Domain:      sig.Domain,
+		StartedAt:   time.Now(),
 	}
-	err = s.db.AddItems(result.Items, topic)
+	defer func() {
+		run.FinishedAt = time.Now()
+		if _, err := s.db.RecordScrapeRun(context.Background(), run); err != nil {
+			log.Printf("Error recording scrape run for %q: %v", sig.Text, err)
+		}
+	}()
+
+	s.scrapes.take()
+	result, err := s.search(sig.Text, order, sig.Currency, page)
 	if err != nil {
-		fmt.Println("Adding items to database error:", err)
-		return vintedscraper.VintedApi_Response{}, nil
+		run.HTTPStatus = vintedscraper.StatusCode(err)
+		run.ErrorClass = vintedscraper.ErrorClass(err)
+		if run.ErrorClass == "" {
+			run.ErrorClass = "request"
+		}
+		run.ErrorMessage = err.Error()
+		return vintedscraper.VintedApi_Response{}, database.IngestResult{}, err
 	}
-	return result, err
-}
+	run.Pages = 1
+	run.HTTPStatus = http.StatusOK
 
-func getCachedItems(s *Server, w http.ResponseWriter, topicID int8) {
-	items, err := s.db.GetItems(topicID)
-	if err != nil {
-		fmt.Println("Error getting items from database:", err)
-		return
+	ingest, err := s.db.AddItems(result.Items, sig.Text, run.Domain)
+	if ingest.TopicID != 0 {
+		defer s.responses.InvalidateTopic(ingest.TopicID)
+		defer s.events.Notify(ingest.TopicID)
+	}
+	if err == nil && page == 1 {
+		ids := make([]int, 0, len(result.Items))
+		for _, item := range result.Items {
+			if database.ValidateItem(item) == nil {
+				ids = append(ids, item.ID)
+			}
+		}
+		search := database.Search{Signature: sig.String(), TopicID: ingest.TopicID, FetchedAt: time.Now()}
+		err = s.db.SaveSearch(context.Background(), search, ids)
 	}
-	response, err := json.Marshal(items)
 	if err != nil {
-		fmt.Println("Marshal error:", err)
-		return
+		run.ErrorClass = "database"
+		run.ErrorMessage = err.Error()
+		return vintedscraper.VintedApi_Response{}, ingest, err
 	}
-	_, _ = w.Write(response)
+	run.ItemsNew = ingest.New
+	run.ItemsUpdated = ingest.Updated
+	return result, ingest, nil
 }
 
 func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
@@ -83,13 +199,28 @@ func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, fmt.Errorf("error handling JSON marshal: %v", err))
+		return
 	}
 
 	_, _ = w.Write(jsonResp)
 }
 
+// cacheHandler serves GET /v1/cache, the response cache counters.
+func (s *Server) cacheHandler(w http.ResponseWriter, r *http.Request) error {
+	return writeData(w, s.responses.Stats(), responseMeta{})
+}
+
+// healthHandler reports the database health, with 503 when it is down.
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	jsonResp, _ := json.Marshal(s.db.Health())
+	health := s.db.Health()
+	if health["status"] == "up" {
+		s.leaderHealth(r.Context(), health)
+	}
+	jsonResp, _ := json.Marshal(health)
+	w.Header().Set("Content-Type", "application/json")
+	if health["status"] != "up" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	_, _ = w.Write(jsonResp)
 }
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"vinted-scraper/internal/database"
+)
+
+// runsHandler serves GET /v1/runs and GET /v1/topics/{id}/runs: the scrape
+// audit log, newest first, paged with limit and cursor (the id of the last
+// run seen; before is accepted as an alias).
+func (s *Server) runsHandler(w http.ResponseWriter, r *http.Request) error {
+	q := database.RunQuery{WorkspaceID: s.workspace(r.Context())}
+	if chi.URLParam(r, "id") != "" {
+		topic, err := s.topicFromURL(r)
+		if err != nil {
+			return err
+		}
+		q.TopicID = topic.ID
+	}
+	values := r.URL.Query()
+	var err error
+	cursor := values.Get("cursor")
+	if cursor == "" {
+		cursor = values.Get("before")
+	}
+	if cursor != "" {
+		if q.Before, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			return badRequest("invalid cursor %q", cursor)
+		}
+	}
+	q.Limit = database.DefaultItemLimit
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return badRequest("invalid limit %q", v)
+		}
+		if q.Limit > database.MaxItemLimit {
+			q.Limit = database.MaxItemLimit
+		}
+	}
+
+	runs, err := s.db.ListScrapeRuns(r.Context(), q)
+	if err != nil {
+		return err
+	}
+	page := &pagination{Limit: q.Limit}
+	if len(runs) == q.Limit {
+		page.NextCursor = strconv.FormatInt(runs[len(runs)-1].ID, 10)
+	}
+	return writeData(w, runs, responseMeta{Pagination: page})
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// alertRuleRequest is an alert rule in a saved search request.
+type alertRuleRequest struct {
+	Name       string            `json:"name"`
+	Conditions map[string]string `json:"conditions"`
+}
+
+// savedSearchRequest is the body of POST /v1/saved-searches.
+type savedSearchRequest struct {
+	Name     string             `json:"name"`
+	Q        string             `json:"q"`
+	Order    string             `json:"order"`
+	Domain   string             `json:"domain"`
+	Currency string             `json:"currency"`
+	Filters  map[string]string  `json:"filters"`
+	Rules    []alertRuleRequest `json:"rules"`
+}
+
+// parseAlertRule validates an alert rule of a request.
+func parseAlertRule(req alertRuleRequest) (database.AlertRule, error) {
+	if _, err := database.ParseFilters(req.Conditions); err != nil {
+		return database.AlertRule{}, badRequest("invalid rule %q: %v", req.Name, err)
+	}
+	return database.AlertRule{Name: req.Name, Conditions: req.Conditions}, nil
+}
+
+// createSavedSearchHandler serves POST /v1/saved-searches: a search with
+// its filters and alert rules. The scheduler scrapes the search on the
+// policy of its topic, and the rules are checked against the items every
+// later scrape of the search (or import into its topic) stores or reprices.
+func (s *Server) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	var req savedSearchRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if req.Q == "" {
+		return badRequest("missing q")
+	}
+	order, err := vintedscraper.ParseOrder(req.Order)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	// Vinted is only scraped on DefaultDomain, so a search on another
+	// domain would never be scraped nor raise an alert.
+	if req.Domain != "" && !strings.EqualFold(strings.TrimSpace(req.Domain), database.DefaultDomain) {
+		return badRequest("unsupported domain %q, only %s is scraped", req.Domain, database.DefaultDomain)
+	}
+	if req.Currency != "" && !validCurrency(req.Currency) {
+		return badRequest("invalid currency %q", req.Currency)
+	}
+	if _, err := database.ParseFilters(req.Filters); err != nil {
+		return badRequest("invalid filters: %v", err)
+	}
+	search := database.SavedSearch{
+		WorkspaceID: s.owner(r.Context()),
+		Name:        req.Name,
+		Text:        req.Q,
+		Order:       string(order),
+		Domain:      req.Domain,
+		Currency:    req.Currency,
+		Filters:     req.Filters,
+	}
+	if search.Name == "" {
+		search.Name = req.Q
+	}
+	for _, ruleReq := range req.Rules {
+		rule, err := parseAlertRule(ruleReq)
+		if err != nil {
+			return err
+		}
+		search.Rules = append(search.Rules, rule)
+	}
+
+	search, err = s.db.CreateSavedSearch(r.Context(), search)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/v1/saved-searches/"+strconv.FormatInt(search.ID, 10))
+	return writeDataStatus(w, http.StatusCreated, search, responseMeta{})
+}
+
+// savedSearchesHandler serves GET /v1/saved-searches.
+func (s *Server) savedSearchesHandler(w http.ResponseWriter, r *http.Request) error {
+	searches, err := s.db.ListSavedSearches(r.Context(), s.workspace(r.Context()))
+	if err != nil {
+		return err
+	}
+	return writeData(w, searches, responseMeta{})
+}
+
+// savedSearchHandler serves GET /v1/saved-searches/{id}.
+func (s *Server) savedSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := s.savedSearchFromURL(r)
+	if err != nil {
+		return err
+	}
+	return writeData(w, search, responseMeta{})
+}
+
+// deleteSavedSearchHandler serves DELETE /v1/saved-searches/{id}, which
+// also drops its rules and alerts.
+func (s *Server) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := s.savedSearchFromURL(r)
+	if err != nil {
+		return err
+	}
+	if err := s.db.DeleteSavedSearch(r.Context(), search.WorkspaceID, search.ID); err != nil && err != database.ErrNotFound {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// savedSearchItemsHandler serves GET /v1/saved-searches/{id}/items, the
+// stored items of its topic that pass its filters, sorted and paged like
+// GET /v1/items. Like GET /v1/items it only covers the workspace's topics,
+// so a saved search does not reveal a topic the workspace never searched.
+func (s *Server) savedSearchItemsHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := s.savedSearchFromURL(r)
+	if err != nil {
+		return err
+	}
+	values := r.URL.Query()
+	for key, value := range search.Filters {
+		values.Set(key, value)
+	}
+	q, err := database.ParseItemQuery(values)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	q.WorkspaceID, q.Topic, q.TopicID = s.workspace(r.Context()), search.Text, 0
+	if q.Sort == database.SortRelevance {
+		q.Signature = savedSearchSignature(search).String()
+	}
+	return s.writeItems(w, r, q, responseMeta{Source: sourceCache})
+}
+
+// createAlertRuleHandler serves POST /v1/saved-searches/{id}/rules.
+func (s *Server) createAlertRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := s.savedSearchFromURL(r)
+	if err != nil {
+		return err
+	}
+	var req alertRuleRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	rule, err := parseAlertRule(req)
+	if err != nil {
+		return err
+	}
+	rule.SavedSearchID = search.ID
+	if rule, err = s.db.AddAlertRule(r.Context(), rule); err != nil {
+		return err
+	}
+	return writeDataStatus(w, http.StatusCreated, rule, responseMeta{})
+}
+
+// deleteAlertRuleHandler serves DELETE /v1/saved-searches/{id}/rules/{rule},
+// which also drops the rule's alerts.
+func (s *Server) deleteAlertRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := s.savedSearchFromURL(r)
+	if err != nil {
+		return err
+	}
+	v := chi.URLParam(r, "rule")
+	ruleID, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return badRequest("invalid rule id %q", v)
+	}
+	err = s.db.DeleteAlertRule(r.Context(), search.ID, ruleID)
+	if err == database.ErrNotFound {
+		return notFound("rule %d of saved search %d not found", ruleID, search.ID)
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// alertsHandler serves GET /v1/alerts and GET /v1/saved-searches/{id}/alerts,
+// newest first, paged with limit and cursor (the id of the last alert seen).
+func (s *Server) alertsHandler(w http.ResponseWriter, r *http.Request) error {
+	q := database.AlertQuery{WorkspaceID: s.workspace(r.Context())}
+	if chi.URLParam(r, "id") != "" {
+		search, err := s.savedSearchFromURL(r)
+		if err != nil {
+			return err
+		}
+		q.SavedSearchID = search.ID
+	}
+	values := r.URL.Query()
+	var err error
+	if v := values.Get("cursor"); v != "" {
+		if q.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return badRequest("invalid cursor %q", v)
+		}
+	}
+	q.Limit = database.DefaultItemLimit
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return badRequest("invalid limit %q", v)
+		}
+		if q.Limit > database.MaxItemLimit {
+			q.Limit = database.MaxItemLimit
+		}
+	}
+
+	alerts, err := s.db.ListAlerts(r.Context(), q)
+	if err != nil {
+		return err
+	}
+	page := &pagination{Limit: q.Limit}
+	if len(alerts) == q.Limit {
+		page.NextCursor = strconv.FormatInt(alerts[len(alerts)-1].ID, 10)
+	}
+	return writeData(w, alerts, responseMeta{Pagination: page})
+}
+
+// savedSearchFromURL loads the saved search named by the {id} URL parameter.
+func (s *Server) savedSearchFromURL(r *http.Request) (database.SavedSearch, error) {
+	id := chi.URLParam(r, "id")
+	searchID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return database.SavedSearch{}, badRequest("invalid saved search id %q", id)
+	}
+	search, err := s.db.GetSavedSearch(r.Context(), s.workspace(r.Context()), searchID)
+	if err == database.ErrNotFound {
+		return search, notFound("saved search %d not found", searchID)
+	}
+	return search, err
+}
@@ -0,0 +1,558 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+const (
+	// readMarkInterval is how often a read of a topic is written to the
+	// database; reads in between only update memory.
+	readMarkInterval = time.Minute
+	// maxSchedulerSleep bounds how long the scheduler sleeps, so it notices
+	// new topics.
+	maxSchedulerSleep = time.Minute
+	// minSchedulerSleep keeps a busy scheduler from spinning.
+	minSchedulerSleep = 10 * time.Millisecond
+)
+
+// Statuses of a topic's last scheduled refresh.
+const (
+	// scheduleQueued is a refresh queued as a scrape job.
+	scheduleQueued = "queued"
+	scheduleError  = "error"
+	// scheduleIdle is a refresh skipped because nobody read the topic lately.
+	scheduleIdle = "idle"
+)
+
+// schedulePolicy is how often the scheduler refreshes a topic; topics with
+// a higher Priority go first when several are due. An Interval of 0 leaves
+// the topic alone.
+type schedulePolicy struct {
+	Interval time.Duration
+	Priority int
+}
+
+// schedulePolicies holds the scheduler settings and per-topic overrides.
+type schedulePolicies struct {
+	Default schedulePolicy
+	Topics  map[string]schedulePolicy
+	// Jitter spreads refreshes by up to this fraction of their interval
+	// either way, so topics scheduled together drift apart.
+	Jitter float64
+	// IdleAfter is how long after its last read a topic stops being refreshed.
+	IdleAfter time.Duration
+}
+
+// For returns the policy of topic.
+func (p schedulePolicies) For(topic string) schedulePolicy {
+	if policy, ok := p.Topics[topic]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Enabled reports whether any topic is refreshed.
+func (p schedulePolicies) Enabled() bool {
+	if p.Default.Interval > 0 {
+		return true
+	}
+	for _, policy := range p.Topics {
+		if policy.Interval > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// schedulePoliciesFromEnv reads SCHEDULE_INTERVAL (a Go duration, default
+// 30m, 0 disables), SCHEDULE_JITTER (a fraction, default 0.1),
+// SCHEDULE_IDLE_AFTER (default 24h) and SCHEDULE_TOPICS, per-topic
+// overrides as comma separated topic=interval[/priority] entries such as
+// "shoes=5m/10,bags=0".
+func schedulePoliciesFromEnv() (schedulePolicies, error) {
+	policies := schedulePolicies{
+		Default:   schedulePolicy{Interval: 30 * time.Minute},
+		Topics:    map[string]schedulePolicy{},
+		Jitter:    0.1,
+		IdleAfter: 24 * time.Hour,
+	}
+	for key, dest := range map[string]*time.Duration{
+		"SCHEDULE_INTERVAL":   &policies.Default.Interval,
+		"SCHEDULE_IDLE_AFTER": &policies.IdleAfter,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return policies, fmt.Errorf("invalid %s %q", key, v)
+		}
+		*dest = d
+	}
+	if v := os.Getenv("SCHEDULE_JITTER"); v != "" {
+		jitter, err := strconv.ParseFloat(v, 64)
+		if err != nil || jitter < 0 || jitter >= 1 {
+			return policies, fmt.Errorf("invalid SCHEDULE_JITTER %q, want a fraction below 1", v)
+		}
+		policies.Jitter = jitter
+	}
+
+	v := os.Getenv("SCHEDULE_TOPICS")
+	if v == "" {
+		return policies, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		topic, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		interval, priority, hasPriority := strings.Cut(spec, "/")
+		if !ok || topic == "" {
+			return policies, fmt.Errorf("invalid SCHEDULE_TOPICS entry %q, want topic=interval[/priority]", entry)
+		}
+		var policy schedulePolicy
+		var err error
+		if policy.Interval, err = time.ParseDuration(interval); err != nil || policy.Interval < 0 {
+			return policies, fmt.Errorf("invalid SCHEDULE_TOPICS entry %q: bad interval %q", entry, interval)
+		}
+		if hasPriority {
+			if policy.Priority, err = strconv.Atoi(priority); err != nil {
+				return policies, fmt.Errorf("invalid SCHEDULE_TOPICS entry %q: bad priority %q", entry, priority)
+			}
+		}
+		policies.Topics[topic] = policy
+	}
+	return policies, nil
+}
+
+// TopicSchedule is the scheduler's state for one topic, as served by
+// GET /v1/topics/{id}/schedule.
+type TopicSchedule struct {
+	TopicID         int64  `json:"topic_id"`
+	Topic           string `json:"topic"`
+	Enabled         bool   `json:"enabled"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+	interval        time.Duration
+	Priority        int        `json:"priority"`
+	NextRunAt       *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	// LastStatus is queued, error or idle (skipped as nobody read the topic
+	// lately); LastJobID is the scrape job of the last queued refresh.
+	LastStatus string     `json:"last_status,omitempty"`
+	LastJobID  int64      `json:"last_job_id,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Runs       int        `json:"runs"`
+	Failures   int        `json:"failures"`
+	LastReadAt *time.Time `json:"last_read_at,omitempty"`
+	Idle       bool       `json:"idle"`
+	// Scheduler is the instance running the scheduler, as GET /health
+	// names the leader.
+	Scheduler string `json:"scheduler,omitempty"`
+}
+
+// scheduler refreshes topics on their schedule policy and remembers when
+// each topic was last read.
+type scheduler struct {
+	policies schedulePolicies
+
+	mu sync.Mutex
+	// running is set while RunScheduler runs.
+	running bool
+	topics  map[int64]*TopicSchedule
+	// reads and marked are when each topic was last read, and when that
+	// was last written to the database.
+	reads  map[int64]time.Time
+	marked map[int64]time.Time
+	// searches is when each saved search is next due.
+	searches map[int64]time.Time
+}
+
+func newScheduler(policies schedulePolicies) *scheduler {
+	return &scheduler{
+		policies: policies,
+		topics:   map[int64]*TopicSchedule{},
+		reads:    map[int64]time.Time{},
+		marked:   map[int64]time.Time{},
+		searches: map[int64]time.Time{},
+	}
+}
+
+// jittered returns interval spread by the jitter of the policies.
+func (sc *scheduler) jittered(interval time.Duration) time.Duration {
+	if sc.policies.Jitter == 0 {
+		return interval
+	}
+	spread := (rand.Float64()*2 - 1) * sc.policies.Jitter
+	return interval + time.Duration(float64(interval)*spread)
+}
+
+// markRead records that the API served the topic, writing it to the
+// database at most once per readMarkInterval.
+func (s *Server) markRead(ctx context.Context, topicID int64) {
+	if topicID == 0 {
+		return
+	}
+	now := time.Now()
+	sc := s.schedule
+	sc.mu.Lock()
+	sc.reads[topicID] = now
+	write := now.Sub(sc.marked[topicID]) >= readMarkInterval
+	if write {
+		sc.marked[topicID] = now
+	}
+	sc.mu.Unlock()
+	if write {
+		if err := s.db.MarkTopicRead(ctx, topicID, now); err != nil {
+			log.Printf("Error marking topic %d read: %v", topicID, err)
+		}
+	}
+}
+
+// lastRead returns when topic was last read, here or by another process.
+func (sc *scheduler) lastRead(topic database.Topic) *time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	read, ok := sc.reads[topic.ID]
+	if topic.LastReadAt != nil && (!ok || topic.LastReadAt.After(read)) {
+		read, ok = *topic.LastReadAt, true
+	}
+	if !ok {
+		return nil
+	}
+	return &read
+}
+
+// state returns the schedule of topic, creating it on first sight: a topic
+// is due one interval after its last scrape, whoever ran it, so scrapes by
+// clients and other processes postpone scheduled ones.
+func (sc *scheduler) state(topic database.Topic) *TopicSchedule {
+	policy := sc.policies.For(topic.Name)
+	lastRead := sc.lastRead(topic)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st, ok := sc.topics[topic.ID]
+	if !ok {
+		st = &TopicSchedule{TopicID: topic.ID}
+		sc.topics[topic.ID] = st
+	}
+	st.Topic = topic.Name
+	st.Enabled = policy.Interval > 0
+	st.interval, st.IntervalSeconds = policy.Interval, seconds(policy.Interval)
+	st.Priority = policy.Priority
+	st.LastReadAt = lastRead
+	st.Idle = lastRead == nil || time.Since(*lastRead) > sc.policies.IdleAfter
+	switch {
+	case !st.Enabled:
+		st.NextRunAt = nil
+	case st.NextRunAt == nil:
+		next := time.Now()
+		if topic.LastScrapedAt != nil {
+			next = topic.LastScrapedAt.Add(sc.jittered(policy.Interval))
+		}
+		st.NextRunAt = &next
+	case topic.LastScrapedAt != nil:
+		if next := topic.LastScrapedAt.Add(policy.Interval); next.After(*st.NextRunAt) {
+			st.NextRunAt = &next
+		}
+	}
+	return st
+}
+
+// snapshot returns a copy of the schedule of topic, disabled when the
+// scheduler is not running here, and whether it is.
+func (sc *scheduler) snapshot(topic database.Topic) (TopicSchedule, bool) {
+	st := sc.state(topic)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	snapshot := *st
+	if !sc.running {
+		snapshot.Enabled, snapshot.NextRunAt = false, nil
+	}
+	return snapshot, sc.running
+}
+
+// finish records the outcome of a scheduled refresh, or of skipping an
+// idle topic, and schedules the next one.
+func (sc *scheduler) finish(st *TopicSchedule, status string, jobID int64, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	now := time.Now()
+	st.LastStatus, st.LastError = status, ""
+	if jobID != 0 {
+		st.LastJobID = jobID
+	}
+	if status != scheduleIdle {
+		st.LastRunAt = &now
+		st.Runs++
+	}
+	if err != nil {
+		st.LastError = err.Error()
+		st.Failures++
+	}
+	next := now.Add(sc.jittered(st.interval))
+	st.NextRunAt = &next
+}
+
+// RunScheduler queues scrape jobs refreshing topics and saved searches on
+// their schedule until ctx is done, for the job workers of every process to
+// share (see RunJobs). Each job carries its topic's priority, and a topic is
+// not queued again while its last job is pending; topics nobody read within
+// IdleAfter are skipped until someone does. Saved searches are never idle:
+// their alert rules read every scrape.
+func (s *Server) RunScheduler(ctx context.Context) {
+	s.schedule.setRunning(true)
+	defer s.schedule.setRunning(false)
+	for {
+		sleep := s.runSchedule(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sleep):
+		}
+	}
+}
+
+func (sc *scheduler) setRunning(running bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.running = running
+}
+
+// runSchedule queues the topics that are due and returns how long to
+// sleep until the next one is.
+func (s *Server) runSchedule(ctx context.Context) time.Duration {
+	topics, err := s.db.ListTopics(ctx, database.AllWorkspaces)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Scheduler: error listing topics: %v", err)
+		}
+		return maxSchedulerSleep
+	}
+	sc := s.schedule
+	now := time.Now()
+	type dueTopic struct {
+		topic    database.Topic
+		st       *TopicSchedule
+		priority int
+		next     time.Time
+		idle     bool
+	}
+	var due []dueTopic
+	for _, topic := range topics {
+		st := sc.state(topic)
+		sc.mu.Lock()
+		if st.Enabled && !st.NextRunAt.After(now) {
+			due = append(due, dueTopic{topic, st, st.Priority, *st.NextRunAt, st.Idle})
+		}
+		sc.mu.Unlock()
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if due[i].priority != due[j].priority {
+			return due[i].priority > due[j].priority
+		}
+		return due[i].next.Before(due[j].next)
+	})
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return 0
+		}
+		if d.idle {
+			sc.finish(d.st, scheduleIdle, 0, nil)
+		} else if job, err := s.refreshTopic(ctx, d.topic, d.priority); err != nil {
+			log.Printf("Scheduler: error queueing topic %q: %v", d.topic.Name, err)
+			sc.finish(d.st, scheduleError, 0, err)
+		} else {
+			sc.finish(d.st, scheduleQueued, job.ID, nil)
+		}
+		s.saveSchedule(ctx, d.st)
+	}
+
+	sleep := s.runSavedSearches(ctx)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, st := range sc.topics {
+		if st.NextRunAt != nil {
+			sleep = min(sleep, time.Until(*st.NextRunAt))
+		}
+	}
+	return max(sleep, minSchedulerSleep)
+}
+
+// refreshTopic queues a scrape job for the newest items of topic, or
+// returns the job already pending for it.
+func (s *Server) refreshTopic(ctx context.Context, topic database.Topic, priority int) (database.ScrapeJob, error) {
+	sig := database.NewSearchSignature(topic.Name, string(vintedscraper.NEWEST_FIRST), "", "", nil)
+	job, err := s.db.CreateScrapeJob(ctx, database.ScrapeJob{
+		Kind:        database.JobKindSchedule,
+		Query:       sig.Text,
+		Order:       sig.Order,
+		Pages:       1,
+		Priority:    priority,
+		DedupeKey:   "schedule:" + sig.Text,
+		MaxAttempts: s.jobs.maxAttempts,
+	})
+	if err == nil {
+		s.jobs.notify()
+	}
+	return job, err
+}
+
+// saveSchedule stores the schedule of a topic for the replicas that do not
+// run the scheduler to report.
+func (s *Server) saveSchedule(ctx context.Context, st *TopicSchedule) {
+	s.schedule.mu.Lock()
+	state := database.TopicScheduleState{
+		TopicID:    st.TopicID,
+		Scheduler:  s.instance,
+		NextRunAt:  st.NextRunAt,
+		LastRunAt:  st.LastRunAt,
+		LastStatus: st.LastStatus,
+		LastJobID:  st.LastJobID,
+		LastError:  st.LastError,
+		Runs:       st.Runs,
+		Failures:   st.Failures,
+		UpdatedAt:  time.Now(),
+	}
+	s.schedule.mu.Unlock()
+	if err := s.db.SaveTopicSchedule(ctx, state); err != nil && ctx.Err() == nil {
+		log.Printf("Scheduler: %v", err)
+	}
+}
+
+// runSavedSearches queues the saved searches that are due, on the policy of
+// the topic named after their text, and returns how long to sleep until the
+// next one is. Like a topic, a saved search is first due one interval after
+// its last scrape.
+func (s *Server) runSavedSearches(ctx context.Context) time.Duration {
+	searches, err := s.db.ListSavedSearches(ctx, database.AllWorkspaces)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Scheduler: error listing saved searches: %v", err)
+		}
+		return maxSchedulerSleep
+	}
+	sc := s.schedule
+	sleep := maxSchedulerSleep
+	listed := map[int64]bool{}
+	for _, search := range searches {
+		listed[search.ID] = true
+		policy := sc.policies.For(search.Text)
+		if policy.Interval == 0 || ctx.Err() != nil {
+			continue
+		}
+		sc.mu.Lock()
+		next, ok := sc.searches[search.ID]
+		sc.mu.Unlock()
+		if !ok {
+			next = time.Now()
+			if last, err := s.db.GetSearch(ctx, savedSearchSignature(search).String()); err == nil {
+				next = last.FetchedAt.Add(sc.jittered(policy.Interval))
+			}
+		}
+		if next.After(time.Now()) {
+			sleep = min(sleep, time.Until(next))
+		} else {
+			if _, err := s.refreshSavedSearch(ctx, search, policy.Priority); err != nil {
+				log.Printf("Scheduler: error queueing saved search %d: %v", search.ID, err)
+			}
+			next = time.Now().Add(sc.jittered(policy.Interval))
+			sleep = min(sleep, policy.Interval)
+		}
+		sc.mu.Lock()
+		sc.searches[search.ID] = next
+		sc.mu.Unlock()
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for id := range sc.searches {
+		if !listed[id] {
+			delete(sc.searches, id)
+		}
+	}
+	return sleep
+}
+
+// savedSearchSignature is the search a saved search scrapes, without its
+// filters, see SearchSignature.Unfiltered.
+func savedSearchSignature(search database.SavedSearch) database.SearchSignature {
+	return database.NewSearchSignature(search.Text, search.Order, search.Domain, search.Currency, nil)
+}
+
+// refreshSavedSearch queues a scrape job for the first page of search in its
+// own order and currency, or returns the job already pending for it. The
+// job is the saved search's workspace's, so the workspace sees the topic.
+func (s *Server) refreshSavedSearch(ctx context.Context, search database.SavedSearch, priority int) (database.ScrapeJob, error) {
+	sig := savedSearchSignature(search)
+	job, err := s.db.CreateScrapeJob(ctx, database.ScrapeJob{
+		Kind:        database.JobKindSchedule,
+		Query:       sig.Text,
+		Order:       sig.Order,
+		Currency:    sig.Currency,
+		Pages:       1,
+		Priority:    priority,
+		DedupeKey:   "schedule:" + sig.String(),
+		Signature:   sig.String(),
+		WorkspaceID: search.WorkspaceID,
+		MaxAttempts: s.jobs.maxAttempts,
+	})
+	if err == nil {
+		s.jobs.notify()
+	}
+	return job, err
+}
+
+// topicSchedule returns the scheduler's state for topic. The scheduler only
+// runs on the leader, so the other replicas report the state it saved last,
+// enabled while the replica that saved it still leads.
+func (s *Server) topicSchedule(ctx context.Context, topic database.Topic) (TopicSchedule, error) {
+	st, running := s.schedule.snapshot(topic)
+	if running {
+		st.Scheduler = s.instance
+		return st, nil
+	}
+	saved, err := s.db.GetTopicSchedule(ctx, topic.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	leader, err := s.db.Leader(ctx, leaderLock)
+	if err != nil {
+		return st, err
+	}
+	st.Scheduler, st.LastRunAt, st.LastStatus = saved.Scheduler, saved.LastRunAt, saved.LastStatus
+	st.LastJobID, st.LastError, st.Runs, st.Failures = saved.LastJobID, saved.LastError, saved.Runs, saved.Failures
+	if st.interval > 0 && saved.Scheduler == leader {
+		st.Enabled, st.NextRunAt = true, saved.NextRunAt
+	}
+	return st, nil
+}
+
+// scheduleHandler serves GET /v1/topics/{id}/schedule, the scheduler's
+// state for a topic, from whichever replica.
+func (s *Server) scheduleHandler(w http.ResponseWriter, r *http.Request) error {
+	topic, err := s.topicFromURL(r)
+	if err != nil {
+		return err
+	}
+	// Serving the schedule does not count as reading the topic.
+	st, err := s.topicSchedule(r.Context(), topic)
+	if err != nil {
+		return err
+	}
+	return writeData(w, st, responseMeta{})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// localSearchHandler serves GET /v1/search/local?q=: a ranked full-text
+// search of the items already stored in the workspace's topics, without
+// calling Vinted. Optional parameters are domain, limit and offset.
+func (s *Server) localSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	q := database.SearchQuery{
+		WorkspaceID: s.workspace(r.Context()),
+		Text:        values.Get("q"),
+		Domain:      values.Get("domain"),
+	}
+	var err error
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return badRequest("invalid limit %q", v)
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return badRequest("invalid offset %q", v)
+		}
+	}
+
+	results, err := s.db.SearchItems(r.Context(), q)
+	if errors.Is(err, database.ErrEmptySearch) {
+		return badRequest("missing search query q")
+	}
+	if err != nil {
+		return err
+	}
+	limit := q.Limit
+	if limit == 0 {
+		limit = database.DefaultItemLimit
+	}
+	return writeData(w, results, responseMeta{Source: sourceCache, Pagination: &pagination{Limit: limit, Offset: q.Offset}})
+}
+
+// searchHandler serves GET /v1/search?q=&order=&currency=: the items Vinted
+// returns for q, in the order it returned them unless sort is given, and
+// narrowed by the filters of GET /v1/items. Vinted never sees the filters,
+// so results are stored per unfiltered search signature, served according
+// to the cache policy of q (see lookupSearch) and filtered when queried;
+// refresh=true waits for a fresh scrape.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	text := strings.TrimSpace(values.Get("q"))
+	if text == "" {
+		return badRequest("missing search query q")
+	}
+	order, err := vintedscraper.ParseOrder(values.Get("order"))
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	q, err := database.ParseItemQuery(values)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	if values.Get("sort") == "" {
+		q.Sort, q.Desc = database.SortRelevance, false
+	}
+	currency := strings.ToUpper(values.Get("currency"))
+	if currency != "" && !validCurrency(currency) {
+		return badRequest("invalid currency %q", currency)
+	}
+	refresh, err := parseRefresh(r)
+	if err != nil {
+		return err
+	}
+
+	sig := database.NewSearchSignature(text, string(order), "", currency, nil)
+	lookup, err := s.lookupSearch(r.Context(), sig, refresh)
+	if err != nil {
+		return err
+	}
+	setCacheHeaders(w, lookup)
+	s.markRead(r.Context(), lookup.TopicID)
+	s.seeTopic(r.Context(), lookup.TopicID)
+	meta := responseMeta{Source: sourceCache, FetchedAt: lookup.ScrapedAt}
+	if lookup.Scraped {
+		meta.Source = sourceLive
+	}
+	q.WorkspaceID, q.Topic, q.TopicID = s.workspace(r.Context()), "", 0
+	q.Signature = lookup.Signature
+	if lookup.Scraped {
+		return s.writeItems(w, r, q, meta)
+	}
+	key := fmt.Sprintf("search?%s#%s,%s,%t,%d,%s", lookup.Signature, q.FilterValues().Encode(), q.Sort, q.Desc, q.Limit, q.Cursor)
+	return s.writeCached(w, r, key, lookup.TopicID, lookup.ScrapedAt, func() ([]byte, error) {
+		return s.renderItems(r.Context(), q, meta)
+	})
+}
+
+// validCurrency reports whether code looks like an ISO 4217 currency code.
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
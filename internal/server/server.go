@@ -1,7 +1,11 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -10,21 +14,123 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
 )
 
 type Server struct {
 	port int
+	// instance names this process in job leases and the leader election.
+	instance string
 
-	db database.Service
+	db     database.Service
+	search SearchFunc
+	cache  *topicCache
+	// responses caches serialized search responses, see writeCached.
+	responses *responseCache
+	// events wakes topic streams after ingestion, see streamHandler.
+	events    *eventBroker
+	heartbeat time.Duration
+	// webhooks delivers events to webhooks, see RunWebhooks.
+	webhooks *webhookDispatcher
+	// scrapes limits how often Vinted is called, see scrapeLimit.
+	scrapes *tokenBucket
+	// schedule refreshes topics in the background, see RunScheduler.
+	schedule *scheduler
+	// jobs runs the scrape jobs queued through the API, see RunJobs.
+	jobs *jobRunner
+	// election runs the leader-only tasks, see RunLeaderElection.
+	election *election
+	// auth requires API keys, see WithAuth; nil leaves the API open.
+	auth *authenticator
 }
 
-func NewServer() *http.Server {
+// SearchFunc fetches a page of Vinted search results, like vintedscraper.Search.
+type SearchFunc func(query string, order vintedscraper.Order, currency string, page int) (vintedscraper.VintedApi_Response, error)
+
+// Option configures a Server built by New.
+type Option func(*Server)
+
+// WithSearch makes the Server scrape Vinted with search instead of
+// vintedscraper.Search.
+func WithSearch(search SearchFunc) Option {
+	return func(s *Server) {
+		s.search = search
+	}
+}
+
+// New returns a Server backed by db, without starting background jobs.
+// Cache and schedule policies are read from the environment (see
+// cachePoliciesFromEnv and schedulePoliciesFromEnv).
+func New(db database.Service, opts ...Option) *Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
-		port: port,
+	policies, err := cachePoliciesFromEnv()
+	if err != nil {
+		log.Printf("%v, using the default cache policy", err)
+		policies = cachePolicies{Default: defaultCachePolicy}
+	}
+	schedule, err := schedulePoliciesFromEnv()
+	if err != nil {
+		log.Printf("%v, scheduler disabled", err)
+		schedule = schedulePolicies{}
+	}
+	s := &Server{
+		port:     port,
+		instance: instanceID(),
 
-		db: database.New(),
+		db:     db,
+		search: vintedscraper.Search,
+		cache:  newTopicCache(policies),
+
+		responses: newResponseCache(responseCacheBytes(), responseCacheTTL()),
+		events:    newEventBroker(),
+		heartbeat: streamHeartbeat(),
+		webhooks:  newWebhookDispatcher(),
+		scrapes:   scrapeLimit(),
+		schedule:  newScheduler(schedule),
+		jobs:      newJobRunner(),
+		election:  newElection(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// instanceID returns a name for this process that is unique across
+// replicas: its host, pid and a random suffix.
+func instanceID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// NewServer returns the HTTP server of the API with its background jobs
+// running. It requires API keys unless API_AUTH is "off".
+func NewServer() *http.Server {
+	var opts []Option
+	if os.Getenv("API_AUTH") != "off" {
+		opts = append(opts, WithAuth())
+	}
+	NewServer := New(database.New(), opts...)
+
+	// Every replica runs job workers; the tasks that must run once per
+	// database run on the elected leader.
+	if interval := retentionInterval(); interval > 0 {
+		policy, err := database.RetentionPolicyFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		NewServer.RegisterLeaderTask("retention", func(ctx context.Context) {
+			NewServer.runRetention(ctx, policy, interval)
+		})
+	}
+	NewServer.RegisterLeaderTask("webhooks", NewServer.RunWebhooks)
+	if NewServer.schedule.policies.Enabled() {
+		NewServer.RegisterLeaderTask("scheduler", NewServer.RunScheduler)
 	}
+	go NewServer.RunLeaderElection(context.Background())
+	go NewServer.RunJobs(context.Background())
 
 	// Declare Server config
 	server := &http.Server{
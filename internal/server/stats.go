@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"vinted-scraper/internal/database"
+)
+
+// statsResponse is a MarketStats tagged with the scope, currency and window
+// it covers.
+type statsResponse struct {
+	TopicID  int64  `json:"topic_id,omitempty"`
+	Brand    string `json:"brand,omitempty"`
+	Currency string `json:"currency"`
+	Window   string `json:"window"`
+	database.MarketStats
+}
+
+// topicStatsHandler serves GET /v1/topics/{id}/stats?window=&currency=.
+func (s *Server) topicStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	topic, err := s.topicFromURL(r)
+	if err != nil {
+		return err
+	}
+	return s.writeStats(w, r, database.StatsQuery{TopicID: topic.ID})
+}
+
+// brandStatsHandler serves GET /v1/brands/{name}/stats?window=&currency=,
+// over the topics the workspace sees.
+func (s *Server) brandStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.writeStats(w, r, database.StatsQuery{Brand: chi.URLParam(r, "name")})
+}
+
+func (s *Server) writeStats(w http.ResponseWriter, r *http.Request, q database.StatsQuery) error {
+	label := r.URL.Query().Get("window")
+	if label == "" {
+		label = "30d"
+	}
+	window, err := parseWindow(label)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	q.Window = window
+	q.WorkspaceID = s.workspace(r.Context())
+	// Only the items priced in one currency are aggregated.
+	q.Currency = strings.ToUpper(r.URL.Query().Get("currency"))
+	if q.Currency == "" {
+		q.Currency = database.DefaultCurrency
+	}
+	if !validCurrency(q.Currency) {
+		return badRequest("invalid currency %q", q.Currency)
+	}
+
+	stats, err := s.db.MarketStats(r.Context(), q)
+	if err != nil {
+		return err
+	}
+	return writeData(w, statsResponse{TopicID: q.TopicID, Brand: q.Brand, Currency: q.Currency, Window: label, MarketStats: stats}, responseMeta{Source: sourceCache})
+}
+
+// parseWindow parses "all", a number of days such as "7d", or a Go duration such as "12h".
+func parseWindow(value string) (time.Duration, error) {
+	if value == "all" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid window %q: use all, <days>d or a duration like 12h", value)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"vinted-scraper/internal/database"
+)
+
+const (
+	// streamBatch is how many events a stream reads from the database at a time.
+	streamBatch = 100
+	// streamWriteTimeout is how long a stream waits for a client to accept
+	// an event before dropping it as too slow.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// streamHeartbeat reads STREAM_HEARTBEAT (a Go duration, default 15s), how
+// often idle streams send a comment to keep proxies from closing them.
+func streamHeartbeat() time.Duration {
+	v := os.Getenv("STREAM_HEARTBEAT")
+	if v == "" {
+		return 15 * time.Second
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid STREAM_HEARTBEAT %q, using 15s: %v", v, err)
+		return 15 * time.Second
+	}
+	return interval
+}
+
+// eventBroker wakes the streams of a topic when ingestion records events
+// for it. Wake-ups carry no data and coalesce: a stream reads what it
+// missed from the database, so a slow stream never holds up ingestion.
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan struct{}]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: map[int64]map[chan struct{}]struct{}{}}
+}
+
+// Subscribe returns a channel that receives after events of topicID, or of
+// any topic for 0, are recorded, and the function to call once done with it.
+func (b *eventBroker) Subscribe(topicID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[topicID] == nil {
+		b.subs[topicID] = map[chan struct{}]struct{}{}
+	}
+	b.subs[topicID][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[topicID], ch)
+		if len(b.subs[topicID]) == 0 {
+			delete(b.subs, topicID)
+		}
+	}
+}
+
+// Notify wakes the subscribers of topicID and of every topic.
+func (b *eventBroker) Notify(topicID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subs := range []map[chan struct{}]struct{}{b.subs[topicID], b.subs[0]} {
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// streamKinds are the events a topic stream sends.
+var streamKinds = []database.EventKind{database.EventNew, database.EventPriceDrop}
+
+// streamHandler serves GET /v1/topics/{id}/stream, Server-Sent Events of
+// the new items and price drops ingestion records for a topic. Each event
+// is named after its kind and carries a database.ItemEvent; its id resumes
+// the stream through the Last-Event-ID header (or last_event_id parameter).
+// Without one the stream starts with the next event.
+//
+// Events are read from the database in batches as fast as the client
+// accepts them; a client that takes longer than streamWriteTimeout to
+// accept an event is disconnected and can resume where it left off.
+func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) error {
+	topic, err := s.topicFromURL(r)
+	if err != nil {
+		return err
+	}
+	s.markRead(r.Context(), topic.ID)
+	lastID, err := lastEventID(r)
+	if err != nil {
+		return err
+	}
+	// Subscribe before reading the latest id so no event falls in between.
+	wake, unsubscribe := s.events.Subscribe(topic.ID)
+	defer unsubscribe()
+	if lastID < 0 {
+		if lastID, err = s.db.LatestEventID(r.Context(), topic.ID); err != nil {
+			return fmt.Errorf("error reading latest event: %v", err)
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	send := func(format string, args ...interface{}) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send("retry: %d\n\n", (5 * time.Second).Milliseconds()); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		// Read everything since lastID, batch by batch. This also picks up
+		// events written by other processes, such as imports.
+		for {
+			events, err := s.db.ListItemEvents(r.Context(), database.EventQuery{
+				WorkspaceID: s.workspace(r.Context()),
+				TopicID:     topic.ID,
+				AfterID:     lastID,
+				Kinds:       streamKinds,
+				Limit:       streamBatch,
+			})
+			if err != nil {
+				if r.Context().Err() == nil {
+					log.Printf("[%s] error reading events of topic %d: %v", middleware.GetReqID(r.Context()), topic.ID, err)
+				}
+				return nil
+			}
+			for _, event := range events {
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("[%s] error marshalling event %d: %v", middleware.GetReqID(r.Context()), event.ID, err)
+					return nil
+				}
+				if err := send("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Kind, data); err != nil {
+					return nil
+				}
+				lastID = event.ID
+			}
+			if len(events) < streamBatch {
+				break
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-wake:
+		case <-heartbeat.C:
+			if err := send(": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// lastEventID reads the event id a stream resumes after, or -1 when the
+// request does not resume one.
+func lastEventID(r *http.Request) (int64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, badRequest("invalid last event id %q", v)
+	}
+	return id, nil
+}
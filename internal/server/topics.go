@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"vinted-scraper/internal/database"
+)
+
+// topicsHandler serves GET /v1/topics, the topics the workspace searched.
+func (s *Server) topicsHandler(w http.ResponseWriter, r *http.Request) error {
+	topics, err := s.db.ListTopics(r.Context(), s.workspace(r.Context()))
+	if err != nil {
+		return err
+	}
+	return writeData(w, topics, responseMeta{Source: sourceCache})
+}
+
+// topicHandler serves GET /v1/topics/{id}.
+func (s *Server) topicHandler(w http.ResponseWriter, r *http.Request) error {
+	topic, err := s.topicFromURL(r)
+	if err != nil {
+		return err
+	}
+	s.markRead(r.Context(), topic.ID)
+	response, err := marshalData(topic, responseMeta{Source: sourceCache, FetchedAt: topic.LastScrapedAt})
+	if err != nil {
+		return err
+	}
+	writeConditional(w, r, response, topic.UpdatedAt)
+	return nil
+}
+
+// topicFromURL loads the topic named by the {id} URL parameter, if the
+// workspace sees it.
+func (s *Server) topicFromURL(r *http.Request) (database.Topic, error) {
+	id := chi.URLParam(r, "id")
+	topicID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return database.Topic{}, badRequest("invalid topic id %q", id)
+	}
+	topic, err := s.db.GetTopic(r.Context(), s.workspace(r.Context()), topicID)
+	if err == database.ErrNotFound {
+		return topic, notFound("topic %d not found", topicID)
+	}
+	return topic, err
+}
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"vinted-scraper/internal/database"
+)
+
+const (
+	// webhookTimeout bounds one delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// webhookTestType is the type of the payload sent by the test endpoint.
+	webhookTestType = "test"
+)
+
+// webhookBackoff reads WEBHOOK_BACKOFF (a Go duration, default 1s), the wait
+// before the first retry of a failed delivery; it doubles with each retry.
+func webhookBackoff() time.Duration {
+	v := os.Getenv("WEBHOOK_BACKOFF")
+	if v == "" {
+		return time.Second
+	}
+	backoff, err := time.ParseDuration(v)
+	if err != nil || backoff < 0 {
+		log.Printf("Invalid WEBHOOK_BACKOFF %q, using 1s: %v", v, err)
+		return time.Second
+	}
+	return backoff
+}
+
+// webhookMaxAttempts reads WEBHOOK_MAX_ATTEMPTS (default 5), how many times
+// an event is offered to a webhook before it is given up on.
+func webhookMaxAttempts() int {
+	v := os.Getenv("WEBHOOK_MAX_ATTEMPTS")
+	if v == "" {
+		return 5
+	}
+	attempts, err := strconv.Atoi(v)
+	if err != nil || attempts < 1 {
+		log.Printf("Invalid WEBHOOK_MAX_ATTEMPTS %q, using 5", v)
+		return 5
+	}
+	return attempts
+}
+
+// webhookNetworks reads WEBHOOK_ALLOWED_NETWORKS, comma separated networks
+// or addresses such as "10.1.0.0/16,127.0.0.1" that webhooks may be
+// delivered to although they are not public, see webhookAddressAllowed.
+func webhookNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_NETWORKS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid WEBHOOK_ALLOWED_NETWORKS entry %q: %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// webhookAddressAllowed reports whether webhooks may be delivered to ip:
+// loopback, private, link-local and unspecified addresses are refused
+// unless allowed lists them, so a webhook cannot reach into the network the
+// server runs in.
+func webhookAddressAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified()
+}
+
+// webhookTransport returns the transport of webhook deliveries. It checks
+// the address each connection is made to, after DNS resolution and on every
+// redirect, so a host name cannot be pointed at an internal address once
+// its webhook was created.
+func webhookTransport(allowed []*net.IPNet) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip, allowed) {
+				return fmt.Errorf("webhook address %s is not public, see WEBHOOK_ALLOWED_NETWORKS", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// webhookDispatcher delivers item events to webhooks, one worker per
+// webhook at a time so each receives its events in order.
+type webhookDispatcher struct {
+	client      *http.Client
+	backoff     time.Duration
+	maxAttempts int
+	// allowed are the networks webhooks may reach although not public.
+	allowed []*net.IPNet
+
+	mu   sync.Mutex
+	busy map[int64]bool
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	allowed := webhookNetworks()
+	return &webhookDispatcher{
+		client:      &http.Client{Timeout: webhookTimeout, Transport: webhookTransport(allowed)},
+		backoff:     webhookBackoff(),
+		maxAttempts: webhookMaxAttempts(),
+		allowed:     allowed,
+		busy:        map[int64]bool{},
+	}
+}
+
+// claim marks the webhook id as being delivered to, reporting false if it
+// already was.
+func (d *webhookDispatcher) claim(id int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.busy[id] {
+		return false
+	}
+	d.busy[id] = true
+	return true
+}
+
+func (d *webhookDispatcher) release(id int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.busy, id)
+}
+
+// webhookPayload is the JSON body POSTed to a webhook. Type is the kind of
+// the event, "alert" or "test".
+type webhookPayload struct {
+	Type      string              `json:"type"`
+	WebhookID int64               `json:"webhook_id"`
+	Event     *database.ItemEvent `json:"event,omitempty"`
+	Alert     *database.Alert     `json:"alert,omitempty"`
+	SentAt    time.Time           `json:"sent_at"`
+}
+
+// RunWebhooks delivers the events ingestion records to the webhooks
+// subscribed to them until ctx is done. It wakes after ingestion and at
+// every heartbeat, which also picks up events written by other processes.
+func (s *Server) RunWebhooks(ctx context.Context) {
+	wake, unsubscribe := s.events.Subscribe(0)
+	defer unsubscribe()
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		s.dispatchWebhooks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchWebhooks starts a worker for each webhook that has none.
+func (s *Server) dispatchWebhooks(ctx context.Context) {
+	hooks, err := s.db.ListWebhooks(ctx, database.AllWorkspaces)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error listing webhooks: %v", err)
+		}
+		return
+	}
+	for _, hook := range hooks {
+		if !s.webhooks.claim(hook.ID) {
+			continue
+		}
+		go func(hook database.Webhook) {
+			defer s.webhooks.release(hook.ID)
+			if err := s.deliverWebhook(ctx, hook); err != nil && ctx.Err() == nil {
+				log.Printf("Error delivering to webhook %d: %v", hook.ID, err)
+			}
+		}(hook)
+	}
+}
+
+// deliverWebhook sends hook the events and alerts it subscribed to.
+func (s *Server) deliverWebhook(ctx context.Context, hook database.Webhook) error {
+	var kinds []database.EventKind
+	alerts := false
+	for _, kind := range hook.EventTypes {
+		if kind == database.EventAlert {
+			alerts = true
+		} else {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) > 0 {
+		if err := s.deliverEvents(ctx, hook, kinds); err != nil {
+			return err
+		}
+	}
+	if alerts {
+		return s.deliverAlerts(ctx, hook)
+	}
+	return nil
+}
+
+// deliverEvents sends hook the events of kinds after its cursor, oldest
+// first, and moves the cursor past each once it is delivered or given up on.
+func (s *Server) deliverEvents(ctx context.Context, hook database.Webhook, kinds []database.EventKind) error {
+	upTo, err := s.db.LatestEventID(ctx, hook.TopicID)
+	if err != nil || upTo <= hook.LastEventID {
+		return err
+	}
+	for {
+		events, err := s.db.ListItemEvents(ctx, database.EventQuery{
+			WorkspaceID: s.hookWorkspace(hook),
+			TopicID:     hook.TopicID,
+			AfterID:     hook.LastEventID,
+			UpToID:      upTo,
+			Kinds:       kinds,
+			Limit:       streamBatch,
+		})
+		if err != nil {
+			return fmt.Errorf("error reading events: %v", err)
+		}
+		for _, event := range events {
+			payload := webhookPayload{Type: string(event.Kind), WebhookID: hook.ID, Event: &event, SentAt: time.Now().UTC()}
+			if err := s.deliverPayload(ctx, hook, payload); err != nil {
+				return err
+			}
+			if err := s.db.AdvanceWebhook(ctx, hook.ID, event.ID); err != nil {
+				return err
+			}
+			hook.LastEventID = event.ID
+		}
+		if len(events) < streamBatch {
+			break
+		}
+	}
+	// Skip past the events of kinds the webhook did not subscribe to.
+	return s.db.AdvanceWebhook(ctx, hook.ID, upTo)
+}
+
+// deliverAlerts sends hook the alerts after its alert cursor, oldest first,
+// as deliverEvents does with events.
+func (s *Server) deliverAlerts(ctx context.Context, hook database.Webhook) error {
+	for {
+		alerts, err := s.db.ListAlerts(ctx, database.AlertQuery{
+			WorkspaceID: s.hookWorkspace(hook),
+			TopicID:     hook.TopicID,
+			AfterID:     hook.LastAlertID,
+			Limit:       streamBatch,
+		})
+		if err != nil {
+			return fmt.Errorf("error reading alerts: %v", err)
+		}
+		for _, alert := range alerts {
+			payload := webhookPayload{Type: string(database.EventAlert), WebhookID: hook.ID, Alert: &alert, SentAt: time.Now().UTC()}
+			if err := s.deliverPayload(ctx, hook, payload); err != nil {
+				return err
+			}
+			if err := s.db.AdvanceWebhookAlerts(ctx, hook.ID, alert.ID); err != nil {
+				return err
+			}
+			hook.LastAlertID = alert.ID
+		}
+		if len(alerts) < streamBatch {
+			return nil
+		}
+	}
+}
+
+// hookWorkspace returns the workspace whose topics and alerts hook receives,
+// or database.AllWorkspaces when the Server does not require keys and so
+// has no workspaces to keep apart.
+func (s *Server) hookWorkspace(hook database.Webhook) int64 {
+	if s.auth == nil {
+		return database.AllWorkspaces
+	}
+	return hook.WorkspaceID
+}
+
+// deliverPayload offers payload to hook up to maxAttempts times, waiting
+// backoff and then twice as long after each failure. Giving up is logged
+// but not an error; failing to log an attempt is, since the webhook may
+// have been deleted.
+func (s *Server) deliverPayload(ctx context.Context, hook database.Webhook, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling payload: %v", err)
+	}
+	wait := s.webhooks.backoff
+	for attempt := 1; ; attempt++ {
+		delivery := s.sendWebhook(ctx, hook, payload, body, attempt)
+		if _, err := s.db.RecordWebhookDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		if delivery.Success {
+			return nil
+		}
+		if attempt >= s.webhooks.maxAttempts {
+			log.Printf("Giving up on %s %d for webhook %d after %d attempts: %s",
+				payload.Type, delivery.EventID, hook.ID, attempt, deliveryFailure(delivery))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// sendWebhook makes one attempt to POST body to hook, signed with its secret.
+func (s *Server) sendWebhook(ctx context.Context, hook database.Webhook, payload webhookPayload, body []byte, attempt int) (delivery database.WebhookDelivery) {
+	delivery = database.WebhookDelivery{
+		WebhookID: hook.ID,
+		EventType: payload.Type,
+		Attempt:   attempt,
+		CreatedAt: time.Now(),
+	}
+	switch {
+	case payload.Event != nil:
+		delivery.EventID = payload.Event.ID
+	case payload.Alert != nil:
+		delivery.EventID = payload.Alert.ID
+	}
+	defer func() {
+		delivery.DurationMS = time.Since(delivery.CreatedAt).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vinted-scraper-webhooks")
+	req.Header.Set("X-Webhook-Event", payload.Type)
+	req.Header.Set("X-Webhook-Signature", signWebhook(hook.Secret, time.Now(), body))
+	resp, err := s.webhooks.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return delivery
+}
+
+// signWebhook returns the X-Webhook-Signature of body sent at t:
+// "t=<unix seconds>,sha256=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+// Receivers recompute it with the secret and reject old timestamps.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryFailure describes why a delivery failed.
+func deliveryFailure(d database.WebhookDelivery) string {
+	if d.Error != "" {
+		return d.Error
+	}
+	return fmt.Sprintf("status %d", d.StatusCode)
+}
+
+// webhookRequest is the body of POST /v1/webhooks.
+type webhookRequest struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+	TopicID    int64    `json:"topic_id"`
+}
+
+// createWebhookHandler serves POST /v1/webhooks. The webhook receives the
+// events recorded from now on; its secret is generated unless given, and
+// is only returned here. A URL naming an address deliveries may not reach
+// is refused here already; host names are checked at delivery.
+func (s *Server) createWebhookHandler(w http.ResponseWriter, r *http.Request) error {
+	var req webhookRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return badRequest("invalid webhook url %q, want an absolute http or https URL", req.URL)
+	}
+	if ip := net.ParseIP(target.Hostname()); ip != nil && !webhookAddressAllowed(ip, s.webhooks.allowed) {
+		return badRequest("invalid webhook url %q: %s is not a public address", req.URL, ip)
+	}
+	if len(req.EventTypes) == 0 {
+		req.EventTypes = []string{string(database.EventNew)}
+	}
+	kinds, err := database.ParseEventKinds(req.EventTypes)
+	if err != nil {
+		return badRequest("invalid webhook: %v", err)
+	}
+	if req.TopicID != 0 {
+		if _, err := s.db.GetTopic(r.Context(), s.workspace(r.Context()), req.TopicID); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return badRequest("topic %d not found", req.TopicID)
+			}
+			return err
+		}
+	}
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("error generating webhook secret: %v", err)
+		}
+		req.Secret = hex.EncodeToString(secret)
+	}
+	latest, err := s.db.LatestEventID(r.Context(), 0)
+	if err != nil {
+		return fmt.Errorf("error reading latest event: %v", err)
+	}
+	latestAlert, err := s.db.LatestAlertID(r.Context())
+	if err != nil {
+		return fmt.Errorf("error reading latest alert: %v", err)
+	}
+
+	hook, err := s.db.CreateWebhook(r.Context(), database.Webhook{
+		WorkspaceID: s.owner(r.Context()),
+		URL:         target.String(),
+		Secret:      req.Secret,
+		EventTypes:  kinds,
+		TopicID:     req.TopicID,
+		LastEventID: latest,
+		LastAlertID: latestAlert,
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/webhooks/%d", hook.ID))
+	return writeDataStatus(w, http.StatusCreated, hook, responseMeta{})
+}
+
+// webhooksHandler serves GET /v1/webhooks, the webhooks of the workspace
+// without their secrets.
+func (s *Server) webhooksHandler(w http.ResponseWriter, r *http.Request) error {
+	hooks, err := s.db.ListWebhooks(r.Context(), s.workspace(r.Context()))
+	if err != nil {
+		return err
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return writeData(w, hooks, responseMeta{})
+}
+
+// webhookHandler serves GET /v1/webhooks/{id}, without its secret.
+func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) error {
+	hook, err := s.webhookFromURL(r)
+	if err != nil {
+		return err
+	}
+	hook.Secret = ""
+	return writeData(w, hook, responseMeta{})
+}
+
+// deleteWebhookHandler serves DELETE /v1/webhooks/{id}, which also drops
+// its delivery log.
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) error {
+	hook, err := s.webhookFromURL(r)
+	if err != nil {
+		return err
+	}
+	if err := s.db.DeleteWebhook(r.Context(), hook.WorkspaceID, hook.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// testWebhookHandler serves POST /v1/webhooks/{id}/test: it sends the
+// webhook a test payload once and returns the logged delivery, whether
+// the receiver accepted it or not.
+func (s *Server) testWebhookHandler(w http.ResponseWriter, r *http.Request) error {
+	hook, err := s.webhookFromURL(r)
+	if err != nil {
+		return err
+	}
+	payload := webhookPayload{Type: webhookTestType, WebhookID: hook.ID, SentAt: time.Now().UTC()}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling payload: %v", err)
+	}
+	delivery := s.sendWebhook(r.Context(), hook, payload, body, 1)
+	if delivery.ID, err = s.db.RecordWebhookDelivery(r.Context(), delivery); err != nil {
+		return err
+	}
+	return writeData(w, delivery, responseMeta{})
+}
+
+// webhookDeliveriesHandler serves GET /v1/webhooks/{id}/deliveries, the
+// latest delivery attempts, newest first, up to limit.
+func (s *Server) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) error {
+	hook, err := s.webhookFromURL(r)
+	if err != nil {
+		return err
+	}
+	limit := database.DefaultItemLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return badRequest("invalid limit %q", v)
+		}
+		if limit > database.MaxItemLimit {
+			limit = database.MaxItemLimit
+		}
+	}
+	deliveries, err := s.db.ListWebhookDeliveries(r.Context(), hook.ID, limit)
+	if err != nil {
+		return err
+	}
+	return writeData(w, deliveries, responseMeta{Pagination: &pagination{Limit: limit}})
+}
+
+// webhookFromURL loads the webhook named by the {id} URL parameter.
+func (s *Server) webhookFromURL(r *http.Request) (database.Webhook, error) {
+	id := chi.URLParam(r, "id")
+	hookID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return database.Webhook{}, badRequest("invalid webhook id %q", id)
+	}
+	hook, err := s.db.GetWebhook(r.Context(), s.workspace(r.Context()), hookID)
+	if err == database.ErrNotFound {
+		return hook, notFound("webhook %d not found", hookID)
+	}
+	return hook, err
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/websocket"
+
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+const (
+	// maxSubscriptions caps the subscriptions of one WebSocket connection.
+	maxSubscriptions = 100
+	// maxClientMessage caps the size of a message from a WebSocket client.
+	maxClientMessage = 4096
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// clientMessage is a message from a WebSocket client: a subscribe or
+// unsubscribe naming exactly one of a topic, a seller, a search or a saved
+// search.
+type clientMessage struct {
+	Type          string `json:"type"`
+	TopicID       int64  `json:"topic_id,omitempty"`
+	SellerID      int    `json:"seller_id,omitempty"`
+	SavedSearchID int64  `json:"saved_search_id,omitempty"`
+	Search        *struct {
+		Q        string `json:"q"`
+		Order    string `json:"order"`
+		Currency string `json:"currency"`
+	} `json:"search,omitempty"`
+
+	// err is set instead when the message could not be decoded.
+	err error
+}
+
+// serverMessage is a message to a WebSocket client. Events are sent as
+// new_item, price_change or removed messages listing the subscriptions
+// they match; subscribed, unsubscribed and error messages answer the client.
+type serverMessage struct {
+	Type          string              `json:"type"`
+	Subscription  string              `json:"subscription,omitempty"`
+	Subscriptions []string            `json:"subscriptions,omitempty"`
+	Event         *database.ItemEvent `json:"event,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// eventMessageTypes maps event kinds onto the message types they are sent as.
+var eventMessageTypes = map[database.EventKind]string{
+	database.EventNew:       "new_item",
+	database.EventPriceDrop: "price_change",
+	database.EventPriceRise: "price_change",
+	database.EventRemoved:   "removed",
+}
+
+// subscription selects the events a WebSocket client asked for. Key names
+// it in messages: "topic:<id>", "seller:<id>", "search:<signature>" or
+// "saved_search:<id>".
+type subscription struct {
+	Key   string
+	Query database.EventQuery
+}
+
+// parseSubscription validates the subscription named by m.
+func (s *Server) parseSubscription(r *http.Request, m clientMessage) (subscription, error) {
+	named := 0
+	for _, set := range []bool{m.TopicID != 0, m.SellerID != 0, m.SavedSearchID != 0, m.Search != nil} {
+		if set {
+			named++
+		}
+	}
+	if named != 1 {
+		return subscription{}, errors.New("name exactly one of topic_id, seller_id, saved_search_id or search")
+	}
+	workspaceID := s.workspace(r.Context())
+	switch {
+	case m.TopicID != 0:
+		if _, err := s.db.GetTopic(r.Context(), workspaceID, m.TopicID); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return subscription{}, fmt.Errorf("topic %d not found", m.TopicID)
+			}
+			return subscription{}, err
+		}
+		return subscription{Key: fmt.Sprintf("topic:%d", m.TopicID), Query: database.EventQuery{WorkspaceID: workspaceID, TopicID: m.TopicID}}, nil
+	case m.SellerID != 0:
+		return subscription{Key: fmt.Sprintf("seller:%d", m.SellerID), Query: database.EventQuery{WorkspaceID: workspaceID, SellerID: m.SellerID}}, nil
+	case m.SavedSearchID != 0:
+		// The saved search's signature carries its filters.
+		search, err := s.db.GetSavedSearch(r.Context(), workspaceID, m.SavedSearchID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return subscription{}, fmt.Errorf("saved search %d not found", m.SavedSearchID)
+			}
+			return subscription{}, err
+		}
+		return subscription{Key: fmt.Sprintf("saved_search:%d", search.ID), Query: database.EventQuery{WorkspaceID: workspaceID, Signature: search.Signature}}, nil
+	}
+	if m.Search.Q == "" {
+		return subscription{}, errors.New("missing search q")
+	}
+	order, err := vintedscraper.ParseOrder(m.Search.Order)
+	if err != nil {
+		return subscription{}, err
+	}
+	sig := database.NewSearchSignature(m.Search.Q, string(order), "", m.Search.Currency, nil).String()
+	return subscription{Key: "search:" + sig, Query: database.EventQuery{WorkspaceID: workspaceID, Signature: sig}}, nil
+}
+
+// websocketHandler serves GET /v1/ws, a WebSocket over which a client
+// subscribes to and unsubscribes from topics, sellers, searches and saved
+// searches, and receives the events ingestion records for them from then
+// on, or from after the last_event_id parameter. Events are read from the
+// database when ingestion wakes the connection and at every heartbeat,
+// which also pings the client; a client that does not answer pings or takes
+// longer than streamWriteTimeout to accept a message is disconnected.
+func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) error {
+	cursor, err := lastEventID(r)
+	if err != nil {
+		return err
+	}
+	wake, unsubscribe := s.events.Subscribe(0)
+	defer unsubscribe()
+	if cursor < 0 {
+		if cursor, err = s.db.LatestEventID(r.Context(), 0); err != nil {
+			return fmt.Errorf("error reading latest event: %v", err)
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		return nil
+	}
+	defer conn.Close()
+	reqID := middleware.GetReqID(r.Context())
+
+	// A client has two pings, plus the time it may take to accept a message,
+	// to answer before it is considered gone.
+	pongWait := 2*s.heartbeat + streamWriteTimeout
+	conn.SetReadLimit(maxClientMessage)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	messages := make(chan clientMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(messages)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+			var m clientMessage
+			if err := json.Unmarshal(data, &m); err != nil {
+				m = clientMessage{err: fmt.Errorf("invalid message: %v", err)}
+			}
+			select {
+			case messages <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	send := func(m serverMessage) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(m)
+	}
+	subs := map[string]subscription{}
+	deliver := func() error {
+		upTo, err := s.db.LatestEventID(r.Context(), 0)
+		if err != nil || upTo <= cursor {
+			return err
+		}
+		events, err := s.matchEvents(r, subs, cursor, upTo)
+		if err != nil {
+			return err
+		}
+		for _, match := range events {
+			event := match.event
+			err := send(serverMessage{Type: eventMessageTypes[event.Kind], Subscriptions: match.keys, Event: &event})
+			if err != nil {
+				return err
+			}
+		}
+		cursor = upTo
+		return nil
+	}
+
+	ping := time.NewTicker(s.heartbeat)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return nil
+		case m, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			err = s.handleClientMessage(r, m, subs, send)
+		case <-wake:
+			err = deliver()
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err == nil {
+				err = deliver()
+			}
+		}
+		if err != nil {
+			if r.Context().Err() == nil && !isClosed(err) {
+				log.Printf("[%s] closing WebSocket: %v", reqID, err)
+			}
+			return nil
+		}
+	}
+}
+
+// handleClientMessage applies a subscribe or unsubscribe message to subs
+// and answers it.
+func (s *Server) handleClientMessage(r *http.Request, m clientMessage, subs map[string]subscription, send func(serverMessage) error) error {
+	if m.err != nil {
+		return send(serverMessage{Type: "error", Error: m.err.Error()})
+	}
+	if m.Type != "subscribe" && m.Type != "unsubscribe" {
+		return send(serverMessage{Type: "error", Error: fmt.Sprintf("unknown message type %q, want subscribe or unsubscribe", m.Type)})
+	}
+	sub, err := s.parseSubscription(r, m)
+	if err != nil {
+		return send(serverMessage{Type: "error", Error: err.Error()})
+	}
+	if m.Type == "unsubscribe" {
+		delete(subs, sub.Key)
+		return send(serverMessage{Type: "unsubscribed", Subscription: sub.Key})
+	}
+	if _, ok := subs[sub.Key]; !ok && len(subs) >= maxSubscriptions {
+		return send(serverMessage{Type: "error", Error: fmt.Sprintf("at most %d subscriptions per connection", maxSubscriptions)})
+	}
+	subs[sub.Key] = sub
+	return send(serverMessage{Type: "subscribed", Subscription: sub.Key})
+}
+
+// eventMatch is an event and the keys of the subscriptions it matches.
+type eventMatch struct {
+	event database.ItemEvent
+	keys  []string
+}
+
+// matchEvents reads the events in (after, upTo] matching any of subs, oldest first.
+func (s *Server) matchEvents(r *http.Request, subs map[string]subscription, after, upTo int64) ([]eventMatch, error) {
+	matches := map[int64]*eventMatch{}
+	for key, sub := range subs {
+		q := sub.Query
+		q.AfterID, q.UpToID, q.Limit = after, upTo, database.MaxItemLimit
+		for {
+			events, err := s.db.ListItemEvents(r.Context(), q)
+			if err != nil {
+				return nil, fmt.Errorf("error reading events of %s: %v", key, err)
+			}
+			for _, event := range events {
+				if matches[event.ID] == nil {
+					matches[event.ID] = &eventMatch{event: event}
+				}
+				matches[event.ID].keys = append(matches[event.ID].keys, key)
+				q.AfterID = event.ID
+			}
+			if len(events) < q.Limit {
+				break
+			}
+		}
+	}
+	result := make([]eventMatch, 0, len(matches))
+	for _, match := range matches {
+		sort.Strings(match.keys)
+		result = append(result, *match)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].event.ID < result[j].event.ID })
+	return result, nil
+}
+
+// isClosed reports whether err is the client going away.
+func isClosed(err error) bool {
+	return websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) ||
+		errors.Is(err, websocket.ErrCloseSent)
+}
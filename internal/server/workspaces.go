@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+
+	"vinted-scraper/internal/database"
+)
+
+// workspace returns the workspace of the request's API key, or
+// database.AllWorkspaces when the Server does not require keys.
+func (s *Server) workspace(ctx context.Context) int64 {
+	if s.auth == nil {
+		return database.AllWorkspaces
+	}
+	key, _ := apiKeyFrom(ctx)
+	return key.WorkspaceID
+}
+
+// owner returns the workspace that owns what the request creates: the
+// API key's, or the default workspace when the Server does not require keys.
+func (s *Server) owner(ctx context.Context) int64 {
+	if workspaceID := s.workspace(ctx); workspaceID != database.AllWorkspaces {
+		return workspaceID
+	}
+	return database.DefaultWorkspace
+}
+
+// workspaceTopic is a topic a workspace was given access to.
+type workspaceTopic struct {
+	workspaceID, topicID int64
+}
+
+// seeTopic gives the request's workspace access to a topic it searched.
+// The topic stays shared, so the next workspace to search it is served the
+// same items without scraping again.
+func (s *Server) seeTopic(ctx context.Context, topicID int64) {
+	workspaceID := s.workspace(ctx)
+	if workspaceID == database.AllWorkspaces || topicID == 0 {
+		return
+	}
+	seen := workspaceTopic{workspaceID, topicID}
+	if _, ok := s.auth.topics.Load(seen); ok {
+		return
+	}
+	if err := s.db.AddWorkspaceTopic(ctx, workspaceID, topicID); err != nil {
+		log.Print(err)
+		return
+	}
+	s.auth.topics.Store(seen, struct{}{})
+}
+
+// scopeItems restricts q to the topics the request's workspace sees, and
+// answers 404 for a topic or topic_id it does not see.
+func (s *Server) scopeItems(ctx context.Context, q *database.ItemQuery) error {
+	q.WorkspaceID = s.workspace(ctx)
+	if q.WorkspaceID == database.AllWorkspaces {
+		return nil
+	}
+	if q.TopicID != 0 {
+		_, err := s.db.GetTopic(ctx, q.WorkspaceID, q.TopicID)
+		if err == database.ErrNotFound {
+			return notFound("topic %d not found", q.TopicID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if q.Topic != "" {
+		topics, err := s.db.ListTopics(ctx, q.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(topics, func(topic database.Topic) bool { return topic.Name == q.Topic }) {
+			return notFound("topic %q not found", q.Topic)
+		}
+	}
+	return nil
+}
+
+// workspaceHandler serves GET /v1/workspace, the workspace of the API key.
+func (s *Server) workspaceHandler(w http.ResponseWriter, r *http.Request) error {
+	ws, err := s.db.GetWorkspace(r.Context(), s.owner(r.Context()))
+	if err != nil {
+		return err
+	}
+	return writeData(w, ws, responseMeta{})
+}
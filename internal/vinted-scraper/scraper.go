@@ -2,13 +2,61 @@ package vinted_scraper
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
+// Classes of ScrapeError, recorded with each scrape run.
+const (
+	ClassCookie  = "cookie"
+	ClassNetwork = "network"
+	ClassHTTP    = "http_status"
+	ClassDecode  = "decode"
+)
+
+// ScrapeError is returned by Search. Class says which step failed and
+// StatusCode holds Vinted's HTTP status once a response was received.
+type ScrapeError struct {
+	Class      string
+	StatusCode int
+	Err        error
+}
+
+func (e *ScrapeError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("vinted %s error (HTTP %d): %v", e.Class, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("vinted %s error: %v", e.Class, e.Err)
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClass returns the class of a ScrapeError in err's chain, or "" if there is none.
+func ErrorClass(err error) string {
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		return scrapeErr.Class
+	}
+	return ""
+}
+
+// StatusCode returns the HTTP status recorded in a ScrapeError in err's chain, or 0.
+func StatusCode(err error) int {
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		return scrapeErr.StatusCode
+	}
+	return 0
+}
+
 type Order string
 
 const (
@@ -33,6 +81,18 @@ func ToOrder(order string) Order {
 	}
 }
 
+// ParseOrder is ToOrder for untrusted input: it rejects unknown orders
+// instead of falling back to NEWEST_FIRST. An empty order is NEWEST_FIRST.
+func ParseOrder(order string) (Order, error) {
+	switch Order(order) {
+	case "":
+		return NEWEST_FIRST, nil
+	case NEWEST_FIRST, RELEVANCE, PRICE_HIGH_TO_LOW, PRICE_LOW_TO_HIGH:
+		return Order(order), nil
+	}
+	return "", fmt.Errorf("unknown order %q", order)
+}
+
 func FetchCookie(domain string) (string, error) {
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.vinted.%s", domain), nil)
@@ -57,14 +117,29 @@ func FetchCookie(domain string) (string, error) {
 	return "", fmt.Errorf("cookie not found")
 }
 
-func Search(query string, order Order, currency string) (VintedApi_Response, error) {
+// SearchParams returns the catalog query parameters Search sends to Vinted
+// for a page of results, counted from 1.
+func SearchParams(query string, order Order, currency string, page int) url.Values {
+	params := url.Values{}
+	params.Set("search_text", query)
+	params.Set("currency", currency)
+	params.Set("order", string(order))
+	if page > 1 {
+		params.Set("page", strconv.Itoa(page))
+	}
+	return params
+}
+
+// Search returns a page of catalog results, counted from 1. The response's
+// Pagination says how many pages there are.
+func Search(query string, order Order, currency string, page int) (VintedApi_Response, error) {
 
 	cookie, err := FetchCookie("co.uk")
 	if err != nil {
-		return VintedApi_Response{}, err
+		return VintedApi_Response{}, &ScrapeError{Class: ClassCookie, Err: err}
 	}
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.vinted.co.uk/api/v2/catalog/items?search_text=%s&currency=%s&order=%s", query, currency, order), nil)
+	req, err := http.NewRequest("GET", "https://www.vinted.co.uk/api/v2/catalog/items?"+SearchParams(query, order, currency, page).Encode(), nil)
 	if err != nil {
 		return VintedApi_Response{}, err
 	}
@@ -74,18 +149,21 @@ func Search(query string, order Order, currency string) (VintedApi_Response, err
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return VintedApi_Response{}, err
+		return VintedApi_Response{}, &ScrapeError{Class: ClassNetwork, Err: err}
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return VintedApi_Response{}, err
+		return VintedApi_Response{}, &ScrapeError{Class: ClassNetwork, StatusCode: resp.StatusCode, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return VintedApi_Response{}, &ScrapeError{Class: ClassHTTP, StatusCode: resp.StatusCode, Err: fmt.Errorf("unexpected status %s", resp.Status)}
 	}
 	var response VintedApi_Response
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return VintedApi_Response{}, err
+		return VintedApi_Response{}, &ScrapeError{Class: ClassDecode, StatusCode: resp.StatusCode, Err: err}
 	}
 
 	return response, nil
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/server"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+func TestSavedSearchAlerts(t *testing.T) {
+	t.Setenv("STREAM_HEARTBEAT", "20ms")
+	t.Setenv("WEBHOOK_ALLOWED_NETWORKS", "127.0.0.1")
+	items := loadItems(t)
+	fake := &fakeSearch{items: items}
+	srv := server.New(backends(t)["sqlite"], server.WithSearch(fake.search))
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.RunWebhooks(ctx)
+
+	for _, body := range []string{
+		`{"order": "newest_first"}`,
+		`{"q": "bags", "order": "cheapest"}`,
+		`{"q": "bags", "domain": "fr"}`,
+		`{"q": "bags", "filters": {"topic": "shoes"}}`,
+		`{"q": "bags", "rules": [{"conditions": {"max_price": "cheap"}}]}`,
+	} {
+		postJSON(t, ts.URL+"/v1/saved-searches", body, http.StatusBadRequest, nil)
+	}
+
+	// Alert on the items up to the median price, and on private sellers.
+	median, _ := strconv.ParseFloat(items[len(items)/2].Price, 64)
+	cheap, private := 0, 0
+	for _, item := range items {
+		if price, _ := strconv.ParseFloat(item.Price, 64); price <= median {
+			cheap++
+		}
+		if !item.User.Business {
+			private++
+		}
+	}
+	var saved struct {
+		ID        int64             `json:"id"`
+		Text      string            `json:"q"`
+		Filters   map[string]string `json:"filters"`
+		Signature string            `json:"signature"`
+		Rules     []struct {
+			ID         int64             `json:"id"`
+			Conditions map[string]string `json:"conditions"`
+		} `json:"rules"`
+	}
+	body := fmt.Sprintf(`{"name": "cheap bags", "q": "  Bags ", "rules": [
+		{"name": "cheap", "conditions": {"max_price": "%v"}},
+		{"name": "private", "conditions": {"business": "FALSE"}}
+	]}`, median)
+	postJSON(t, ts.URL+"/v1/saved-searches", body, http.StatusCreated, &saved)
+	if saved.Text != "bags" || len(saved.Rules) != 2 || saved.Rules[1].Conditions["business"] != "false" || saved.Signature == "" {
+		t.Fatalf("expected a normalized saved search with two rules; got %+v", saved)
+	}
+	searchURL := fmt.Sprintf("%s/v1/saved-searches/%d", ts.URL, saved.ID)
+	cheapRule := saved.Rules[0].ID
+
+	rcv := &webhookReceiver{secret: "s3cret"}
+	receiver := httptest.NewServer(rcv)
+	t.Cleanup(receiver.Close)
+	postJSON(t, ts.URL+"/v1/webhooks", fmt.Sprintf(`{"url": %q, "secret": "s3cret", "event_types": ["alert"]}`, receiver.URL),
+		http.StatusCreated, nil)
+
+	// The first scrape stores every item, so each rule alerts on its matches.
+	getSearch(t, ts.URL, "", http.StatusOK)
+	var alerts []struct {
+		ID     int64 `json:"id"`
+		RuleID int64 `json:"rule_id"`
+		Item   struct {
+			ID int `json:"id"`
+		} `json:"item"`
+	}
+	decode := func(body v1Response) {
+		t.Helper()
+		alerts = nil
+		if err := json.Unmarshal(body.Data, &alerts); err != nil {
+			t.Fatalf("error decoding alerts. Err: %v", err)
+		}
+	}
+	countRule := func(id int64) int {
+		n := 0
+		for _, alert := range alerts {
+			if alert.RuleID == id {
+				n++
+			}
+		}
+		return n
+	}
+	decode(getV1(t, searchURL+"/alerts?limit=500", http.StatusOK))
+	if len(alerts) != cheap+private || countRule(cheapRule) != cheap {
+		t.Fatalf("expected %d cheap and %d private alerts; got %d alerts, %d cheap", cheap, private, len(alerts), countRule(cheapRule))
+	}
+	for i := 1; i < len(alerts); i++ {
+		if alerts[i].ID >= alerts[i-1].ID {
+			t.Fatalf("expected alerts newest first; got %d after %d", alerts[i].ID, alerts[i-1].ID)
+		}
+	}
+	page := getV1(t, ts.URL+"/v1/alerts?limit=2", http.StatusOK)
+	if page.Meta.Pagination == nil || page.Meta.Pagination.NextCursor == "" {
+		t.Errorf("expected a next cursor; got %+v", page.Meta.Pagination)
+	}
+
+	// Alerts fan out to webhooks subscribed to them.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rcv.received()) < len(alerts) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := rcv.received(); len(got) != len(alerts) || got[0].Type != "alert" {
+		t.Fatalf("expected %d alert deliveries; got %d", len(alerts), len(got))
+	}
+
+	// Seeing the same items again raises nothing; a price drop into the
+	// cheap range raises one alert.
+	getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+	decode(getV1(t, searchURL+"/alerts?limit=500", http.StatusOK))
+	if len(alerts) != cheap+private {
+		t.Fatalf("expected no new alerts; got %d", len(alerts)-cheap-private)
+	}
+	cheaper := append([]vintedscraper.Item(nil), items...)
+	var dropped *vintedscraper.Item
+	for i := range cheaper {
+		if price, _ := strconv.ParseFloat(cheaper[i].Price, 64); price > median {
+			cheaper[i].Price = strconv.FormatFloat(median/2, 'f', 2, 64)
+			dropped = &cheaper[i]
+			break
+		}
+	}
+	if dropped == nil {
+		t.Fatalf("expected an item above the median price")
+	}
+	fake.items = cheaper
+	getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+	decode(getV1(t, searchURL+"/alerts?limit=500", http.StatusOK))
+	if countRule(cheapRule) != cheap+1 || alerts[0].Item.ID != dropped.ID {
+		t.Errorf("expected a cheap alert for item %d; got %d cheap alerts, newest for item %d", dropped.ID, countRule(cheapRule), alerts[0].Item.ID)
+	}
+
+	// The saved search lists its stored items, filtered like /v1/items.
+	if got := getV1(t, searchURL+"/items?business=true&limit=500", http.StatusOK); len(got.Data) == 0 {
+		t.Errorf("expected items")
+	}
+
+	// Rules can be added and removed.
+	var rule struct {
+		ID int64 `json:"id"`
+	}
+	postJSON(t, searchURL+"/rules", `{"name": "brand", "conditions": {"brand": "Nike", "size": "M"}}`, http.StatusCreated, &rule)
+	postJSON(t, searchURL+"/rules", `{"conditions": {"colour": "red"}}`, http.StatusBadRequest, nil)
+	deleteURL(t, fmt.Sprintf("%s/rules/%d", searchURL, rule.ID), http.StatusNoContent)
+	deleteURL(t, fmt.Sprintf("%s/rules/%d", searchURL, rule.ID), http.StatusNotFound)
+
+	deleteURL(t, searchURL, http.StatusNoContent)
+	getV1(t, searchURL, http.StatusNotFound)
+	decode(getV1(t, ts.URL+"/v1/alerts", http.StatusOK))
+	if len(alerts) != 0 {
+		t.Errorf("expected the alerts to go with the saved search; got %d", len(alerts))
+	}
+}
+
+// deleteURL sends a DELETE to url and checks its status.
+func deleteURL(t *testing.T, url string, wantStatus int) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("DELETE %s: expected status %d; got %v", url, wantStatus, resp.Status)
+	}
+}
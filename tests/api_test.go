@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vinted-scraper/internal/server"
+)
+
+// v1Response is the envelope every /v1 endpoint answers with.
+type v1Response struct {
+	Data json.RawMessage `json:"data"`
+	Meta struct {
+		Source     string `json:"source"`
+		Pagination *struct {
+			Limit      int    `json:"limit"`
+			NextCursor string `json:"next_cursor"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+// newTestServer serves the API over a SQLite database preloaded with items.json under topic.
+func newTestServer(t *testing.T, topic string, opts ...server.Option) (*httptest.Server, int64) {
+	t.Helper()
+	db := backends(t)["sqlite"]
+	added, err := db.AddItems(loadItems(t), topic, "")
+	if err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+	ts := httptest.NewServer(server.New(db, opts...).RegisterRoutes())
+	t.Cleanup(ts.Close)
+	return ts, added.TopicID
+}
+
+func getV1(t *testing.T, url string, wantStatus int) v1Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("GET %s: expected status %d; got %v", url, wantStatus, resp.Status)
+	}
+	var body v1Response
+	if wantStatus == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding envelope. Err: %v", err)
+		}
+	}
+	return body
+}
+
+func TestV1Topics(t *testing.T) {
+	ts, topicID := newTestServer(t, "bags")
+
+	body := getV1(t, ts.URL+"/v1/topics", http.StatusOK)
+	var topics []struct {
+		ID    int64  `json:"id"`
+		Name  string `json:"name"`
+		Items int64  `json:"items"`
+	}
+	if err := json.Unmarshal(body.Data, &topics); err != nil {
+		t.Fatalf("error decoding topics. Err: %v", err)
+	}
+	if len(topics) != 1 || topics[0].ID != topicID || topics[0].Name != "bags" || topics[0].Items != 48 {
+		t.Errorf("expected topic bags with 48 items; got %+v", topics)
+	}
+
+	body = getV1(t, fmt.Sprintf("%s/v1/topics/%d/items?limit=10&sort=price", ts.URL, topicID), http.StatusOK)
+	var items []struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(body.Data, &items); err != nil {
+		t.Fatalf("error decoding items. Err: %v", err)
+	}
+	if len(items) != 10 || body.Meta.Source != "cache" || body.Meta.Pagination == nil || body.Meta.Pagination.NextCursor == "" {
+		t.Errorf("expected a first page of 10 cached items with a cursor; got %d items, meta %+v", len(items), body.Meta)
+	}
+
+	getV1(t, ts.URL+"/v1/topics/999", http.StatusNotFound)
+	getV1(t, ts.URL+"/v1/topics/abc/items", http.StatusBadRequest)
+	getV1(t, ts.URL+"/v1/items?sort=colour", http.StatusBadRequest)
+	getV1(t, ts.URL+"/v1/search?q=bags&order=cheapest", http.StatusBadRequest)
+}
+
+func TestProblemResponses(t *testing.T) {
+	ts, topicID := newTestServer(t, "bags")
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/v1/nowhere", http.StatusNotFound},
+		{fmt.Sprintf("/v1/topics/%d", topicID+1), http.StatusNotFound},
+		{"/v1/items?cursor=broken", http.StatusBadRequest},
+		{"/v1/search/local", http.StatusBadRequest},
+		{"/v1/topics/1/stats?window=forever", http.StatusBadRequest},
+		{"/v1/topics/1/stats?currency=pounds", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(ts.URL + c.path)
+		if err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+		var p struct {
+			Title     string `json:"title"`
+			Status    int    `json:"status"`
+			Detail    string `json:"detail"`
+			Instance  string `json:"instance"`
+			RequestID string `json:"request_id"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&p)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("GET %s: error decoding problem. Err: %v", c.path, err)
+		}
+		if resp.StatusCode != c.status || p.Status != c.status {
+			t.Errorf("GET %s: expected status %d; got %d with problem status %d", c.path, c.status, resp.StatusCode, p.Status)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("GET %s: expected problem+json; got %q", c.path, ct)
+		}
+		if p.Title != http.StatusText(c.status) || p.Detail == "" || p.RequestID == "" || p.RequestID != resp.Header.Get("X-Request-Id") {
+			t.Errorf("GET %s: incomplete problem %+v", c.path, p)
+		}
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	fake := &fakeSearch{items: loadItems(t)}
+	ts, topicID := newTestServer(t, "bags", server.WithSearch(fake.search))
+
+	get := func(path string, header http.Header, wantStatus int) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("GET %s with %v: expected status %d; got %v", path, header, wantStatus, resp.Status)
+		}
+		return resp
+	}
+
+	for _, path := range []string{fmt.Sprintf("/v1/topics/%d", topicID), fmt.Sprintf("/v1/topics/%d/items", topicID), "/v1/items?brand=nike"} {
+		resp := get(path, nil, http.StatusOK)
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			t.Fatalf("GET %s: expected an ETag", path)
+		}
+		get(path, http.Header{"If-None-Match": {etag}}, http.StatusNotModified)
+		get(path, http.Header{"If-None-Match": {`"other", ` + etag}}, http.StatusNotModified)
+		get(path, http.Header{"If-None-Match": {`"other"`}}, http.StatusOK)
+		if modified := resp.Header.Get("Last-Modified"); modified != "" {
+			get(path, http.Header{"If-Modified-Since": {modified}}, http.StatusNotModified)
+			get(path, http.Header{"If-Modified-Since": {"Mon, 01 Jan 2001 00:00:00 GMT"}}, http.StatusOK)
+		} else if path != "/v1/items?brand=nike" {
+			t.Errorf("GET %s: expected a Last-Modified", path)
+		}
+	}
+
+	// A scrape changes the search response and its ETag.
+	get("/v1/search?q=bags", nil, http.StatusOK)
+	etag := get("/v1/search?q=bags", nil, http.StatusOK).Header.Get("ETag")
+	get("/v1/search?q=bags", http.Header{"If-None-Match": {etag}}, http.StatusNotModified)
+	time.Sleep(10 * time.Millisecond)
+	get("/v1/search?q=bags&refresh=true", nil, http.StatusOK)
+	get("/v1/search?q=bags", http.Header{"If-None-Match": {etag}}, http.StatusOK)
+}
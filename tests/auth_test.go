@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/server"
+)
+
+// doAuth makes a request with an API key and checks its status.
+func doAuth(t *testing.T, method, url, key, body string, wantStatus int, v interface{}) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("error building request. Err: %v", err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: expected status %d; got %v: %s", method, url, wantStatus, resp.Status, data)
+	}
+	if v != nil {
+		envelope := struct{ Data interface{} }{Data: v}
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			t.Fatalf("error decoding response. Err: %v", err)
+		}
+	}
+	return resp
+}
+
+func TestAPIKeys(t *testing.T) {
+	t.Setenv("API_SCRAPE_LIMIT", "1/1h")
+	fake := &fakeSearch{items: loadItems(t)}
+	db := backends(t)["sqlite"]
+	srv := server.New(db, server.WithSearch(fake.search), server.WithAuth())
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+
+	admin, err := db.CreateAPIKey(context.Background(), database.APIKey{Name: "ops", Role: database.RoleAdmin})
+	if err != nil {
+		t.Fatalf("error creating API key. Err: %v", err)
+	}
+
+	doAuth(t, http.MethodGet, ts.URL+"/health", "", "", http.StatusOK, nil)
+	resp := doAuth(t, http.MethodGet, ts.URL+"/v1/topics", "", "", http.StatusUnauthorized, nil)
+	if resp.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("expected a WWW-Authenticate challenge")
+	}
+	doAuth(t, http.MethodGet, ts.URL+"/v1/topics", "vsk_nope", "", http.StatusUnauthorized, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/vintedTopic/bags-newest", "", "", http.StatusUnauthorized, nil)
+
+	var reader database.APIKey
+	doAuth(t, http.MethodPost, ts.URL+"/v1/keys", admin.Key, `{"name": "dashboard", "rate_limit": 4}`, http.StatusCreated, &reader)
+	if reader.Key == "" || reader.Role != database.RoleReader || reader.RateLimit != 4 {
+		t.Fatalf("expected a reader key with its secret; got %+v", reader)
+	}
+	doAuth(t, http.MethodPost, ts.URL+"/v1/keys", reader.Key, `{"name": "escalated", "role": "admin"}`, http.StatusForbidden, nil)
+	doAuth(t, http.MethodPost, ts.URL+"/v1/keys", admin.Key, `{"name": " "}`, http.StatusBadRequest, nil)
+	doAuth(t, http.MethodPost, ts.URL+"/v1/keys", admin.Key, `{"name": "root", "role": "owner"}`, http.StatusBadRequest, nil)
+
+	// The key's only live scrape fetches the search; another search is
+	// refused until the quota refills, but the stored search is served.
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags", reader.Key, "", http.StatusOK, nil)
+	resp = doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=shoes", reader.Key, "", http.StatusTooManyRequests, nil)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header")
+	}
+	if calls := fake.calls.Load(); calls != 1 {
+		t.Errorf("expected one scrape; got %d", calls)
+	}
+	// The fourth request spends the key's rate limit.
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags", reader.Key, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/topics", reader.Key, "", http.StatusTooManyRequests, nil)
+	// Other keys have limits of their own.
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags&refresh=true", admin.Key, "", http.StatusOK, nil)
+
+	var revoked database.APIKey
+	doAuth(t, http.MethodDelete, fmt.Sprintf("%s/v1/keys/%d", ts.URL, reader.ID), admin.Key, "", http.StatusOK, &revoked)
+	if revoked.RevokedAt == nil || revoked.Key != "" {
+		t.Errorf("expected the key revoked, without its secret; got %+v", revoked)
+	}
+	doAuth(t, http.MethodGet, ts.URL+"/v1/topics", reader.Key, "", http.StatusUnauthorized, nil)
+	doAuth(t, http.MethodDelete, ts.URL+"/v1/keys/999", admin.Key, "", http.StatusNotFound, nil)
+
+	var keys []database.APIKey
+	doAuth(t, http.MethodGet, ts.URL+"/v1/keys", admin.Key, "", http.StatusOK, &keys)
+	if len(keys) != 2 || keys[0].LastUsedAt == nil || keys[0].Key != "" || keys[1].RevokedAt == nil {
+		t.Errorf("expected both keys, used and revoked, without secrets; got %+v", keys)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	db := backends(t)["sqlite"]
+	srv := server.New(db, server.WithSearch((&fakeSearch{items: loadItems(t)}).search), server.WithAuth())
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+
+	keys := map[database.Role]database.APIKey{}
+	for _, role := range []database.Role{database.RoleReader, database.RoleOperator, database.RoleAdmin} {
+		key, err := db.CreateAPIKey(context.Background(), database.APIKey{Name: string(role), Role: role})
+		if err != nil {
+			t.Fatalf("error creating API key. Err: %v", err)
+		}
+		keys[role] = key
+	}
+	reader, operator, admin := keys[database.RoleReader].Key, keys[database.RoleOperator].Key, keys[database.RoleAdmin].Key
+
+	// Readers read; operators also force searches and queue and cancel
+	// jobs; only admins manage keys and read the audit log.
+	doAuth(t, http.MethodGet, ts.URL+"/v1/jobs", reader, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags", reader, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags&refresh=true", reader, "", http.StatusForbidden, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/vintedTopic/bags-newest?refresh=true", reader, "", http.StatusForbidden, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags&refresh=true", operator, "", http.StatusOK, nil)
+	doAuth(t, http.MethodPost, ts.URL+"/v1/jobs", reader, `{"q": "bags"}`, http.StatusForbidden, nil)
+	var job scrapeJob
+	doAuth(t, http.MethodPost, ts.URL+"/v1/jobs", operator, `{"q": "bags"}`, http.StatusAccepted, &job)
+	doAuth(t, http.MethodPost, fmt.Sprintf("%s/v1/jobs/%d/cancel", ts.URL, job.ID), admin, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/keys", operator, "", http.StatusForbidden, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/audit", operator, "", http.StatusForbidden, nil)
+
+	// Every privileged request is audited, allowed or not; reads are not.
+	var entries []database.AuditEntry
+	doAuth(t, http.MethodGet, ts.URL+"/v1/audit", admin, "", http.StatusOK, &entries)
+	want := []struct {
+		key    database.APIKey
+		action string
+		status int
+	}{
+		{keys[database.RoleOperator], "GET /v1/audit", http.StatusForbidden},
+		{keys[database.RoleOperator], "GET /v1/keys", http.StatusForbidden},
+		{keys[database.RoleAdmin], "POST /v1/jobs/{id}/cancel", http.StatusOK},
+		{keys[database.RoleOperator], "POST /v1/jobs", http.StatusAccepted},
+		{keys[database.RoleReader], "POST /v1/jobs", http.StatusForbidden},
+		{keys[database.RoleOperator], "GET /v1/search", http.StatusOK},
+		{keys[database.RoleReader], "GET /vintedTopic/{topicOrder}", http.StatusForbidden},
+		{keys[database.RoleReader], "GET /v1/search", http.StatusForbidden},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d audit entries; got %+v", len(want), entries)
+	}
+	for i, w := range want {
+		got := entries[i]
+		if got.APIKeyID != w.key.ID || got.Actor != w.key.Prefix || got.Action != w.action || got.Status != w.status || got.RequestID == "" {
+			t.Errorf("expected entry %d to be %s by %s answered %d; got %+v", i, w.action, w.key.Prefix, w.status, got)
+		}
+	}
+	if got := entries[2].Path; got != fmt.Sprintf("/v1/jobs/%d/cancel", job.ID) {
+		t.Errorf("expected the cancelled job's path; got %q", got)
+	}
+
+	doAuth(t, http.MethodGet, fmt.Sprintf("%s/v1/audit?api_key_id=%d&limit=1", ts.URL, keys[database.RoleOperator].ID), admin, "", http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].Action != "GET /v1/audit" || entries[0].Status != http.StatusForbidden {
+		t.Errorf("expected the operator's latest entry; got %+v", entries)
+	}
+}
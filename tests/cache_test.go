@@ -0,0 +1,367 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/server"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// fakeSearch counts the searches it answers with the items of items.json,
+// reversed for price_low_to_high, or with err. Searches wait until release
+// is closed, if it is set. With perPage set, the items are split into pages
+// of that size; otherwise they all come on the first page.
+type fakeSearch struct {
+	items   []vintedscraper.Item
+	err     error
+	release chan struct{}
+	perPage int
+	calls   atomic.Int32
+}
+
+func (f *fakeSearch) search(_ string, order vintedscraper.Order, _ string, page int) (vintedscraper.VintedApi_Response, error) {
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	if f.err != nil {
+		return vintedscraper.VintedApi_Response{}, f.err
+	}
+	items := f.items
+	if order == vintedscraper.PRICE_LOW_TO_HIGH {
+		items = make([]vintedscraper.Item, len(f.items))
+		for i, item := range f.items {
+			items[len(items)-1-i] = item
+		}
+	}
+	var response vintedscraper.VintedApi_Response
+	response.Pagination.CurrentPage, response.Pagination.TotalPages = page, 1
+	if f.perPage > 0 {
+		response.Pagination.TotalPages = (len(items) + f.perPage - 1) / f.perPage
+		items = items[min(len(items), (page-1)*f.perPage):min(len(items), page*f.perPage)]
+	} else if page > 1 {
+		items = nil
+	}
+	response.Items = items
+	return response, nil
+}
+
+// getSearch requests /v1/search?q=bags plus extra and returns the response
+// with its body closed.
+func getSearch(t *testing.T, url, extra string, wantStatus int) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url + "/v1/search?q=bags" + extra)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("search: expected status %d; got %v", wantStatus, resp.Status)
+	}
+	return resp
+}
+
+func TestTopicCache(t *testing.T) {
+	fake := &fakeSearch{items: loadItems(t)}
+
+	t.Run("unknown searches are scraped", func(t *testing.T) {
+		ts, _ := newTestServer(t, "bags", server.WithSearch(fake.search))
+		fake.calls.Store(0)
+		resp := getSearch(t, ts.URL, "", http.StatusOK)
+		if got := resp.Header.Get("Cache-Status"); !strings.Contains(got, "fwd=miss") {
+			t.Errorf("expected a miss; got Cache-Status %q", got)
+		}
+		if fake.calls.Load() != 1 {
+			t.Errorf("expected one scrape; got %d", fake.calls.Load())
+		}
+
+		// The scrape was recorded, so the topic is now fresh.
+		resp = getSearch(t, ts.URL, "", http.StatusOK)
+		if got := resp.Header.Get("Cache-Status"); !strings.Contains(got, "; hit; ttl=") || resp.Header.Get("Age") == "" {
+			t.Errorf("expected a fresh hit with an Age; got Cache-Status %q, Age %q", got, resp.Header.Get("Age"))
+		}
+		if fake.calls.Load() != 1 {
+			t.Errorf("expected a fresh hit not to scrape; got %d scrapes", fake.calls.Load())
+		}
+
+		resp = getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+		if got := resp.Header.Get("Cache-Status"); !strings.Contains(got, "fwd=request") {
+			t.Errorf("expected a forced refresh; got Cache-Status %q", got)
+		}
+		if fake.calls.Load() != 2 {
+			t.Errorf("expected refresh=true to scrape; got %d scrapes", fake.calls.Load())
+		}
+		getSearch(t, ts.URL, "&refresh=maybe", http.StatusBadRequest)
+	})
+
+	t.Run("stale topics are refreshed once in the background", func(t *testing.T) {
+		t.Setenv("CACHE_FRESH_TTL", "1ns")
+		t.Setenv("CACHE_STALE_TTL", "1h")
+		ts, _ := newTestServer(t, "bags", server.WithSearch(fake.search))
+		getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+
+		fake.calls.Store(0)
+		fake.release = make(chan struct{})
+		defer func() { fake.release = nil }()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp := getSearch(t, ts.URL, "", http.StatusOK)
+				if got := resp.Header.Get("Cache-Status"); !strings.Contains(got, "detail=revalidating") {
+					t.Errorf("expected a stale hit; got Cache-Status %q", got)
+				}
+			}()
+		}
+		wg.Wait()
+		if calls := fake.calls.Load(); calls != 1 {
+			t.Errorf("expected concurrent stale hits to share one refresh; got %d scrapes", calls)
+		}
+		close(fake.release)
+		// Wait for the background refresh before the database is closed.
+		getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+	})
+
+	t.Run("failed refreshes fall back within max age", func(t *testing.T) {
+		t.Setenv("CACHE_FRESH_TTL", "0s")
+		t.Setenv("CACHE_STALE_TTL", "0s")
+		failing := &fakeSearch{items: fake.items}
+		ts, _ := newTestServer(t, "bags", server.WithSearch(failing.search))
+		getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+
+		failing.err = errors.New("vinted is down")
+		resp := getSearch(t, ts.URL, "", http.StatusOK)
+		if got := resp.Header.Get("Cache-Status"); !strings.Contains(got, "detail=stale-if-error") {
+			t.Errorf("expected a stale-if-error hit; got Cache-Status %q", got)
+		}
+		getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+
+		t.Setenv("CACHE_MAX_AGE", "0s")
+		ts, _ = newTestServer(t, "bags", server.WithSearch(failing.search))
+		getSearch(t, ts.URL, "", http.StatusBadGateway)
+	})
+
+	t.Run("replicas share a scrape through the job queue", func(t *testing.T) {
+		db := backends(t)["sqlite"]
+		var replicas []string
+		for i := 0; i < 2; i++ {
+			ts := httptest.NewServer(server.New(db, server.WithSearch(fake.search)).RegisterRoutes())
+			t.Cleanup(ts.Close)
+			replicas = append(replicas, ts.URL)
+		}
+
+		fake.calls.Store(0)
+		fake.release = make(chan struct{})
+		defer func() { fake.release = nil }()
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				getSearch(t, url, "", http.StatusOK)
+			}(replicas[i%2])
+		}
+		// Let every request queue or find the job before it finishes.
+		time.Sleep(200 * time.Millisecond)
+		close(fake.release)
+		wg.Wait()
+		if calls := fake.calls.Load(); calls != 1 {
+			t.Errorf("expected both replicas to share one scrape; got %d", calls)
+		}
+		jobs, err := db.ListScrapeJobs(context.Background(), database.JobQuery{WorkspaceID: database.AllWorkspaces, Kind: database.JobKindSearch})
+		if err != nil || len(jobs) != 1 || jobs[0].Status != database.JobSucceeded {
+			t.Errorf("expected one succeeded search job; got %+v (%v)", jobs, err)
+		}
+	})
+}
+
+func TestSearchSignatures(t *testing.T) {
+	fake := &fakeSearch{items: loadItems(t)}
+	ts, _ := newTestServer(t, "bags", server.WithSearch(fake.search))
+
+	ids := func(extra string) []int {
+		t.Helper()
+		body := getV1(t, ts.URL+"/v1/search?"+extra, http.StatusOK)
+		var items []struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(body.Data, &items); err != nil {
+			t.Fatalf("error decoding items. Err: %v", err)
+		}
+		result := make([]int, len(items))
+		for i, item := range items {
+			result[i] = item.ID
+		}
+		return result
+	}
+
+	newest := ids("q=bags&order=newest_first")
+	cheapest := ids("q=bags&order=price_low_to_high")
+	if fake.calls.Load() != 2 {
+		t.Fatalf("expected each order to be scraped; got %d scrapes", fake.calls.Load())
+	}
+	if len(newest) < 2 || len(newest) != len(cheapest) {
+		t.Fatalf("expected both orders to return the same items; got %d and %d", len(newest), len(cheapest))
+	}
+	for i := range newest {
+		if newest[i] != cheapest[len(cheapest)-1-i] {
+			t.Fatalf("expected each order to keep the order Vinted returned; got %v and %v", newest, cheapest)
+		}
+	}
+
+	// Spelling differences map onto the same signature and are cache hits.
+	cached := ids("q=%20BAGS%20&order=newest_first&currency=gbp")
+	if fake.calls.Load() != 2 {
+		t.Errorf("expected an equivalent search to be a cache hit; got %d scrapes", fake.calls.Load())
+	}
+	for i := range newest {
+		if cached[i] != newest[i] {
+			t.Fatalf("expected a cache hit to keep the live order; got %v, want %v", cached, newest)
+		}
+	}
+
+	// Pages of a cached search follow the live order too.
+	first := getV1(t, ts.URL+"/v1/search?q=bags&order=newest_first&limit=2", http.StatusOK)
+	if first.Meta.Pagination == nil || first.Meta.Pagination.NextCursor == "" {
+		t.Fatalf("expected a next cursor")
+	}
+	if second := ids("q=bags&order=newest_first&limit=2&cursor=" + first.Meta.Pagination.NextCursor); len(second) == 0 || second[0] != newest[2] {
+		t.Errorf("expected the second page to start at %d; got %v", newest[2], second)
+	}
+
+	// Vinted never sees the filters: a filtered search narrows down the
+	// stored results, in their live order, without scraping again.
+	items := fake.items
+	brand := items[0].BrandTitle
+	filtered := ids("q=bags&order=newest_first&brand=" + url.QueryEscape(brand))
+	if fake.calls.Load() != 2 {
+		t.Errorf("expected filters not to change the signature; got %d scrapes", fake.calls.Load())
+	}
+	var want []int
+	for _, id := range newest {
+		for _, item := range items {
+			if item.ID == id && strings.EqualFold(item.BrandTitle, brand) {
+				want = append(want, id)
+			}
+		}
+	}
+	if len(filtered) == 0 || fmt.Sprint(filtered) != fmt.Sprint(want) {
+		t.Errorf("expected the %s items in live order %v; got %v", brand, want, filtered)
+	}
+
+	// The currency is part of the signature.
+	ids("q=bags&order=newest_first&currency=EUR")
+	if fake.calls.Load() != 3 {
+		t.Errorf("expected the currency to change the signature; got %d scrapes", fake.calls.Load())
+	}
+	getV1(t, ts.URL+"/v1/search?q=bags&currency=pounds", http.StatusBadRequest)
+}
+
+func TestSearchSignature(t *testing.T) {
+	price := 10.0
+	a := database.NewSearchSignature("  Nike   Shoes ", "newest_first", "", "gbp", database.ItemQuery{Brand: "Nike", MaxPrice: &price}.FilterValues())
+	b := database.NewSearchSignature("nike shoes", "newest_first", "co.uk", "GBP", database.ItemQuery{Brand: "nike", MaxPrice: &price}.FilterValues())
+	if a.String() != b.String() {
+		t.Errorf("expected equal signatures; got %q and %q", a, b)
+	}
+	want := "brand=nike&currency=GBP&domain=co.uk&max_price=10&order=newest_first&q=nike+shoes"
+	if a.String() != want {
+		t.Errorf("expected %q; got %q", want, a)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	fake := &fakeSearch{items: loadItems(t)}
+	ts, _ := newTestServer(t, "bags", server.WithSearch(fake.search))
+
+	stats := func() (s struct {
+		Entries       int    `json:"entries"`
+		Hits          uint64 `json:"hits"`
+		Misses        uint64 `json:"misses"`
+		Invalidations uint64 `json:"invalidations"`
+	}) {
+		t.Helper()
+		body := getV1(t, ts.URL+"/v1/cache", http.StatusOK)
+		if err := json.Unmarshal(body.Data, &s); err != nil {
+			t.Fatalf("error decoding cache stats. Err: %v", err)
+		}
+		return s
+	}
+
+	live := getV1(t, ts.URL+"/v1/search?q=bags", http.StatusOK)
+	first := getV1(t, ts.URL+"/v1/search?q=bags", http.StatusOK)
+	second := getV1(t, ts.URL+"/v1/search?q=bags", http.StatusOK)
+	if string(first.Data) != string(second.Data) || string(live.Data) != string(first.Data) {
+		t.Errorf("expected cached responses to match the live one")
+	}
+	if s := stats(); s.Entries != 1 || s.Misses != 1 || s.Hits != 1 {
+		t.Errorf("expected one entry, one miss and one hit; got %+v", s)
+	}
+
+	// Ingesting the topic again drops its responses.
+	getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+	if s := stats(); s.Entries != 0 || s.Invalidations != 1 {
+		t.Errorf("expected the refresh to invalidate the entry; got %+v", s)
+	}
+	getV1(t, ts.URL+"/v1/search?q=bags", http.StatusOK)
+	if s := stats(); s.Entries != 1 || s.Misses != 2 {
+		t.Errorf("expected a miss after the invalidation; got %+v", s)
+	}
+}
+
+func TestResponseCacheAcrossReplicas(t *testing.T) {
+	fake := &fakeSearch{items: loadItems(t)}
+	db := backends(t)["sqlite"]
+	a := httptest.NewServer(server.New(db, server.WithSearch(fake.search)).RegisterRoutes())
+	t.Cleanup(a.Close)
+	b := httptest.NewServer(server.New(db, server.WithSearch(fake.search)).RegisterRoutes())
+	t.Cleanup(b.Close)
+
+	stats := func(url string) (s struct {
+		Misses      uint64 `json:"misses"`
+		Expirations uint64 `json:"expirations"`
+	}) {
+		t.Helper()
+		if err := json.Unmarshal(getV1(t, url+"/v1/cache", http.StatusOK).Data, &s); err != nil {
+			t.Fatalf("error decoding cache stats. Err: %v", err)
+		}
+		return s
+	}
+
+	getSearch(t, a.URL, "", http.StatusOK)
+	getSearch(t, a.URL, "", http.StatusOK)
+	getSearch(t, a.URL, "", http.StatusOK)
+	if s := stats(a.URL); s.Misses != 1 {
+		t.Fatalf("expected one miss before the other replica scrapes; got %+v", s)
+	}
+	// A scrape by the other replica is not announced to this one, but
+	// changes the scrape time its responses are keyed by.
+	time.Sleep(time.Millisecond)
+	getSearch(t, b.URL, "&refresh=true", http.StatusOK)
+	getSearch(t, a.URL, "", http.StatusOK)
+	if s := stats(a.URL); s.Misses != 2 {
+		t.Errorf("expected a miss after the other replica's scrape; got %+v", s)
+	}
+
+	t.Setenv("RESPONSE_CACHE_TTL", "1ns")
+	c := httptest.NewServer(server.New(db, server.WithSearch(fake.search)).RegisterRoutes())
+	t.Cleanup(c.Close)
+	getSearch(t, c.URL, "", http.StatusOK)
+	getSearch(t, c.URL, "", http.StatusOK)
+	if s := stats(c.URL); s.Misses != 2 || s.Expirations != 1 {
+		t.Errorf("expected the expired response to be rendered again; got %+v", s)
+	}
+}
@@ -0,0 +1,495 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"vinted-scraper/internal/database"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// backends returns every database.Service the repository suite runs against.
+// SQLite always runs; Postgres runs when TEST_DATABASE_URL points at a database.
+func backends(t *testing.T) map[string]database.Service {
+	t.Helper()
+	services := make(map[string]database.Service)
+
+	sqlite, err := database.NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("error opening sqlite. Err: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	services["sqlite"] = sqlite
+
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		services["postgres"] = postgresBackend(t, url)
+	}
+	return services
+}
+
+// postgresBackend migrates a schema of its own in the database at url, so
+// every test starts from an empty database, and drops it when the test ends.
+// Extensions live in public, which stays on the search path.
+func postgresBackend(t *testing.T, url string) database.Service {
+	t.Helper()
+	admin, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatalf("error opening postgres. Err: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	for _, stmt := range []string{"CREATE EXTENSION IF NOT EXISTS pg_trgm SCHEMA public", "CREATE SCHEMA " + schema} {
+		if _, err := admin.Exec(stmt); err != nil {
+			t.Fatalf("error preparing postgres. Err: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("error dropping schema %s. Err: %v", schema, err)
+		}
+	})
+
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	postgres, err := database.NewPostgres(url + sep + "search_path=" + schema + "%2Cpublic")
+	if err != nil {
+		t.Fatalf("error opening postgres. Err: %v", err)
+	}
+	t.Cleanup(func() { postgres.Close() })
+	return postgres
+}
+
+// loadItems reads the saved catalog response in items.json.
+func loadItems(t *testing.T) []vintedscraper.Item {
+	t.Helper()
+	data, err := os.ReadFile("items.json")
+	if err != nil {
+		t.Fatalf("error reading items.json. Err: %v", err)
+	}
+	var response vintedscraper.VintedApi_Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("error decoding items.json. Err: %v", err)
+	}
+	return response.Items
+}
+
+func TestRepository(t *testing.T) {
+	items := loadItems(t)
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			topic := "repository-" + name
+			added, err := db.AddItems(items, topic, "")
+			if err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+			if added.New != len(items) || added.Updated != 0 {
+				t.Errorf("expected %d new items; got %+v", len(items), added)
+			}
+			// Re-adding the same response must update rather than duplicate.
+			readded, err := db.AddItems(items, topic, "")
+			if err != nil {
+				t.Fatalf("error re-adding items. Err: %v", err)
+			}
+			if readded.New != 0 || readded.Updated != len(items) || readded.TopicID != added.TopicID {
+				t.Errorf("expected %d updated items; got %+v", len(items), readded)
+			}
+
+			topicID, err := db.ExistsTopic(topic)
+			if err != nil || topicID == 0 {
+				t.Fatalf("expected topic %q to exist; got id %d, err %v", topic, topicID, err)
+			}
+			if _, err := db.ExistsTopic("missing-" + name); err == nil {
+				t.Errorf("expected an error for a missing topic")
+			}
+
+			stored, err := db.GetItems(topicID)
+			if err != nil {
+				t.Fatalf("error getting items. Err: %v", err)
+			}
+			if len(stored) != len(items) {
+				t.Fatalf("expected %d items; got %d", len(items), len(stored))
+			}
+			byID := make(map[int]vintedscraper.Item)
+			for _, item := range stored {
+				byID[item.ID] = item
+			}
+			for _, want := range items {
+				got, ok := byID[want.ID]
+				if !ok {
+					t.Errorf("item %d missing", want.ID)
+					continue
+				}
+				if got.Title != want.Title || got.BrandTitle != want.BrandTitle || got.Photo.ID != want.Photo.ID {
+					t.Errorf("item %d: expected %q/%q/%d; got %q/%q/%d", want.ID,
+						want.Title, want.BrandTitle, want.Photo.ID, got.Title, got.BrandTitle, got.Photo.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestRepricing(t *testing.T) {
+	ctx := context.Background()
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			items := loadItems(t)[:3]
+			for i := range items {
+				items[i].Price, items[i].Currency = "10.0", "GBP"
+			}
+			added, err := db.AddItems(items, "repricing", "")
+			if err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+			changes := func() (history, events int) {
+				t.Helper()
+				q := database.ItemQuery{WorkspaceID: database.AllWorkspaces, TopicID: added.TopicID}
+				if err := db.StreamPriceHistory(ctx, q, func(database.PricePoint) error { history++; return nil }); err != nil {
+					t.Fatalf("error streaming price history. Err: %v", err)
+				}
+				list, err := db.ListItemEvents(ctx, database.EventQuery{WorkspaceID: database.AllWorkspaces, TopicID: added.TopicID, Limit: 100})
+				if err != nil {
+					t.Fatalf("error listing events. Err: %v", err)
+				}
+				return history, len(list)
+			}
+
+			// The same listings priced in euros did not change price.
+			euros := append([]vintedscraper.Item(nil), items...)
+			for i := range euros {
+				euros[i].Price, euros[i].Currency = "11.5", "EUR"
+			}
+			if _, err := db.AddItems(euros, "repricing", ""); err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+			if history, events := changes(); history != len(items) || events != len(items) {
+				t.Errorf("expected a search in euros to record nothing; got %d prices and %d events", history, events)
+			}
+
+			// A drop in pounds is still found after the euro search.
+			items[0].Price = "8.0"
+			if _, err := db.AddItems(items, "repricing", ""); err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+			if history, events := changes(); history != len(items)+1 || events != len(items)+1 {
+				t.Errorf("expected one price drop; got %d prices and %d events", history, events)
+			}
+		})
+	}
+}
+
+func TestQueryItems(t *testing.T) {
+	items := loadItems(t)
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			topic := "query-" + name
+			if _, err := db.AddItems(items, topic, ""); err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+
+			// Walking every page by descending price must visit each item once, in order.
+			q := database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: topic, Sort: database.SortPrice, Desc: true, Limit: 7}
+			seen := make(map[int]bool)
+			last := math.Inf(1)
+			for pages := 0; ; pages++ {
+				if pages > len(items) {
+					t.Fatalf("pagination did not terminate")
+				}
+				page, err := db.QueryItems(context.Background(), q)
+				if err != nil {
+					t.Fatalf("error querying items. Err: %v", err)
+				}
+				for _, item := range page.Items {
+					price, _ := strconv.ParseFloat(item.Price, 64)
+					if price > last {
+						t.Errorf("item %d priced %v after %v", item.ID, price, last)
+					}
+					if seen[item.ID] {
+						t.Errorf("item %d returned twice", item.ID)
+					}
+					seen[item.ID], last = true, price
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+			if len(seen) != len(items) {
+				t.Errorf("expected %d items across pages; got %d", len(items), len(seen))
+			}
+
+			min, max := 10.0, 30.0
+			page, err := db.QueryItems(context.Background(), database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: topic, MinPrice: &min, MaxPrice: &max, Limit: database.MaxItemLimit})
+			if err != nil {
+				t.Fatalf("error querying items. Err: %v", err)
+			}
+			want := 0
+			for _, item := range items {
+				if price, _ := strconv.ParseFloat(item.Price, 64); price >= min && price <= max {
+					want++
+				}
+			}
+			if len(page.Items) != want {
+				t.Errorf("expected %d items priced %v-%v; got %d", want, min, max, len(page.Items))
+			}
+
+			page, err = db.QueryItems(context.Background(), database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: topic, Brand: "primark"})
+			if err != nil {
+				t.Fatalf("error querying items. Err: %v", err)
+			}
+			for _, item := range page.Items {
+				if item.BrandTitle != "Primark" {
+					t.Errorf("expected only Primark items; got %q", item.BrandTitle)
+				}
+			}
+			if len(page.Items) == 0 {
+				t.Errorf("expected Primark items")
+			}
+
+			_, err = db.QueryItems(context.Background(), database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: topic, Cursor: "not-a-cursor"})
+			if !errors.Is(err, database.ErrInvalidCursor) {
+				t.Errorf("expected ErrInvalidCursor; got %v", err)
+			}
+		})
+	}
+}
+
+func TestSearchItems(t *testing.T) {
+	items := loadItems(t)
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := db.AddItems(items, "search-"+name, ""); err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+
+			results, err := db.SearchItems(context.Background(), database.SearchQuery{WorkspaceID: database.AllWorkspaces, Text: "radley"})
+			if err != nil {
+				t.Fatalf("error searching items. Err: %v", err)
+			}
+			if len(results) == 0 {
+				t.Fatalf("expected results for radley")
+			}
+			for i, result := range results {
+				if i > 0 && result.Rank > results[i-1].Rank {
+					t.Errorf("results not ranked: %v after %v", result.Rank, results[i-1].Rank)
+				}
+			}
+			if results[0].BrandTitle != "Radley" && !strings.Contains(strings.ToLower(results[0].Title), "radley") {
+				t.Errorf("expected a Radley item first; got %q by %q", results[0].Title, results[0].BrandTitle)
+			}
+
+			if _, err := db.SearchItems(context.Background(), database.SearchQuery{WorkspaceID: database.AllWorkspaces, Text: "  "}); !errors.Is(err, database.ErrEmptySearch) {
+				t.Errorf("expected ErrEmptySearch; got %v", err)
+			}
+			results, err = db.SearchItems(context.Background(), database.SearchQuery{WorkspaceID: database.AllWorkspaces, Text: "radley", Domain: "fr"})
+			if err != nil {
+				t.Fatalf("error searching items. Err: %v", err)
+			}
+			if len(results) != 0 {
+				t.Errorf("expected no fr results; got %d", len(results))
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	items := loadItems(t)
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			topic := "prune-" + name
+			// Scraping twice leaves a duplicate of every thumbnail behind.
+			for i := 0; i < 2; i++ {
+				if _, err := db.AddItems(items, topic, ""); err != nil {
+					t.Fatalf("error adding items. Err: %v", err)
+				}
+			}
+			stale := items[:5]
+			for _, item := range stale {
+				_, err := db.Exec(ctx, "UPDATE Item SET last_seen_at = $1 WHERE id = $2", time.Now().UTC().AddDate(0, 0, -100), item.ID)
+				if err != nil {
+					t.Fatalf("error backdating item. Err: %v", err)
+				}
+			}
+
+			policy := database.RetentionPolicy{ItemMaxAge: 90 * 24 * time.Hour, Orphans: true}
+			dry, err := db.Prune(ctx, policy, true)
+			if err != nil {
+				t.Fatalf("error on dry run. Err: %v", err)
+			}
+			if dry.Items != int64(len(stale)) || dry.Photos != int64(len(stale)) || dry.PriceHistory != int64(len(stale)) {
+				t.Errorf("expected %d items, photos and prices in dry run; got %+v", len(stale), dry)
+			}
+			if dry.Thumbnails == 0 {
+				t.Errorf("expected duplicate thumbnails in dry run")
+			}
+
+			topicID, _ := db.ExistsTopic(topic)
+			if stored, _ := db.GetItems(topicID); len(stored) != len(items) {
+				t.Fatalf("dry run removed items: %d left of %d", len(stored), len(items))
+			}
+
+			report, err := db.Prune(ctx, policy, false)
+			if err != nil {
+				t.Fatalf("error pruning. Err: %v", err)
+			}
+			dry.DryRun = false
+			if report != dry {
+				t.Errorf("expected prune to match dry run %+v; got %+v", dry, report)
+			}
+			if stored, _ := db.GetItems(topicID); len(stored) != len(items)-len(stale) {
+				t.Errorf("expected %d items after prune; got %d", len(items)-len(stale), len(stored))
+			}
+
+			again, err := db.Prune(ctx, policy, false)
+			if err != nil {
+				t.Fatalf("error pruning. Err: %v", err)
+			}
+			if again != (database.PruneReport{}) {
+				t.Errorf("expected a second prune to remove nothing; got %+v", again)
+			}
+		})
+	}
+}
+
+func TestScrapeRuns(t *testing.T) {
+	items := loadItems(t)
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			topic := "runs-" + name
+			started := time.Now().Add(-time.Second)
+
+			// A run that failed before the topic existed has no topic id.
+			failed := database.ScrapeRun{Topic: topic, QueryParams: "search_text=" + topic, Domain: "co.uk",
+				StartedAt: started, FinishedAt: time.Now(), HTTPStatus: 403, ErrorClass: "http_status", ErrorMessage: "forbidden"}
+			if _, err := db.RecordScrapeRun(ctx, failed); err != nil {
+				t.Fatalf("error recording run. Err: %v", err)
+			}
+			added, err := db.AddItems(items, topic, "")
+			if err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+			ok := database.ScrapeRun{Topic: topic, QueryParams: "search_text=" + topic, Domain: "co.uk",
+				StartedAt: started, FinishedAt: time.Now(), Pages: 1, ItemsNew: added.New, HTTPStatus: 200}
+			if _, err := db.RecordScrapeRun(ctx, ok); err != nil {
+				t.Fatalf("error recording run. Err: %v", err)
+			}
+
+			runs, err := db.ListScrapeRuns(ctx, database.RunQuery{WorkspaceID: database.AllWorkspaces, TopicID: added.TopicID})
+			if err != nil {
+				t.Fatalf("error listing runs. Err: %v", err)
+			}
+			if len(runs) != 1 || runs[0].ItemsNew != len(items) || runs[0].ErrorClass != "" {
+				t.Fatalf("expected the successful run for topic %d; got %+v", added.TopicID, runs)
+			}
+
+			all, err := db.ListScrapeRuns(ctx, database.RunQuery{WorkspaceID: database.AllWorkspaces})
+			if err != nil {
+				t.Fatalf("error listing runs. Err: %v", err)
+			}
+			if len(all) != 2 || all[1].ErrorClass != "http_status" || all[1].HTTPStatus != 403 || all[1].TopicID != 0 {
+				t.Errorf("expected the failed run last, without topic; got %+v", all)
+			}
+
+			older, err := db.ListScrapeRuns(ctx, database.RunQuery{WorkspaceID: database.AllWorkspaces, Before: all[0].ID})
+			if err != nil {
+				t.Fatalf("error listing runs. Err: %v", err)
+			}
+			if len(older) != 1 || older[0].ID != all[1].ID {
+				t.Errorf("expected only run %d before %d; got %+v", all[1].ID, all[0].ID, older)
+			}
+		})
+	}
+}
+
+func TestMarketStats(t *testing.T) {
+	items := loadItems(t)
+	prices := make([]float64, 0, len(items))
+	for _, item := range items {
+		price, _ := strconv.ParseFloat(item.Price, 64)
+		prices = append(prices, price)
+	}
+	sort.Float64s(prices)
+
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			added, err := db.AddItems(items, "stats-"+name, "")
+			if err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+
+			stats, err := db.MarketStats(ctx, database.StatsQuery{WorkspaceID: database.AllWorkspaces, TopicID: added.TopicID, Window: 7 * 24 * time.Hour})
+			if err != nil {
+				t.Fatalf("error computing stats. Err: %v", err)
+			}
+			if stats.Count != int64(len(items)) || stats.NewListings != int64(len(items)) {
+				t.Errorf("expected %d items, all new; got %+v", len(items), stats)
+			}
+			if stats.Price.Min != prices[0] || stats.Price.Max != prices[len(prices)-1] {
+				t.Errorf("expected prices %v-%v; got %+v", prices[0], prices[len(prices)-1], stats.Price)
+			}
+			median := (prices[len(prices)/2-1] + prices[len(prices)/2]) / 2
+			if stats.Price.Median != median {
+				t.Errorf("expected median %v; got %v", median, stats.Price.Median)
+			}
+			if !(stats.Price.P25 <= stats.Price.Median && stats.Price.Median <= stats.Price.P75) {
+				t.Errorf("expected ordered percentiles; got %+v", stats.Price)
+			}
+			if stats.PrivateShare != 1 || stats.BusinessShare != 0 {
+				t.Errorf("expected only private sellers; got %v/%v", stats.PrivateShare, stats.BusinessShare)
+			}
+
+			brand, err := db.MarketStats(ctx, database.StatsQuery{WorkspaceID: database.AllWorkspaces, Brand: "radley"})
+			if err != nil {
+				t.Fatalf("error computing brand stats. Err: %v", err)
+			}
+			if brand.Count == 0 || brand.Count >= stats.Count {
+				t.Errorf("expected a subset for radley; got %d", brand.Count)
+			}
+
+			// Items priced in euros are aggregated on their own.
+			euros := make([]vintedscraper.Item, 0, int(brand.Count))
+			for _, item := range items {
+				if item.BrandTitle == "Radley" {
+					item.ID += 1_000_000
+					item.Price, item.Currency = "1000", "EUR"
+					euros = append(euros, item)
+				}
+			}
+			if _, err := db.AddItems(euros, "stats-euros-"+name, ""); err != nil {
+				t.Fatalf("error adding items. Err: %v", err)
+			}
+			pounds, err := db.MarketStats(ctx, database.StatsQuery{WorkspaceID: database.AllWorkspaces, Brand: "radley"})
+			if err != nil {
+				t.Fatalf("error computing brand stats. Err: %v", err)
+			}
+			if pounds != brand {
+				t.Errorf("expected the euro prices left out; got %+v, want %+v", pounds, brand)
+			}
+			inEuros, err := db.MarketStats(ctx, database.StatsQuery{WorkspaceID: database.AllWorkspaces, Brand: "radley", Currency: "eur"})
+			if err != nil {
+				t.Fatalf("error computing brand stats. Err: %v", err)
+			}
+			if inEuros.Count != int64(len(euros)) || inEuros.Price.Min != 1000 || inEuros.Price.Max != 1000 {
+				t.Errorf("expected %d items at 1000 EUR; got %+v", len(euros), inEuros)
+			}
+
+			if _, err := db.MarketStats(ctx, database.StatsQuery{}); err == nil {
+				t.Errorf("expected an error without topic or brand")
+			}
+		})
+	}
+}
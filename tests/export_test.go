@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/export"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExport(t *testing.T) {
+	items := loadItems(t)
+	db := backends(t)["sqlite"]
+	if _, err := db.AddItems(items, "export", ""); err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+	ctx := context.Background()
+	all := database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: "export"}
+
+	var buf bytes.Buffer
+	if err := export.Write(ctx, db, &buf, export.Items, export.CSV, all); err != nil {
+		t.Fatalf("error exporting csv. Err: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading csv. Err: %v", err)
+	}
+	if len(records) != len(items)+1 || records[0][0] != "id" {
+		t.Errorf("expected a header and %d rows; got %d rows starting %v", len(items), len(records), records[0])
+	}
+
+	buf.Reset()
+	if err := export.Write(ctx, db, &buf, export.PriceHistory, export.NDJSON, all); err != nil {
+		t.Fatalf("error exporting ndjson. Err: %v", err)
+	}
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var point map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+			t.Fatalf("invalid ndjson line %q. Err: %v", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != len(items) {
+		t.Errorf("expected %d price points; got %d", len(items), lines)
+	}
+
+	buf.Reset()
+	radley := database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: "export", Brand: "Radley"}
+	if err := export.Write(ctx, db, &buf, export.Photos, export.Parquet, radley); err != nil {
+		t.Fatalf("error exporting parquet. Err: %v", err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("error reading parquet. Err: %v", err)
+	}
+	page, err := db.QueryItems(ctx, radley)
+	if err != nil {
+		t.Fatalf("error querying items. Err: %v", err)
+	}
+	if file.NumRows() != int64(len(page.Items)) || file.NumRows() == 0 {
+		t.Errorf("expected %d photos; got %d", len(page.Items), file.NumRows())
+	}
+	if _, ok := file.Schema().Lookup("dominant_color"); !ok {
+		t.Errorf("expected a dominant_color column in %v", file.Schema())
+	}
+}
+
+func TestExportDoesNotHoldWriters(t *testing.T) {
+	items := loadItems(t)
+	db := backends(t)["sqlite"]
+	if _, err := db.AddItems(items, "export", ""); err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+
+	// A client reading an export slowly must not keep scrapes from writing.
+	written := false
+	err := db.StreamItems(context.Background(), database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: "export"}, func(database.StoredItem) error {
+		if written {
+			return nil
+		}
+		written = true
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err := db.Exec(ctx, "UPDATE Topic SET last_read_at = CURRENT_TIMESTAMP")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected a write during the export to go through. Err: %v", err)
+	}
+}
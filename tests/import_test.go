@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/importer"
+)
+
+func TestImport(t *testing.T) {
+	items := loadItems(t)
+	db := backends(t)["sqlite"]
+
+	imp := importer.New(db, "imported", "fr")
+	imp.ImportFile("items.json")
+	report := imp.Report()
+	if report.Files != 1 || report.Responses != 1 || report.Inserted != len(items) || len(report.Errors) != 0 {
+		t.Fatalf("expected %d inserted items from one response; got %+v", len(items), report)
+	}
+
+	// NDJSON of single items: one known item, one without an id, one that is not an item.
+	known, _ := json.Marshal(items[0])
+	ndjson := string(known) + "\n" + `{"title":"no id","price":"1.0"}` + "\n" + `"garbage"` + "\n"
+	imp = importer.New(db, "imported", "fr")
+	if err := imp.Import(strings.NewReader(ndjson)); err != nil {
+		t.Fatalf("error importing ndjson. Err: %v", err)
+	}
+	report = imp.Report()
+	if report.Inserted != 0 || report.Updated != 1 || report.Rejected != 2 {
+		t.Errorf("expected 1 updated and 2 rejected; got %+v", report)
+	}
+
+	imp = importer.New(db, "imported", "fr")
+	imp.ImportFile("missing.json")
+	if report := imp.Report(); len(report.Errors) != 1 {
+		t.Errorf("expected an error for a missing file; got %+v", report)
+	}
+
+	page, err := db.QueryItems(context.Background(), database.ItemQuery{WorkspaceID: database.AllWorkspaces, Topic: "imported", Limit: database.MaxItemLimit})
+	if err != nil {
+		t.Fatalf("error querying items. Err: %v", err)
+	}
+	if len(page.Items) != len(items) {
+		t.Errorf("expected %d imported items; got %d", len(items), len(page.Items))
+	}
+	results, err := db.SearchItems(context.Background(), database.SearchQuery{WorkspaceID: database.AllWorkspaces, Text: "radley", Domain: "fr"})
+	if err != nil || len(results) == 0 {
+		t.Errorf("expected imported items under domain fr; got %d, %v", len(results), err)
+	}
+}
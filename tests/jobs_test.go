@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/server"
+)
+
+type scrapeJob struct {
+	ID           int64  `json:"id"`
+	Query        string `json:"q"`
+	Pages        int    `json:"pages"`
+	Status       string `json:"status"`
+	TopicID      int64  `json:"topic_id"`
+	PagesFetched int    `json:"pages_fetched"`
+	ItemsNew     int    `json:"items_new"`
+	Errors       int    `json:"errors"`
+	LastError    string `json:"last_error"`
+	Attempts     int    `json:"attempts"`
+	MaxAttempts  int    `json:"max_attempts"`
+}
+
+func TestScrapeJobs(t *testing.T) {
+	t.Setenv("STREAM_HEARTBEAT", "20ms")
+	t.Setenv("JOB_RETRY_BACKOFF", "10ms")
+	t.Setenv("JOB_MAX_ATTEMPTS", "2")
+	items := loadItems(t)
+	fake := &fakeSearch{items: items, perPage: 10}
+	db := backends(t)["sqlite"]
+	srv := server.New(db, server.WithSearch(fake.search))
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+
+	for _, body := range []string{
+		`{"pages": 2}`,
+		`{"q": "bags", "pages": 101}`,
+		`{"q": "bags", "order": "cheapest"}`,
+		`{"q": "bags", "depth": 2}`,
+	} {
+		postJSON(t, ts.URL+"/v1/jobs", body, http.StatusBadRequest, nil)
+	}
+
+	getJob := func(id int64) scrapeJob {
+		t.Helper()
+		var job scrapeJob
+		if err := json.Unmarshal(getV1(t, fmt.Sprintf("%s/v1/jobs/%d", ts.URL, id), http.StatusOK).Data, &job); err != nil {
+			t.Fatalf("error decoding job. Err: %v", err)
+		}
+		return job
+	}
+	waitJob := func(id int64) scrapeJob {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		job := getJob(id)
+		for (job.Status == "queued" || job.Status == "running") && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			job = getJob(id)
+		}
+		return job
+	}
+	claim := func(wantID int64) database.ScrapeJob {
+		t.Helper()
+		time.Sleep(5 * time.Millisecond)
+		job, err := db.ClaimScrapeJob(context.Background(), "crashed-worker", time.Millisecond)
+		if err != nil || job.ID != wantID {
+			t.Fatalf("expected to claim job %d; got %+v, %v", wantID, job, err)
+		}
+		return job
+	}
+
+	// A queued job can be cancelled, more than once.
+	var cancelled scrapeJob
+	postJSON(t, ts.URL+"/v1/jobs", `{"q": "  Bags "}`, http.StatusAccepted, &cancelled)
+	if cancelled.Query != "bags" || cancelled.Pages != 1 || cancelled.Status != "queued" || cancelled.MaxAttempts != 2 {
+		t.Fatalf("expected a queued job of one page; got %+v", cancelled)
+	}
+	cancelURL := fmt.Sprintf("%s/v1/jobs/%d/cancel", ts.URL, cancelled.ID)
+	postJSON(t, cancelURL, "", http.StatusOK, &cancelled)
+	postJSON(t, cancelURL, "", http.StatusOK, &cancelled)
+	if cancelled.Status != "cancelled" {
+		t.Errorf("expected the job cancelled; got %+v", cancelled)
+	}
+
+	// A job whose worker died after its first page is claimed again once
+	// its lease expires, and resumes from the second page.
+	var resumed, orphaned, deep scrapeJob
+	postJSON(t, ts.URL+"/v1/jobs", `{"q": "bags", "pages": 2}`, http.StatusAccepted, &resumed)
+	claimed := claim(resumed.ID)
+	claimed.PagesFetched = 1
+	if err := db.UpdateScrapeJob(context.Background(), claimed); err != nil {
+		t.Fatalf("error updating job. Err: %v", err)
+	}
+	// A job whose worker died on its last attempt is dead-lettered; its
+	// priority puts it first in the queue.
+	postJSON(t, ts.URL+"/v1/jobs", `{"q": "bags", "priority": 10}`, http.StatusAccepted, &orphaned)
+	claim(orphaned.ID)
+	claim(orphaned.ID)
+	// A job deeper than the search stops at its last page.
+	postJSON(t, ts.URL+"/v1/jobs", `{"q": "bags", "pages": 10}`, http.StatusAccepted, &deep)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.RunJobs(ctx)
+
+	if got := waitJob(resumed.ID); got.Status != "succeeded" || got.PagesFetched != 2 || got.ItemsNew != 10 || got.Attempts != 2 {
+		t.Errorf("expected the job to fetch its second page on a second attempt; got %+v", got)
+	}
+	if got := waitJob(orphaned.ID); got.Status != "dead" || got.LastError != "lease expired" || got.PagesFetched != 0 {
+		t.Errorf("expected the orphaned job dead-lettered; got %+v", got)
+	}
+	pages := (len(items) + fake.perPage - 1) / fake.perPage
+	got := waitJob(deep.ID)
+	if got.Status != "succeeded" || got.PagesFetched != pages || got.TopicID == 0 {
+		t.Errorf("expected the job to stop after %d pages; got %+v", pages, got)
+	}
+	if calls := int(fake.calls.Load()); calls != 1+pages {
+		t.Errorf("expected %d scrapes; got %d", 1+pages, calls)
+	}
+	postJSON(t, fmt.Sprintf("%s/v1/jobs/%d/cancel", ts.URL, deep.ID), "", http.StatusConflict, nil)
+	postJSON(t, fmt.Sprintf("%s/v1/jobs/%d/retry", ts.URL, deep.ID), "", http.StatusConflict, nil)
+
+	// The first page is saved as the search, so it is now served from the database.
+	calls := fake.calls.Load()
+	getSearch(t, ts.URL, "", http.StatusOK)
+	if fake.calls.Load() != calls {
+		t.Errorf("expected the search to be served without scraping")
+	}
+
+	// A failing job is retried, then dead-lettered; a retry from the
+	// dead-letter queue starts it over.
+	fake.err = errors.New("vinted is down")
+	var failed scrapeJob
+	postJSON(t, ts.URL+"/v1/jobs", `{"q": "shoes", "pages": 3}`, http.StatusAccepted, &failed)
+	if got := waitJob(failed.ID); got.Status != "dead" || got.Errors != 2 || got.Attempts != 2 || got.LastError == "" || got.PagesFetched != 0 {
+		t.Errorf("expected the job dead after two failed attempts; got %+v", got)
+	}
+	fake.err = nil
+	postJSON(t, fmt.Sprintf("%s/v1/jobs/%d/retry", ts.URL, failed.ID), "", http.StatusOK, nil)
+	if got := waitJob(failed.ID); got.Status != "succeeded" || got.PagesFetched != 3 || got.Errors != 2 {
+		t.Errorf("expected the retried job to succeed; got %+v", got)
+	}
+
+	var jobs []scrapeJob
+	if err := json.Unmarshal(getV1(t, ts.URL+"/v1/jobs?status=dead&kind=api", http.StatusOK).Data, &jobs); err != nil {
+		t.Fatalf("error decoding jobs. Err: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != orphaned.ID {
+		t.Errorf("expected the orphaned job in the dead-letter queue; got %+v", jobs)
+	}
+	getV1(t, ts.URL+"/v1/jobs?status=failed", http.StatusBadRequest)
+	getV1(t, ts.URL+"/v1/jobs?kind=cron", http.StatusBadRequest)
+	getV1(t, ts.URL+"/v1/jobs/999", http.StatusNotFound)
+	if _, err := db.GetScrapeJob(context.Background(), 999); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+}
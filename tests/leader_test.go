@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/server"
+)
+
+func TestLeaderLock(t *testing.T) {
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			lock, err := db.AcquireLeadership(ctx, "test", "first")
+			if err != nil {
+				t.Fatalf("error taking the lock. Err: %v", err)
+			}
+			if _, err := db.AcquireLeadership(ctx, "test", "second"); !errors.Is(err, database.ErrNotLeader) {
+				t.Fatalf("expected ErrNotLeader; got %v", err)
+			}
+			if leader, err := db.Leader(ctx, "test"); err != nil || leader != "first" {
+				t.Errorf("expected first to lead; got %q, %v", leader, err)
+			}
+			if err := lock.Check(ctx); err != nil {
+				t.Errorf("expected the lock held; got %v", err)
+			}
+			if err := lock.Release(); err != nil {
+				t.Fatalf("error releasing the lock. Err: %v", err)
+			}
+			if leader, err := db.Leader(ctx, "test"); err != nil || leader != "" {
+				t.Errorf("expected no leader; got %q, %v", leader, err)
+			}
+			lock, err = db.AcquireLeadership(ctx, "test", "second")
+			if err != nil {
+				t.Fatalf("error taking the released lock. Err: %v", err)
+			}
+			lock.Release()
+		})
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	t.Setenv("LEADER_CHECK_INTERVAL", "20ms")
+	db := backends(t)["sqlite"]
+
+	type replica struct {
+		srv     *server.Server
+		url     string
+		running atomic.Int32
+		stop    context.CancelFunc
+	}
+	replicas := make([]*replica, 2)
+	for i := range replicas {
+		r := &replica{srv: server.New(db)}
+		r.srv.RegisterLeaderTask("test", func(ctx context.Context) {
+			r.running.Add(1)
+			defer r.running.Add(-1)
+			<-ctx.Done()
+		})
+		ts := httptest.NewServer(r.srv.RegisterRoutes())
+		t.Cleanup(ts.Close)
+		r.url = ts.URL
+		var ctx context.Context
+		ctx, r.stop = context.WithCancel(context.Background())
+		t.Cleanup(r.stop)
+		go r.srv.RunLeaderElection(ctx)
+		replicas[i] = r
+	}
+
+	health := func(r *replica) map[string]string {
+		t.Helper()
+		resp, err := http.Get(r.url + "/health")
+		if err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+		defer resp.Body.Close()
+		var health map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+			t.Fatalf("error decoding health. Err: %v", err)
+		}
+		return health
+	}
+	// waitLeader waits for exactly one of replicas to run the task and
+	// returns it.
+	waitLeader := func(replicas ...*replica) *replica {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			var leaders []*replica
+			for _, r := range replicas {
+				if r.running.Load() > 0 {
+					leaders = append(leaders, r)
+				}
+			}
+			if len(leaders) > 1 {
+				t.Fatalf("expected one leader; got %d", len(leaders))
+			}
+			if len(leaders) == 1 {
+				return leaders[0]
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected a leader to be elected")
+		return nil
+	}
+
+	leader := waitLeader(replicas...)
+	follower := replicas[0]
+	if follower == leader {
+		follower = replicas[1]
+	}
+	leaderHealth, followerHealth := health(leader), health(follower)
+	if leaderHealth["is_leader"] != "true" || leaderHealth["leader"] != leaderHealth["instance"] {
+		t.Errorf("expected the leader to report itself; got %v", leaderHealth)
+	}
+	if followerHealth["is_leader"] != "false" || followerHealth["leader"] != leaderHealth["instance"] ||
+		followerHealth["instance"] == leaderHealth["instance"] {
+		t.Errorf("expected the follower to report the leader; got %v", followerHealth)
+	}
+
+	// Once the leader stops its tasks stop, and the follower takes over.
+	leader.stop()
+	if got := waitLeader(follower); got != follower {
+		t.Fatalf("expected the follower to take over")
+	}
+	if leader.running.Load() != 0 {
+		t.Errorf("expected the old leader's task stopped")
+	}
+	if h := health(follower); h["is_leader"] != "true" {
+		t.Errorf("expected the follower to lead; got %v", h)
+	}
+}
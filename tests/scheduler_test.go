@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/server"
+)
+
+type topicSchedule struct {
+	Enabled         bool   `json:"enabled"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+	Priority        int    `json:"priority"`
+	LastStatus      string `json:"last_status"`
+	LastJobID       int64  `json:"last_job_id"`
+	Runs            int    `json:"runs"`
+	Idle            bool   `json:"idle"`
+	NextRunAt       string `json:"next_run_at"`
+	Scheduler       string `json:"scheduler"`
+}
+
+func TestScheduler(t *testing.T) {
+	t.Setenv("SCHEDULE_INTERVAL", "50ms")
+	t.Setenv("SCHEDULE_JITTER", "0")
+	t.Setenv("SCHEDULE_TOPICS", "shoes=1h/5")
+	t.Setenv("SCRAPE_RATE_LIMIT", "1000/1s")
+	items := loadItems(t)
+	fake := &fakeSearch{items: items}
+	db := backends(t)["sqlite"]
+	srv := server.New(db, server.WithSearch(fake.search))
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+
+	// bags is read through the API; shoes is imported and never read.
+	getSearch(t, ts.URL, "", http.StatusOK)
+	bags := topicIDs(t, ts.URL)["bags"]
+	ingest, err := db.AddItems(items[:5], "shoes", "")
+	if err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+	scheduleAt := func(url string, topicID int64) topicSchedule {
+		t.Helper()
+		var st topicSchedule
+		body := getV1(t, fmt.Sprintf("%s/v1/topics/%d/schedule", url, topicID), http.StatusOK)
+		if err := json.Unmarshal(body.Data, &st); err != nil {
+			t.Fatalf("error decoding schedule. Err: %v", err)
+		}
+		return st
+	}
+	schedule := func(topicID int64) topicSchedule {
+		t.Helper()
+		return scheduleAt(ts.URL, topicID)
+	}
+	if st := schedule(bags); st.Enabled {
+		t.Errorf("expected the schedule disabled while the scheduler is stopped; got %+v", st)
+	}
+
+	// boots is only a saved search, scraped in its own order and currency
+	// although nobody reads it.
+	saved, err := db.CreateSavedSearch(context.Background(), database.SavedSearch{Text: "boots", Order: "price_low_to_high", Currency: "EUR"})
+	if err != nil {
+		t.Fatalf("error creating saved search. Err: %v", err)
+	}
+	savedSig := database.NewSearchSignature(saved.Text, saved.Order, "", saved.Currency, nil).String()
+	scraped := func() bool {
+		_, err := db.GetSearch(context.Background(), savedSig)
+		return err == nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.RunScheduler(ctx)
+	go srv.RunJobs(ctx)
+
+	calls := fake.calls.Load()
+	deadline := time.Now().Add(5 * time.Second)
+	for (fake.calls.Load() < calls+2 || schedule(ingest.TopicID).LastStatus == "" || !scraped()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := fake.calls.Load(); got < calls+2 {
+		t.Fatalf("expected the read topic to be refreshed twice; got %d scrapes", got-calls)
+	}
+	st := schedule(bags)
+	if !st.Enabled || st.LastStatus != "queued" || st.LastJobID == 0 || st.Runs == 0 || st.Idle {
+		t.Errorf("expected bags refreshed on schedule; got %+v", st)
+	}
+	if !scraped() {
+		t.Errorf("expected the saved search to be scraped on schedule")
+	}
+	var jobs []struct {
+		Query       string `json:"q"`
+		Kind        string `json:"kind"`
+		Order       string `json:"order"`
+		Currency    string `json:"currency"`
+		WorkspaceID int64  `json:"workspace_id"`
+	}
+	if err := json.Unmarshal(getV1(t, ts.URL+"/v1/jobs?kind=schedule", http.StatusOK).Data, &jobs); err != nil {
+		t.Fatalf("error decoding jobs. Err: %v", err)
+	}
+	var queries []string
+	for _, job := range jobs {
+		queries = append(queries, job.Query)
+		if job.Query == "boots" && (job.Order != "price_low_to_high" || job.Currency != "EUR" || job.WorkspaceID != database.DefaultWorkspace) {
+			t.Errorf("expected the saved search's order, currency and workspace; got %+v", job)
+		}
+	}
+	if !slices.Contains(queries, "bags") || !slices.Contains(queries, "boots") {
+		t.Errorf("expected the refreshes to go through the job queue; got %+v", jobs)
+	}
+
+	// The unread topic is skipped, and keeps its override.
+	st = schedule(ingest.TopicID)
+	if st.LastStatus != "idle" || st.Runs != 0 || !st.Idle || st.IntervalSeconds != 3600 || st.Priority != 5 {
+		t.Errorf("expected shoes idle with its override; got %+v", st)
+	}
+
+	getV1(t, ts.URL+"/v1/topics/999/schedule", http.StatusNotFound)
+
+	// A replica that does not run the scheduler reports what it saved, and
+	// the next run while the replica that saved it leads.
+	replica := httptest.NewServer(server.New(db, server.WithSearch(fake.search)).RegisterRoutes())
+	t.Cleanup(replica.Close)
+	st = schedule(bags)
+	replicated := scheduleAt(replica.URL, bags)
+	if replicated.Scheduler == "" || replicated.Scheduler != st.Scheduler || replicated.LastStatus != "queued" || replicated.LastJobID == 0 || replicated.Runs == 0 {
+		t.Errorf("expected the saved schedule of %+v; got %+v", st, replicated)
+	}
+	if replicated.Enabled || replicated.NextRunAt != "" {
+		t.Errorf("expected the schedule disabled without a leader; got %+v", replicated)
+	}
+	lock, err := db.AcquireLeadership(context.Background(), "vinted-scraper/leader", st.Scheduler)
+	if err != nil {
+		t.Fatalf("error taking leadership. Err: %v", err)
+	}
+	t.Cleanup(func() { lock.Release() })
+	if replicated := scheduleAt(replica.URL, bags); !replicated.Enabled || replicated.NextRunAt == "" {
+		t.Errorf("expected the leader's next run; got %+v", replicated)
+	}
+}
+
+// topicIDs returns the ids of the topics listed by /v1/topics by name.
+func topicIDs(t *testing.T, url string) map[string]int64 {
+	t.Helper()
+	var topics []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(getV1(t, url+"/v1/topics", http.StatusOK).Data, &topics); err != nil {
+		t.Fatalf("error decoding topics. Err: %v", err)
+	}
+	ids := map[string]int64{}
+	for _, topic := range topics {
+		ids[topic.Name] = topic.ID
+	}
+	return ids
+}
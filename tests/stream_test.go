@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/server"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// sseEvent is one event read from a Server-Sent Events stream; comments
+// such as heartbeats are read as events with only a comment.
+type sseEvent struct {
+	ID      string
+	Event   string
+	Data    string
+	Comment string
+}
+
+// openStream connects to an event stream and returns its events as they
+// arrive. The stream is closed when the test ends.
+func openStream(t *testing.T, url string, header http.Header) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error opening stream. Err: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("expected an event stream; got %v %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan sseEvent, 1000)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				events <- event
+				event = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				event.Comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id: "):
+				event.ID = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				event.Event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				event.Data = line[6:]
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent returns the next event that is not a comment.
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("stream closed")
+			}
+			if event.Event != "" {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for an event")
+		}
+	}
+}
+
+func TestTopicStream(t *testing.T) {
+	t.Setenv("STREAM_HEARTBEAT", "20ms")
+	items := loadItems(t)
+	fake := &fakeSearch{items: items}
+	ts, topicID := newTestServer(t, "bags", server.WithSearch(fake.search))
+	url := fmt.Sprintf("%s/v1/topics/%d/stream", ts.URL, topicID)
+
+	// A new stream starts after the events already recorded, with heartbeats.
+	live := openStream(t, url, nil)
+	for event := range live {
+		if event.Comment == "heartbeat" {
+			break
+		}
+		if event.Event != "" {
+			t.Fatalf("expected no past events; got %+v", event)
+		}
+	}
+
+	// Halve the price of the first item and ingest again.
+	cheaper := append([]vintedscraper.Item(nil), items...)
+	price, _ := strconv.ParseFloat(cheaper[0].Price, 64)
+	cheaper[0].Price = strconv.FormatFloat(price/2, 'f', 2, 64)
+	fake.items = cheaper
+	getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+
+	event := nextEvent(t, live)
+	if event.Event != "price_drop" || event.ID == "" {
+		t.Fatalf("expected a price drop; got %+v", event)
+	}
+	var data struct {
+		Kind          string `json:"kind"`
+		PreviousPrice string `json:"previous_price"`
+		Item          struct {
+			ID int `json:"id"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+		t.Fatalf("error decoding event. Err: %v", err)
+	}
+	if data.Item.ID != items[0].ID || data.Kind != "price_drop" || data.PreviousPrice == "" {
+		t.Errorf("expected a price drop of item %d; got %+v", items[0].ID, data)
+	}
+
+	// Resuming from the start replays the new items in order, then the drop.
+	replay := openStream(t, url, http.Header{"Last-Event-ID": {"0"}})
+	var last int64
+	count := 0
+	for {
+		event := nextEvent(t, replay)
+		id, _ := strconv.ParseInt(event.ID, 10, 64)
+		if id <= last {
+			t.Fatalf("expected increasing event ids; got %d after %d", id, last)
+		}
+		last = id
+		if event.Event == "price_drop" {
+			break
+		}
+		count++
+	}
+	if count != len(items) {
+		t.Errorf("expected %d new item events before the drop; got %d", len(items), count)
+	}
+
+	getV1(t, url+"?last_event_id=abc", http.StatusBadRequest)
+	getV1(t, fmt.Sprintf("%s/v1/topics/%d/stream", ts.URL, topicID+1), http.StatusNotFound)
+}
@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vinted-scraper/internal/server"
+)
+
+// webhookReceiver is a local webhook endpoint that checks signatures and
+// fails the first failures requests it gets.
+type webhookReceiver struct {
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	payloads []webhookPayload
+	invalid  int
+}
+
+type webhookPayload struct {
+	Type      string `json:"type"`
+	WebhookID int64  `json:"webhook_id"`
+	Event     *struct {
+		ID   int64  `json:"id"`
+		Kind string `json:"kind"`
+		Item struct {
+			ID int `json:"id"`
+		} `json:"item"`
+	} `json:"event"`
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if !validSignature(rcv.secret, r.Header.Get("X-Webhook-Signature"), body) {
+		rcv.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var payload webhookPayload
+	_ = json.Unmarshal(body, &payload)
+	rcv.payloads = append(rcv.payloads, payload)
+}
+
+func (rcv *webhookReceiver) received() []webhookPayload {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]webhookPayload(nil), rcv.payloads...)
+}
+
+// validSignature checks an X-Webhook-Signature the way a receiver would.
+func validSignature(secret, header string, body []byte) bool {
+	var ts, sum string
+	for _, part := range strings.Split(header, ",") {
+		if v, ok := strings.CutPrefix(part, "t="); ok {
+			ts = v
+		}
+		if v, ok := strings.CutPrefix(part, "sha256="); ok {
+			sum = v
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return ts != "" && hmac.Equal([]byte(sum), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+// postJSON POSTs body to url and decodes the data of the envelope into v.
+func postJSON(t *testing.T, url, body string, wantStatus int, v interface{}) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("POST %s: expected status %d; got %v", url, wantStatus, resp.Status)
+	}
+	if v != nil {
+		envelope := struct{ Data interface{} }{Data: v}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			t.Fatalf("error decoding envelope. Err: %v", err)
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Setenv("STREAM_HEARTBEAT", "20ms")
+	t.Setenv("WEBHOOK_BACKOFF", "10ms")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	// The receivers listen on loopback, which webhooks may not reach by default.
+	t.Setenv("WEBHOOK_ALLOWED_NETWORKS", "127.0.0.1")
+	items := loadItems(t)
+	fake := &fakeSearch{items: items}
+	db := backends(t)["sqlite"]
+	srv := server.New(db, server.WithSearch(fake.search))
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.RunWebhooks(ctx)
+
+	rcv := &webhookReceiver{secret: "s3cret", failures: 1}
+	receiver := httptest.NewServer(rcv)
+	t.Cleanup(receiver.Close)
+
+	for _, body := range []string{
+		`{"url": "ftp://example.com/hook"}`,
+		`{"url": "/relative"}`,
+		`{"url": "http://example.com", "event_types": ["sold"]}`,
+		`{"url": "http://example.com", "topic_id": 999}`,
+		`{"url": "http://example.com", "unknown": true}`,
+	} {
+		postJSON(t, ts.URL+"/v1/webhooks", body, http.StatusBadRequest, nil)
+	}
+
+	var hook struct {
+		ID         int64    `json:"id"`
+		Secret     string   `json:"secret"`
+		EventTypes []string `json:"event_types"`
+	}
+	postJSON(t, ts.URL+"/v1/webhooks", fmt.Sprintf(`{"url": %q, "secret": "s3cret"}`, receiver.URL), http.StatusCreated, &hook)
+	if hook.ID == 0 || hook.Secret != "s3cret" || len(hook.EventTypes) != 1 || hook.EventTypes[0] != "new" {
+		t.Fatalf("expected a webhook for new items; got %+v", hook)
+	}
+	hookURL := fmt.Sprintf("%s/v1/webhooks/%d", ts.URL, hook.ID)
+	if body := getV1(t, hookURL, http.StatusOK); strings.Contains(string(body.Data), "s3cret") {
+		t.Errorf("expected the secret to be hidden; got %s", body.Data)
+	}
+
+	// The first scrape stores every item as new; the receiver fails the
+	// first attempt, so the first item is retried.
+	getSearch(t, ts.URL, "", http.StatusOK)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rcv.received()) < len(items) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	received := rcv.received()
+	if len(received) != len(items) {
+		t.Fatalf("expected %d deliveries; got %d", len(items), len(received))
+	}
+	for i, payload := range received {
+		if payload.Type != "new" || payload.WebhookID != hook.ID || payload.Event == nil || payload.Event.Item.ID != items[i].ID {
+			t.Fatalf("delivery %d: expected new item %d; got %+v", i, items[i].ID, payload)
+		}
+	}
+
+	var deliveries []struct {
+		EventID    int64 `json:"event_id"`
+		Attempt    int   `json:"attempt"`
+		StatusCode int   `json:"status_code"`
+		Success    bool  `json:"success"`
+	}
+	body := getV1(t, hookURL+"/deliveries?limit=500", http.StatusOK)
+	if err := json.Unmarshal(body.Data, &deliveries); err != nil {
+		t.Fatalf("error decoding deliveries. Err: %v", err)
+	}
+	if len(deliveries) != len(items)+1 {
+		t.Fatalf("expected %d attempts; got %d", len(items)+1, len(deliveries))
+	}
+	first, retry := deliveries[len(deliveries)-1], deliveries[len(deliveries)-2]
+	if first.Success || first.StatusCode != http.StatusServiceUnavailable || !retry.Success || retry.Attempt != 2 || retry.EventID != first.EventID {
+		t.Errorf("expected a failed attempt then a successful retry; got %+v then %+v", first, retry)
+	}
+
+	// The test endpoint makes one signed attempt and returns it.
+	var test struct {
+		EventType string `json:"event_type"`
+		Success   bool   `json:"success"`
+	}
+	postJSON(t, hookURL+"/test", "", http.StatusOK, &test)
+	if test.EventType != "test" || !test.Success {
+		t.Errorf("expected a successful test delivery; got %+v", test)
+	}
+	if rcv.invalid != 0 {
+		t.Errorf("expected every signature to verify; got %d invalid", rcv.invalid)
+	}
+
+	deleteURL(t, hookURL, http.StatusNoContent)
+	getV1(t, hookURL, http.StatusNotFound)
+	getV1(t, hookURL+"/deliveries", http.StatusNotFound)
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	ts, _ := newTestServer(t, "bags")
+	rcv := &webhookReceiver{secret: "s3cret"}
+	receiver := httptest.NewServer(rcv)
+	t.Cleanup(receiver.Close)
+
+	for _, address := range []string{"127.0.0.1:1", "10.0.0.1", "[::1]", "169.254.169.254", "0.0.0.0"} {
+		postJSON(t, ts.URL+"/v1/webhooks", fmt.Sprintf(`{"url": "http://%s/hook"}`, address), http.StatusBadRequest, nil)
+	}
+
+	// A host name is only resolved at delivery, which refuses loopback.
+	var hook struct{ ID int64 }
+	local := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	postJSON(t, ts.URL+"/v1/webhooks", fmt.Sprintf(`{"url": %q, "secret": "s3cret"}`, local), http.StatusCreated, &hook)
+	var test struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	postJSON(t, fmt.Sprintf("%s/v1/webhooks/%d/test", ts.URL, hook.ID), "", http.StatusOK, &test)
+	if test.Success || !strings.Contains(test.Error, "not public") || len(rcv.received()) != 0 {
+		t.Errorf("expected the delivery to loopback refused; got %+v and %d deliveries", test, len(rcv.received()))
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"vinted-scraper/internal/server"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+// wsMessage is a message from the WebSocket endpoint.
+type wsMessage struct {
+	Type          string   `json:"type"`
+	Subscription  string   `json:"subscription"`
+	Subscriptions []string `json:"subscriptions"`
+	Error         string   `json:"error"`
+	Event         struct {
+		Kind string `json:"kind"`
+		Item struct {
+			ID int `json:"id"`
+		} `json:"item"`
+	} `json:"event"`
+}
+
+func TestWebSocket(t *testing.T) {
+	t.Setenv("STREAM_HEARTBEAT", "50ms")
+	items := loadItems(t)
+	fake := &fakeSearch{items: items}
+	ts, topicID := newTestServer(t, "bags", server.WithSearch(fake.search))
+	getSearch(t, ts.URL, "", http.StatusOK)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/v1/ws", nil)
+	if err != nil {
+		t.Fatalf("error dialing WebSocket. Err: %v (%v)", err, resp)
+	}
+	defer conn.Close()
+	read := func() wsMessage {
+		t.Helper()
+		var m wsMessage
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("error reading message. Err: %v", err)
+		}
+		return m
+	}
+	request := func(message string, wantType string) wsMessage {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatalf("error writing message. Err: %v", err)
+		}
+		m := read()
+		if m.Type != wantType {
+			t.Fatalf("%s: expected %s; got %+v", message, wantType, m)
+		}
+		return m
+	}
+
+	topic := request(fmt.Sprintf(`{"type": "subscribe", "topic_id": %d}`, topicID), "subscribed").Subscription
+	seller := request(fmt.Sprintf(`{"type": "subscribe", "seller_id": %d}`, items[1].User.ID), "subscribed").Subscription
+	search := request(`{"type": "subscribe", "search": {"q": "Bags"}}`, "subscribed").Subscription
+	if topic != fmt.Sprintf("topic:%d", topicID) || seller != fmt.Sprintf("seller:%d", items[1].User.ID) || !strings.HasPrefix(search, "search:") {
+		t.Errorf("unexpected subscription keys %q, %q, %q", topic, seller, search)
+	}
+	// A saved search's subscription only matches the items its filters pass.
+	var saved struct{ ID int64 }
+	postJSON(t, ts.URL+"/v1/saved-searches", fmt.Sprintf(`{"q": "bags", "filters": {"brand": %q}}`, items[0].BrandTitle), http.StatusCreated, &saved)
+	savedSearch := request(fmt.Sprintf(`{"type": "subscribe", "saved_search_id": %d}`, saved.ID), "subscribed").Subscription
+	if savedSearch != fmt.Sprintf("saved_search:%d", saved.ID) {
+		t.Errorf("unexpected subscription key %q", savedSearch)
+	}
+	request(`{"type": "subscribe", "saved_search_id": 999999}`, "error")
+	request(`{"type": "subscribe", "topic_id": 999999}`, "error")
+	request(`{"type": "subscribe", "topic_id": 1, "seller_id": 1}`, "error")
+	request(`{"type": "watch", "topic_id": 1}`, "error")
+	request(`not json`, "error")
+	request(fmt.Sprintf(`{"type": "subscribe", "seller_id": %d}`, items[2].User.ID+1_000_000), "subscribed")
+	request(fmt.Sprintf(`{"type": "unsubscribe", "seller_id": %d}`, items[2].User.ID+1_000_000), "unsubscribed")
+
+	// Halve the price of the first item and drop the second from the results.
+	changed := append([]vintedscraper.Item{items[0]}, items[2:]...)
+	price, _ := strconv.ParseFloat(changed[0].Price, 64)
+	changed[0].Price = strconv.FormatFloat(price/2, 'f', 2, 64)
+	fake.items = changed
+	getSearch(t, ts.URL, "&refresh=true", http.StatusOK)
+
+	var priceChange, removed *wsMessage
+	for priceChange == nil || removed == nil {
+		m := read()
+		switch {
+		case m.Type == "price_change" && m.Event.Item.ID == items[0].ID:
+			priceChange = &m
+		case m.Type == "removed" && m.Event.Item.ID == items[1].ID:
+			removed = &m
+		default:
+			t.Fatalf("unexpected message %+v", m)
+		}
+	}
+	if got := strings.Join(priceChange.Subscriptions, ","); got != strings.Join([]string{savedSearch, search, topic}, ",") {
+		t.Errorf("expected the price change to match the saved search, search and topic; got %s", got)
+	}
+	if got := strings.Join(removed.Subscriptions, ","); !strings.Contains(got, seller) || !strings.Contains(got, search) || strings.Contains(got, savedSearch) {
+		t.Errorf("expected the removal to match the seller and search only; got %s", got)
+	}
+}
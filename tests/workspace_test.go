@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"vinted-scraper/internal/database"
+	"vinted-scraper/internal/server"
+	vintedscraper "vinted-scraper/internal/vinted-scraper"
+)
+
+func TestWorkspaces(t *testing.T) {
+	t.Setenv("STREAM_HEARTBEAT", "50ms")
+	fake := &fakeSearch{items: loadItems(t)}
+	db := backends(t)["sqlite"]
+	srv := server.New(db, server.WithSearch(fake.search), server.WithAuth())
+	ts := httptest.NewServer(srv.RegisterRoutes())
+	t.Cleanup(ts.Close)
+	ctx := context.Background()
+
+	sales, err := db.CreateWorkspace(ctx, "sales")
+	if err != nil {
+		t.Fatalf("error creating workspace. Err: %v", err)
+	}
+	if _, err := db.CreateWorkspace(ctx, "sales"); !errors.Is(err, database.ErrWorkspaceExists) {
+		t.Errorf("expected ErrWorkspaceExists; got %v", err)
+	}
+	ops, err := db.CreateAPIKey(ctx, database.APIKey{Name: "ops", Role: database.RoleAdmin})
+	if err != nil {
+		t.Fatalf("error creating API key. Err: %v", err)
+	}
+	team, err := db.CreateAPIKey(ctx, database.APIKey{WorkspaceID: sales.ID, Name: "sales", Role: database.RoleAdmin})
+	if err != nil {
+		t.Fatalf("error creating API key. Err: %v", err)
+	}
+	if ops.WorkspaceID != database.DefaultWorkspace {
+		t.Errorf("expected keys in the default workspace by default; got %d", ops.WorkspaceID)
+	}
+
+	var ws database.Workspace
+	doAuth(t, http.MethodGet, ts.URL+"/v1/workspace", team.Key, "", http.StatusOK, &ws)
+	if ws.ID != sales.ID || ws.Name != "sales" {
+		t.Errorf("expected the sales workspace; got %+v", ws)
+	}
+
+	// A topic is only listed for the workspaces that searched it, but its
+	// items are shared: the second workspace is served without a scrape.
+	var topics []database.Topic
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags", ops.Key, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/topics", ops.Key, "", http.StatusOK, &topics)
+	if len(topics) != 1 {
+		t.Fatalf("expected the searched topic; got %+v", topics)
+	}
+	topicURL := fmt.Sprintf("%s/v1/topics/%d", ts.URL, topics[0].ID)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/topics", team.Key, "", http.StatusOK, &topics)
+	if len(topics) != 0 {
+		t.Errorf("expected no topics in another workspace; got %+v", topics)
+	}
+	doAuth(t, http.MethodGet, topicURL, team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/search?q=bags", team.Key, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, topicURL, team.Key, "", http.StatusOK, nil)
+	if calls := fake.calls.Load(); calls != 1 {
+		t.Errorf("expected the search scraped once for both workspaces; got %d", calls)
+	}
+
+	// Saved searches, webhooks, jobs and keys belong to their workspace.
+	var search database.SavedSearch
+	doAuth(t, http.MethodPost, ts.URL+"/v1/saved-searches", ops.Key, `{"q": "bags"}`, http.StatusCreated, &search)
+	var hook database.Webhook
+	doAuth(t, http.MethodPost, ts.URL+"/v1/webhooks", ops.Key, `{"url": "http://example.com/hook"}`, http.StatusCreated, &hook)
+	var job scrapeJob
+	doAuth(t, http.MethodPost, ts.URL+"/v1/jobs", ops.Key, `{"q": "bags"}`, http.StatusAccepted, &job)
+	var searches []database.SavedSearch
+	doAuth(t, http.MethodGet, ts.URL+"/v1/saved-searches", team.Key, "", http.StatusOK, &searches)
+	var hooks []database.Webhook
+	doAuth(t, http.MethodGet, ts.URL+"/v1/webhooks", team.Key, "", http.StatusOK, &hooks)
+	var jobs []scrapeJob
+	doAuth(t, http.MethodGet, ts.URL+"/v1/jobs", team.Key, "", http.StatusOK, &jobs)
+	if len(searches) != 0 || len(hooks) != 0 || len(jobs) != 0 {
+		t.Errorf("expected nothing of another workspace; got %+v, %+v and %+v", searches, hooks, jobs)
+	}
+	doAuth(t, http.MethodDelete, fmt.Sprintf("%s/v1/saved-searches/%d", ts.URL, search.ID), team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodDelete, fmt.Sprintf("%s/v1/webhooks/%d", ts.URL, hook.ID), team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodPost, fmt.Sprintf("%s/v1/jobs/%d/cancel", ts.URL, job.ID), team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodDelete, fmt.Sprintf("%s/v1/keys/%d", ts.URL, ops.ID), team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodGet, fmt.Sprintf("%s/v1/saved-searches/%d", ts.URL, search.ID), ops.Key, "", http.StatusOK, nil)
+
+	var keys []database.APIKey
+	doAuth(t, http.MethodGet, ts.URL+"/v1/keys", team.Key, "", http.StatusOK, &keys)
+	if len(keys) != 1 || keys[0].ID != team.ID {
+		t.Errorf("expected only the workspace's key; got %+v", keys)
+	}
+	var entries []database.AuditEntry
+	doAuth(t, http.MethodGet, ts.URL+"/v1/audit", team.Key, "", http.StatusOK, &entries)
+	for _, entry := range entries {
+		if entry.WorkspaceID != sales.ID || entry.APIKeyID != team.ID {
+			t.Errorf("expected only the workspace's audit entries; got %+v", entry)
+		}
+	}
+	if len(entries) != 5 {
+		t.Errorf("expected the workspace's 5 audited requests; got %d", len(entries))
+	}
+
+	// Background work sees every workspace.
+	all, err := db.ListTopics(ctx, database.AllWorkspaces)
+	if err != nil || len(all) != 1 {
+		t.Errorf("expected the shared topic; got %+v (%v)", all, err)
+	}
+	// A caller that names no workspace only sees the default one.
+	if keys, err := db.ListAPIKeys(ctx, 0); err != nil || len(keys) != 1 || keys[0].ID != ops.ID {
+		t.Errorf("expected only the default workspace's key; got %+v (%v)", keys, err)
+	}
+
+	// Items, exports, brand stats and local searches only cover the topics
+	// a workspace sees. The private topic's items are listings the shared
+	// search never found.
+	var shared, radley []vintedscraper.Item
+	for _, item := range loadItems(t) {
+		if item.BrandTitle == "Radley" {
+			shared = append(shared, item)
+			item.ID += 1_000_000
+			item.Title, item.BrandTitle = "Private label bag", "Private Label"
+			radley = append(radley, item)
+		}
+	}
+	private, err := db.AddItems(radley, "radley", "")
+	if err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+	if err := db.AddWorkspaceTopic(ctx, database.DefaultWorkspace, private.TopicID); err != nil {
+		t.Fatalf("error adding workspace topic. Err: %v", err)
+	}
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{{ops.Key, len(radley)}, {team.Key, 0}} {
+		var items []vintedscraper.Item
+		doAuth(t, http.MethodGet, ts.URL+"/v1/items?brand=private+label", tc.key, "", http.StatusOK, &items)
+		var stats struct{ Count int }
+		doAuth(t, http.MethodGet, ts.URL+"/v1/brands/private%20label/stats", tc.key, "", http.StatusOK, &stats)
+		var found []database.SearchResult
+		doAuth(t, http.MethodGet, ts.URL+"/v1/search/local?q=private+label", tc.key, "", http.StatusOK, &found)
+		exported := exportLines(t, ts.URL+"/v1/export/items?brand=private+label", tc.key)
+		if len(items) != tc.want || stats.Count != tc.want || len(found) != tc.want || exported != tc.want {
+			t.Errorf("expected %d items; got %d items, %d in stats, %d found and %d exported", tc.want, len(items), stats.Count, len(found), exported)
+		}
+	}
+	var saved database.SavedSearch
+	doAuth(t, http.MethodPost, ts.URL+"/v1/saved-searches", team.Key, `{"q": "radley"}`, http.StatusCreated, &saved)
+	var savedItems []vintedscraper.Item
+	doAuth(t, http.MethodGet, fmt.Sprintf("%s/v1/saved-searches/%d/items", ts.URL, saved.ID), team.Key, "", http.StatusOK, &savedItems)
+	if len(savedItems) != 0 {
+		t.Errorf("expected a saved search not to reveal another workspace's topic; got %d items", len(savedItems))
+	}
+	// Scraping a shared listing again from the private topic leaves it in
+	// the shared topic too.
+	if _, err := db.AddItems(shared, "radley", ""); err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{{ops.Key, len(shared)}, {team.Key, len(shared)}} {
+		var items []vintedscraper.Item
+		doAuth(t, http.MethodGet, ts.URL+"/v1/items?brand=radley", tc.key, "", http.StatusOK, &items)
+		if len(items) != tc.want {
+			t.Errorf("expected %d items after a second topic scraped them; got %d", tc.want, len(items))
+		}
+	}
+	doAuth(t, http.MethodGet, ts.URL+"/v1/items?topic=radley", ops.Key, "", http.StatusOK, nil)
+	doAuth(t, http.MethodGet, ts.URL+"/v1/items?topic=radley", team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodGet, fmt.Sprintf("%s/v1/items?topic_id=%d", ts.URL, private.TopicID), team.Key, "", http.StatusNotFound, nil)
+	doAuth(t, http.MethodGet, fmt.Sprintf("%s/v1/export/items?topic_id=%d", ts.URL, private.TopicID), team.Key, "", http.StatusNotFound, nil)
+
+	// A seller's events only reach the workspaces that see the topic.
+	subscribe := func(key string) *websocket.Conn {
+		t.Helper()
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/v1/ws", http.Header{"Authorization": {"Bearer " + key}})
+		if err != nil {
+			t.Fatalf("error dialing WebSocket. Err: %v (%v)", err, resp)
+		}
+		t.Cleanup(func() { conn.Close() })
+		var m wsMessage
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"type": "subscribe", "seller_id": %d}`, radley[0].User.ID)))
+		if err := conn.ReadJSON(&m); err != nil || m.Type != "subscribed" {
+			t.Fatalf("expected the seller subscribed; got %+v (%v)", m, err)
+		}
+		return conn
+	}
+	opsConn, teamConn := subscribe(ops.Key), subscribe(team.Key)
+	changed := append([]vintedscraper.Item(nil), radley...)
+	price, _ := strconv.ParseFloat(changed[0].Price, 64)
+	changed[0].Price = strconv.FormatFloat(price/2, 'f', 2, 64)
+	if _, err := db.AddItems(changed, "radley", ""); err != nil {
+		t.Fatalf("error adding items. Err: %v", err)
+	}
+	var m wsMessage
+	_ = opsConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := opsConn.ReadJSON(&m); err != nil || m.Type != "price_change" || m.Event.Item.ID != radley[0].ID {
+		t.Errorf("expected the seller's price change; got %+v (%v)", m, err)
+	}
+	_ = teamConn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if err := teamConn.ReadJSON(&m); err == nil {
+		t.Errorf("expected no events of another workspace's topic; got %+v", m)
+	}
+}
+
+// exportLines returns the number of rows of an NDJSON export.
+func exportLines(t *testing.T, url, key string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("error building request. Err: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: expected status 200; got %v", url, resp.Status)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(resp.Body); scanner.Scan(); {
+		lines++
+	}
+	return lines
+}